@@ -0,0 +1,77 @@
+package zerotrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Aliases maps router interface addresses to the routers that they belong to,
+// as inferred by alias resolution.  Routers respond to trace packets from any
+// of their interfaces, so two traceroutes that pass through the same router
+// may see two different addresses at the same hop.  Aliases lets us tell such
+// hops apart from actual path changes.  Aliases is immutable, so it's safe for
+// concurrent use.
+type Aliases struct {
+	routers map[string]string
+}
+
+// NewAliases returns new aliases from the given map of interface addresses to
+// router IDs, which the caller must not modify afterwards.
+func NewAliases(routers map[string]string) *Aliases {
+	return &Aliases{routers: routers}
+}
+
+// ParseITDKNodes parses the given nodes file of a CAIDA ITDK snapshot, which
+// contains one router per line, e.g., "node N1:  192.0.2.1 198.51.100.1", and
+// returns a map of interface addresses to router IDs.  Empty lines and lines
+// starting with '#' are ignored.
+func ParseITDKNodes(r io.Reader) (map[string]string, error) {
+	var (
+		routers = make(map[string]string)
+		s       = bufio.NewScanner(r)
+	)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "node" || !strings.HasSuffix(fields[1], ":") {
+			return nil, fmt.Errorf("line %d: malformed node %q", lineNum, line)
+		}
+		id := strings.TrimSuffix(fields[1], ":")
+		for _, field := range fields[2:] {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid address %q", lineNum, field)
+			}
+			routers[ip.String()] = id
+		}
+	}
+	return routers, s.Err()
+}
+
+// Len returns the number of interface addresses that we know the router of.
+func (a *Aliases) Len() int {
+	if a == nil {
+		return 0
+	}
+	return len(a.routers)
+}
+
+// SameRouter returns true if the two given addresses are equal or interfaces
+// of the same router.  Nil or zero-value aliases only consider equal addresses
+// the same router.
+func (a *Aliases) SameRouter(x, y net.IP) bool {
+	if x.Equal(y) {
+		return true
+	}
+	if a == nil {
+		return false
+	}
+	id, exists := a.routers[x.String()]
+	return exists && a.routers[y.String()] == id
+}
@@ -0,0 +1,59 @@
+package zerotrace
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseITDKNodes(t *testing.T) {
+	for _, test := range []struct {
+		nodes   string
+		routers map[string]string
+		valid   bool
+	}{
+		{
+			"# ITDK nodes file.\n\nnode N1:  192.0.2.1 198.51.100.1\nnode N2:  2001:db8::1\n",
+			map[string]string{"192.0.2.1": "N1", "198.51.100.1": "N1", "2001:db8::1": "N2"},
+			true,
+		},
+		{"", map[string]string{}, true},
+		{"N1: 192.0.2.1", nil, false},
+		{"node N1 192.0.2.1", nil, false},
+		{"node N1:  foo", nil, false},
+	} {
+		routers, err := ParseITDKNodes(strings.NewReader(test.nodes))
+		assertEqual(t, err == nil, test.valid)
+		if !reflect.DeepEqual(routers, test.routers) {
+			t.Fatalf("Expected aliases %v but got %v.", test.routers, routers)
+		}
+	}
+}
+
+func TestAliasesSameRouter(t *testing.T) {
+	var (
+		a = net.ParseIP("192.0.2.1")
+		b = net.ParseIP("198.51.100.1")
+		c = net.ParseIP("203.0.113.1")
+		d = net.ParseIP("203.0.113.2")
+	)
+	aliases := NewAliases(map[string]string{a.String(): "N1", b.String(): "N1", c.String(): "N2"})
+	assertEqual(t, aliases.Len(), 3)
+
+	assertEqual(t, aliases.SameRouter(a, b), true)
+	assertEqual(t, aliases.SameRouter(a, c), false)
+	assertEqual(t, aliases.SameRouter(d, d), true)
+	// Addresses that we know no router of are only aliases of themselves.
+	assertEqual(t, aliases.SameRouter(d, net.ParseIP("203.0.113.3")), false)
+
+	// Nil aliases only consider equal addresses the same router.
+	var nilAliases *Aliases
+	assertEqual(t, nilAliases.SameRouter(a, a), true)
+	assertEqual(t, nilAliases.SameRouter(a, b), false)
+	assertEqual(t, nilAliases.Len(), 0)
+	// So do zero-value aliases.
+	assertEqual(t, (&Aliases{}).SameRouter(a, a), true)
+	assertEqual(t, (&Aliases{}).SameRouter(a, b), false)
+	assertEqual(t, (&Aliases{}).Len(), 0)
+}
@@ -0,0 +1,99 @@
+package zerotrace
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// The following types mirror RIPE Atlas's traceroute result format, so our
+// traceroutes can be merged with Atlas data and analyzed by the same code:
+// https://atlas.ripe.net/docs/apis/result-format/
+// Fields that we have no data for are omitted.
+
+type atlasReply struct {
+	From    string      `json:"from,omitempty"`
+	RTT     float64     `json:"rtt,omitempty"`
+	Err     interface{} `json:"err,omitempty"`
+	Timeout string      `json:"x,omitempty"`
+}
+
+type atlasHop struct {
+	Hop    int          `json:"hop"`
+	Result []atlasReply `json:"result"`
+}
+
+type atlasTraceroute struct {
+	Type      string     `json:"type"`
+	AF        int        `json:"af"`
+	Proto     string     `json:"proto"`
+	SrcAddr   string     `json:"src_addr"`
+	DstAddr   string     `json:"dst_addr"`
+	DstName   string     `json:"dst_name"`
+	Size      int        `json:"size"`
+	ParisID   int        `json:"paris_id"`
+	Timestamp int64      `json:"timestamp"`
+	EndTime   int64      `json:"endtime"`
+	Result    []atlasHop `json:"result"`
+}
+
+// atlasUnreachErrs maps ICMP destination unreachable codes to the error
+// strings that RIPE Atlas uses.
+var atlasUnreachErrs = map[uint8]string{
+	0:  "N", // Network unreachable.
+	1:  "H", // Host unreachable.
+	2:  "P", // Protocol unreachable.
+	3:  "p", // Port unreachable.
+	13: "A", // Administratively prohibited.
+}
+
+// atlasErr returns the RIPE Atlas error for the given probe, or nil if the
+// probe was answered by an ICMP time exceeded message.
+func atlasErr(p *Probe) interface{} {
+	const icmpUnreach = 3
+	if p.ICMPType != icmpUnreach {
+		return nil
+	}
+	if err, exists := atlasUnreachErrs[p.ICMPCode]; exists {
+		return err
+	}
+	return int(p.ICMPCode)
+}
+
+// WriteAtlasJSON writes the result to the given writer as a single line of
+// JSON in RIPE Atlas's traceroute result format.
+func (r *Result) WriteAtlasJSON(w io.Writer) error {
+	end := r.Start
+	t := atlasTraceroute{
+		Type:      "traceroute",
+		AF:        4,
+		Proto:     "TCP",
+		SrcAddr:   r.Src.String(),
+		DstAddr:   r.Dst.String(),
+		DstName:   r.Dst.String(),
+		Size:      r.probeSize(),
+		Timestamp: r.Start.Unix(),
+		Result:    []atlasHop{},
+	}
+	for _, h := range r.Hops {
+		hop := atlasHop{Hop: h.TTL, Result: []atlasReply{}}
+		for _, p := range h.Probes {
+			if !p.Answered() {
+				hop.Result = append(hop.Result, atlasReply{Timeout: "*"})
+				continue
+			}
+			if done := p.Sent.Add(p.RTT); done.After(end) {
+				end = done
+			}
+			hop.Result = append(hop.Result, atlasReply{
+				From: p.From.String(),
+				RTT:  float64(p.RTT) / float64(time.Millisecond),
+				Err:  atlasErr(p),
+			})
+		}
+		t.Result = append(t.Result, hop)
+	}
+	t.EndTime = end.Unix()
+
+	return json.NewEncoder(w).Encode(t)
+}
@@ -0,0 +1,55 @@
+package zerotrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteAtlasJSON(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		start = time.Unix(1700000000, 0).UTC()
+		hop   = net.ParseIP("10.0.0.1")
+		dst   = net.ParseIP(dstAddr)
+	)
+	r := &Result{
+		Start: start,
+		Src:   net.ParseIP(srcAddr),
+		Dst:   dst,
+		Hops: []*Hop{
+			{TTL: 1, Probes: []*Probe{
+				{Sent: start, RTT: time.Millisecond, From: hop, ICMPType: 11},
+				{Sent: start},
+			}},
+			{TTL: 2, Probes: []*Probe{
+				{Sent: start.Add(time.Second), RTT: time.Second, From: dst, ICMPType: 3, ICMPCode: 3},
+				{Sent: start, RTT: time.Millisecond, From: dst, ICMPType: 3, ICMPCode: 9},
+			}},
+		},
+	}
+	failOnErr(t, r.WriteAtlasJSON(&buf))
+
+	var tr atlasTraceroute
+	failOnErr(t, json.Unmarshal(buf.Bytes(), &tr))
+	assertEqual(t, tr.Type, "traceroute")
+	assertEqual(t, tr.AF, 4)
+	assertEqual(t, tr.Proto, "TCP")
+	assertEqual(t, tr.SrcAddr, srcAddr)
+	assertEqual(t, tr.DstAddr, dstAddr)
+	assertEqual(t, tr.Timestamp, int64(1700000000))
+	assertEqual(t, tr.EndTime, int64(1700000002))
+	assertEqual(t, len(tr.Result), 2)
+
+	assertEqual(t, tr.Result[0].Hop, 1)
+	assertEqual(t, tr.Result[0].Result[0].From, hop.String())
+	assertEqual(t, tr.Result[0].Result[0].RTT, 1.0)
+	assertEqual(t, tr.Result[0].Result[0].Err, nil)
+	assertEqual(t, tr.Result[0].Result[1].Timeout, "*")
+
+	assertEqual(t, tr.Result[1].Result[0].Err, "p")
+	// Unknown codes are reported as numbers, which JSON decodes as float64.
+	assertEqual(t, tr.Result[1].Result[1].Err, 9.0)
+}
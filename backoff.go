@@ -0,0 +1,82 @@
+package zerotrace
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// backoffMemory is the time for which we remember the probe interval that we
+// backed off to toward a destination, after its last rate-limited traceroute.
+const backoffMemory = 10 * time.Minute
+
+// backoffEntry is the probe interval that we backed off to toward a
+// destination, and when we last did so.
+type backoffEntry struct {
+	interval time.Duration
+	updated  time.Time
+}
+
+// probeBackoff remembers the probe intervals that we backed off to toward
+// each destination, so the next call to Trace toward a destination paces its
+// trace packets like the previous call ended up doing, instead of starting
+// over with the configured interval.  It's safe for concurrent use.  A nil
+// probeBackoff remembers nothing.
+type probeBackoff struct {
+	sync.Mutex // Guards all fields.
+	intervals  map[string]backoffEntry
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// newProbeBackoff returns a new probeBackoff, or nil if the given maximum
+// probe interval disables backing off.
+func newProbeBackoff(maxInterval time.Duration) *probeBackoff {
+	if maxInterval <= 0 {
+		return nil
+	}
+	return &probeBackoff{
+		intervals: make(map[string]backoffEntry),
+		now:       time.Now,
+	}
+}
+
+// interval returns the probe interval for the next traceroute toward the given
+// address: the interval that we backed off to, unless we didn't back off
+// recently or the given interval is larger.
+func (b *probeBackoff) interval(ip net.IP, cur time.Duration) time.Duration {
+	if b == nil {
+		return cur
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	e, ok := b.intervals[ip.String()]
+	if !ok || b.now().Sub(e.updated) >= backoffMemory {
+		return cur
+	}
+	return max(e.interval, cur)
+}
+
+// remember records that we backed off to the given probe interval toward the
+// given address.
+func (b *probeBackoff) remember(ip net.IP, interval time.Duration) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+	// Forget destinations that we haven't backed off toward in a while, so
+	// we don't accumulate all destinations that we ever traced.
+	if now.Sub(b.lastSweep) >= backoffMemory {
+		for addr, e := range b.intervals {
+			if now.Sub(e.updated) >= backoffMemory {
+				delete(b.intervals, addr)
+			}
+		}
+		b.lastSweep = now
+	}
+	b.intervals[ip.String()] = backoffEntry{interval: interval, updated: now}
+}
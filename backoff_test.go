@@ -0,0 +1,36 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeBackoff(t *testing.T) {
+	var (
+		now = time.Now()
+		b   = newProbeBackoff(time.Second)
+		ip  = net.ParseIP("192.0.2.1")
+	)
+	b.now = func() time.Time { return now }
+
+	// Without backing off, we use the given interval.
+	assertEqual(t, b.interval(ip, time.Millisecond), time.Millisecond)
+	b.remember(ip, 40*time.Millisecond)
+	assertEqual(t, b.interval(ip, time.Millisecond), 40*time.Millisecond)
+	assertEqual(t, b.interval(ip, time.Second), time.Second)
+	// Other destinations are unaffected.
+	assertEqual(t, b.interval(net.ParseIP("192.0.2.2"), 0), time.Duration(0))
+
+	// Once we didn't back off for a while, the interval is forgotten.
+	now = now.Add(backoffMemory)
+	assertEqual(t, b.interval(ip, 0), time.Duration(0))
+	b.remember(net.ParseIP("198.51.100.1"), 20*time.Millisecond)
+	assertEqual(t, len(b.intervals), 1)
+
+	// A nil probeBackoff remembers nothing.
+	b = newProbeBackoff(0)
+	assertEqual(t, b == nil, true)
+	b.remember(ip, time.Second)
+	assertEqual(t, b.interval(ip, 0), time.Duration(0))
+}
@@ -0,0 +1,99 @@
+package zerotrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// ErrBlocked is returned if we're asked to trace a destination that's on
+	// the blocklist.
+	ErrBlocked = errors.New("destination is on the blocklist")
+)
+
+// Blocklist holds the networks that we never send trace packets to, e.g.,
+// bogons, our own infrastructure, or networks whose operators asked us not to
+// probe them.  A Blocklist is safe for concurrent use, and its networks can be
+// replaced while traceroutes are running.
+type Blocklist struct {
+	nets atomic.Pointer[[]*net.IPNet]
+}
+
+// NewBlocklist returns a new blocklist that contains the given networks.
+func NewBlocklist(nets []*net.IPNet) *Blocklist {
+	b := &Blocklist{}
+	b.Set(nets)
+	return b
+}
+
+// ParseBlocklist parses the given blocklist, which contains one network in
+// CIDR notation (or a single address) per line.  Empty lines and lines
+// starting with '#' are ignored.
+func ParseBlocklist(r io.Reader) ([]*net.IPNet, error) {
+	var (
+		nets []*net.IPNet
+		s    = bufio.NewScanner(r)
+	)
+	for lineNum := 1; s.Scan(); lineNum++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			ip := net.ParseIP(line)
+			if ip == nil {
+				return nil, fmt.Errorf("line %d: invalid address %q", lineNum, line)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, s.Err()
+}
+
+// Set atomically replaces the blocklist's networks with the given networks.
+func (b *Blocklist) Set(nets []*net.IPNet) {
+	b.nets.Store(&nets)
+}
+
+// list returns the blocklist's networks.  A nil blocklist, or one that was
+// never set, e.g., a zero-value Blocklist, has no networks.
+func (b *Blocklist) list() []*net.IPNet {
+	if b == nil {
+		return nil
+	}
+	if nets := b.nets.Load(); nets != nil {
+		return *nets
+	}
+	return nil
+}
+
+// Len returns the number of networks on the blocklist.
+func (b *Blocklist) Len() int {
+	return len(b.list())
+}
+
+// Contains returns true if the given address is in one of the blocklist's
+// networks.  A nil or zero-value blocklist contains nothing.
+func (b *Blocklist) Contains(ip net.IP) bool {
+	for _, n := range b.list() {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
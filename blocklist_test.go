@@ -0,0 +1,69 @@
+package zerotrace
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	nets, err := ParseBlocklist(strings.NewReader(`
+# Bogons.
+10.0.0.0/8
+192.168.0.0/16
+
+# A partner who asked not to be probed.
+198.51.100.7
+2001:db8::/32
+`))
+	failOnErr(t, err)
+	assertEqual(t, len(nets), 4)
+	assertEqual(t, nets[2].String(), "198.51.100.7/32")
+
+	if _, err := ParseBlocklist(strings.NewReader("10.0.0.0/33")); err == nil {
+		t.Fatal("Expected error for invalid network.")
+	}
+	if _, err := ParseBlocklist(strings.NewReader("foo")); err == nil {
+		t.Fatal("Expected error for invalid address.")
+	}
+}
+
+func TestBlocklistContains(t *testing.T) {
+	nets, err := ParseBlocklist(strings.NewReader("10.0.0.0/8\n198.51.100.7"))
+	failOnErr(t, err)
+	b := NewBlocklist(nets)
+	assertEqual(t, b.Len(), 2)
+
+	assertEqual(t, b.Contains(net.ParseIP("10.1.2.3")), true)
+	assertEqual(t, b.Contains(net.ParseIP("198.51.100.7")), true)
+	assertEqual(t, b.Contains(net.ParseIP("198.51.100.8")), false)
+
+	// Replacing the networks takes effect immediately.
+	b.Set(nil)
+	assertEqual(t, b.Contains(net.ParseIP("10.1.2.3")), false)
+
+	// A nil blocklist contains nothing.
+	var nilList *Blocklist
+	assertEqual(t, nilList.Contains(net.ParseIP("10.1.2.3")), false)
+	assertEqual(t, nilList.Len(), 0)
+	// Neither does a zero-value blocklist.
+	assertEqual(t, (&Blocklist{}).Contains(net.ParseIP("10.1.2.3")), false)
+	assertEqual(t, (&Blocklist{}).Len(), 0)
+}
+
+func TestTraceBlocked(t *testing.T) {
+	cfg := NewDefaultConfig()
+	_, n, err := net.ParseCIDR("10.0.0.0/8")
+	failOnErr(t, err)
+	cfg.Blocklist = NewBlocklist([]*net.IPNet{n})
+	z := NewZeroTrace(cfg)
+
+	// The mock connection's remote end is 10.0.0.2.
+	if _, err := z.Trace(&mockConn{}); err != ErrBlocked {
+		t.Fatalf("Expected error %v but got %v.", ErrBlocked, err)
+	}
+	// We refuse to even dial blocklisted addresses.
+	if _, err := z.TraceAddr("10.0.0.2:443"); err != ErrBlocked {
+		t.Fatalf("Expected error %v but got %v.", ErrBlocked, err)
+	}
+}
@@ -0,0 +1,128 @@
+package zerotrace
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	// loopbackIface is the interface that we measure our self-latency on.
+	loopbackIface = "lo"
+	// calibrationPort is the port that we send calibration probes to.  It's
+	// the discard port, which we expect to be closed, so the kernel answers
+	// with a RST.
+	calibrationPort    = 9
+	calibrationProbes  = 3
+	calibrationTimeout = time.Second
+)
+
+var (
+	errNoCalibrationReply = errors.New("no reply to calibration probe")
+)
+
+// measureSelfLatency returns our self-latency: the RTT of a probe that never
+// leaves the box.  We send TCP segments to a closed port on the loopback
+// interface and take the time between each segment's send timestamp and the
+// capture timestamp of the kernel's RST, just like we do for trace packets.
+// The lowest of these RTTs is the overhead of our send and capture path, which
+// is non-negligible for nearby clients if the box is loaded.
+func (z *ZeroTrace) measureSelfLatency() (time.Duration, error) {
+	hdl, err := pcap.OpenLive(loopbackIface, 128, false, z.cfg.PktBufTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer hdl.Close()
+	filter := fmt.Sprintf("tcp src port %d and tcp[tcpflags] & tcp-rst != 0", calibrationPort)
+	if err := hdl.SetBPFFilter(filter); err != nil {
+		return 0, err
+	}
+
+	var (
+		selfLatency time.Duration
+		loopback    = net.IPv4(127, 0, 0, 1)
+	)
+	for i := 0; i < calibrationProbes; i++ {
+		srcPort := uint16(32768 + rand.Intn(28232)) // Linux's ephemeral ports.
+		payload, err := createSegment(loopback, loopback, srcPort, calibrationPort, 0)
+		if err != nil {
+			return 0, err
+		}
+		hdr := newIpv4Header(64, 0, loopback, len(payload))
+		if err := z.rawConn.WriteTo(hdr, payload, nil); err != nil {
+			return 0, err
+		}
+		sent := time.Now().UTC()
+		recvd, err := awaitReset(hdl, hdl.LinkType().LayerType(), srcPort, sent.Add(calibrationTimeout))
+		if err != nil {
+			return 0, err
+		}
+		if rtt := recvd.Sub(sent); i == 0 || rtt < selfLatency {
+			selfLatency = rtt
+		}
+	}
+	return selfLatency, nil
+}
+
+// awaitReset reads packets from the given source until it encounters the RST
+// that answers our calibration probe from the given port, and returns the
+// RST's capture timestamp.  We give up at the given deadline.
+func awaitReset(
+	src gopacket.ZeroCopyPacketDataSource,
+	first gopacket.LayerType,
+	port uint16,
+	deadline time.Time,
+) (time.Time, error) {
+	for time.Now().UTC().Before(deadline) {
+		data, ci, err := src.ZeroCopyReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		pkt := gopacket.NewPacket(data, first, gopacket.NoCopy)
+		tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			continue
+		}
+		if tcp.RST && tcp.SrcPort == calibrationPort && uint16(tcp.DstPort) == port {
+			return ci.Timestamp, nil
+		}
+	}
+	return time.Time{}, errNoCalibrationReply
+}
+
+// calibrate measures our self-latency right away and then at the given
+// interval until the ZeroTrace object is closed.
+func (z *ZeroTrace) calibrate(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		selfLatency, err := z.measureSelfLatency()
+		if err != nil {
+			l.Printf("Error measuring self-latency: %v", err)
+		} else {
+			l.Printf("Measured self-latency: %s", selfLatency)
+			z.selfLatency.Store(int64(selfLatency))
+		}
+		select {
+		case <-z.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SelfLatency returns our most recently measured self-latency, or zero if we
+// haven't measured it (see Config.CalibrationInterval).
+func (z *ZeroTrace) SelfLatency() time.Duration {
+	return time.Duration(z.selfLatency.Load())
+}
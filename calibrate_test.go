@@ -0,0 +1,54 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newResetPkt returns a RST from our calibration port to the given port.
+func newResetPkt(t *testing.T, port uint16) []byte {
+	t.Helper()
+	loopback := net.IPv4(127, 0, 0, 1)
+	ip := &layers.IPv4{
+		Version:  ipv4Version,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    loopback,
+		DstIP:    loopback,
+	}
+	tcp := &layers.TCP{
+		SrcPort: calibrationPort,
+		DstPort: layers.TCPPort(port),
+		RST:     true,
+	}
+	failOnErr(t, tcp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	failOnErr(t, gopacket.SerializeLayers(buf, opts, ip, tcp))
+	return buf.Bytes()
+}
+
+func TestAwaitReset(t *testing.T) {
+	deadline := time.Now().UTC().Add(time.Second)
+	src := &mockSource{pkts: [][]byte{
+		newIcmpPkt(t, dummyAddr, 1), // Not a TCP segment.
+		newResetPkt(t, 1234),        // Answers another probe.
+		newResetPkt(t, 4321),
+	}}
+	_, err := awaitReset(src, layers.LayerTypeIPv4, 4321, deadline)
+	failOnErr(t, err)
+	assertEqual(t, len(src.pkts), 0)
+
+	// We give up once the source is exhausted or the deadline passed.
+	src = &mockSource{pkts: [][]byte{newResetPkt(t, 1234)}}
+	if _, err := awaitReset(src, layers.LayerTypeIPv4, 4321, deadline); err == nil {
+		t.Fatal("Expected error if no RST answers our probe.")
+	}
+	src = &mockSource{pkts: [][]byte{newResetPkt(t, 4321)}}
+	_, err = awaitReset(src, layers.LayerTypeIPv4, 4321, time.Now().UTC())
+	assertEqual(t, err, errNoCalibrationReply)
+}
@@ -0,0 +1,303 @@
+package zerotrace
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+var (
+	// captureMgrs maps network interface names to the capture manager that
+	// owns the interface's pcap handle.
+	captureMgrs      = make(map[string]*captureManager)
+	captureMgrsMutex sync.Mutex // Guards captureMgrs and captureManager.refs.
+)
+
+// subscription represents a traceroute's request to receive the ICMP
+// responses to the given flow's trace packets.  The event loop closes done once
+// the pcap handle's BPF filter lets the flow's packets through.
+type subscription struct {
+	r    receiver
+	f    *flow
+	done chan struct{}
+}
+
+// captureManager owns the pcap handle of a single network interface.  All
+// ZeroTrace objects that listen on the same interface share a capture manager,
+// which runs a single event loop that demultiplexes captured ICMP packets to
+// the traceroutes that registered with it.
+type captureManager struct {
+	iface    string
+	refs     int
+	snapLen  int32
+	timeout  time.Duration
+	tsSource string
+	pcapMu   sync.Mutex // Guards pcap and filter.
+	pcap     *pcap.Handle
+	filter   string
+	pkts     chan *respPkt
+	ipids    *ipIdPool
+	quit     chan struct{}
+	incoming chan *subscription
+	outgoing chan receiver
+	// dumps is the number of traceroutes that dump their packets to a pcap
+	// file.  While it's non-zero, we keep a copy of each captured packet.
+	dumps atomic.Int32
+	// heartbeat is the time (in Unix nanoseconds) of the read loop's most
+	// recent successful read or buffer timeout, which the supervisor uses to
+	// detect stalls.
+	heartbeat atomic.Int64
+	// restarts is the number of times that the supervisor restarted the read
+	// loop.
+	restarts atomic.Int64
+	// receivers is the number of receivers that are registered with the
+	// event loop.
+	receivers atomic.Int32
+}
+
+// newCaptureManager returns a new capture manager for the given interface.
+// The manager is not yet listening for packets.
+func newCaptureManager(iface string) *captureManager {
+	return &captureManager{
+		iface:    iface,
+		pkts:     make(chan *respPkt),
+		ipids:    newIpIdPool(),
+		quit:     make(chan struct{}),
+		incoming: make(chan *subscription),
+		outgoing: make(chan receiver),
+	}
+}
+
+// acquireCaptureManager returns the capture manager for the given interface,
+// creating it and opening its pcap handle if no other ZeroTrace object uses
+// the interface yet.  Note that the snap length, buffer timeout, and timestamp
+// source only take effect for the first caller.  Callers must call release
+// when they no longer need the capture manager.  Unless the given stall
+// timeout is zero, a supervisor restarts the capture if it stalls.
+func acquireCaptureManager(
+	iface string,
+	snapLen int32,
+	timeout time.Duration,
+	tsSource string,
+	stallTimeout time.Duration,
+) (*captureManager, error) {
+	captureMgrsMutex.Lock()
+	defer captureMgrsMutex.Unlock()
+
+	if m, exists := captureMgrs[iface]; exists {
+		m.refs++
+		return m, nil
+	}
+
+	hdl, err := openPcap(iface, snapLen, timeout, tsSource, bpfNoFlows)
+	if err != nil {
+		return nil, err
+	}
+	m := newCaptureManager(iface)
+	m.snapLen, m.timeout, m.tsSource = snapLen, timeout, tsSource
+	m.pcap, m.filter = hdl, bpfNoFlows
+	m.refs = 1
+	captureMgrs[iface] = m
+
+	readDone := m.startReading(hdl)
+	go m.listen(m.pkts)
+	if stallTimeout > 0 {
+		go m.supervise(readDone, stallTimeout)
+	}
+
+	return m, nil
+}
+
+// release gives up the caller's reference to the capture manager.  Once the
+// last reference is gone, the manager stops its event loop and closes its pcap
+// handle.
+func (m *captureManager) release() {
+	captureMgrsMutex.Lock()
+	defer captureMgrsMutex.Unlock()
+
+	m.refs--
+	if m.refs > 0 {
+		return
+	}
+	delete(captureMgrs, m.iface)
+	close(m.quit)
+	m.pcapMu.Lock()
+	defer m.pcapMu.Unlock()
+	if m.pcap != nil {
+		// The supervisor may have failed to re-open the handle.
+		m.pcap.Close()
+	}
+}
+
+// register instructs the capture manager to send a copy of newly-captured ICMP
+// responses to the given flow's trace packets, and of the flow's client's TCP
+// segments, to the given receiver.  It returns once the BPF filter that lets
+// the flow's packets through is installed, so the caller may start sending
+// trace packets.
+func (m *captureManager) register(r receiver, f *flow) {
+	s := &subscription{r: r, f: f, done: make(chan struct{})}
+	m.incoming <- s
+	<-s.done
+}
+
+// unregister instructs the capture manager to stop sending newly-captured ICMP
+// responses to the given receiver.
+func (m *captureManager) unregister(r receiver) {
+	m.outgoing <- r
+}
+
+// startDump instructs the capture manager to keep a copy of each captured
+// packet until the caller calls stopDump.
+func (m *captureManager) startDump() {
+	m.dumps.Add(1)
+}
+
+// stopDump undoes startDump.
+func (m *captureManager) stopDump() {
+	m.dumps.Add(-1)
+}
+
+// updateFilter narrows the pcap handle's BPF filter down to the ICMP responses
+// and client TCP segments of the given receivers' flows, so we don't have to
+// capture and parse unrelated packets.  If the kernel rejects the filter, we
+// fall back to a filter that lets all flows' packets through.
+func (m *captureManager) updateFilter(receivers map[receiver]*flow) {
+	m.pcapMu.Lock()
+	defer m.pcapMu.Unlock()
+
+	if m.pcap == nil {
+		return
+	}
+	flows := []*flow{}
+	for _, f := range receivers {
+		flows = append(flows, f)
+	}
+	m.filter = bpfFilter(flows)
+	if err := m.pcap.SetBPFFilter(m.filter); err != nil {
+		l.Printf("Error setting BPF filter for %d flow(s), falling back to %q: %v",
+			len(flows), bpfAllFlows, err)
+		m.filter = bpfAllFlows
+		if err := m.pcap.SetBPFFilter(m.filter); err != nil {
+			l.Printf("Error setting fallback BPF filter: %v", err)
+		}
+	}
+}
+
+// The minimum and maximum time that the read loop waits after a read error.
+const (
+	minReadBackoff = 10 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+// read reads packets from the given source, decodes them, and writes the
+// resulting response packets to the given channel.  We use zero-copy reads
+// because the decoder doesn't hold on to packet data.  The function returns
+// once the source is exhausted, e.g., because its pcap handle was closed.
+// After a read error, we wait before reading again, twice as long for each
+// consecutive error.  Only successful reads (and buffer timeouts) count as
+// heartbeat, so if the errors persist, the supervisor restarts the capture.
+func (m *captureManager) read(
+	src gopacket.ZeroCopyPacketDataSource,
+	dec *icmpDecoder,
+	pkts chan *respPkt,
+) {
+	backoff := time.Duration(0)
+	for {
+		data, ci, err := src.ZeroCopyReadPacketData()
+		if err == io.EOF {
+			return
+		}
+		if err != nil && err != pcap.NextErrorTimeoutExpired {
+			backoff = min(max(2*backoff, minReadBackoff), maxReadBackoff)
+			l.Printf("Error reading packet (retrying in %s): %v", backoff, err)
+			select {
+			case <-m.quit:
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		m.heartbeat.Store(time.Now().UnixNano())
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		dec.keepRaw = m.dumps.Load() > 0
+		respPkt, err := dec.decode(data, ci)
+		if err != nil {
+			l.Printf("Error parsing ICMP packet: %v", err)
+			continue
+		}
+		respPkt.recvdMono = time.Now()
+		select {
+		case <-m.quit:
+			return
+		case pkts <- respPkt:
+		}
+	}
+}
+
+// listen processes newly-captured ICMP packets.  New traceroutes register
+// themselves with this function's event loop to receive a copy of
+// newly-captured ICMP packets.
+func (m *captureManager) listen(pktStream chan *respPkt) {
+	var (
+		ticker    = time.NewTicker(3 * time.Second)
+		receivers = make(map[receiver]*flow)
+	)
+	defer ticker.Stop()
+
+	l.Printf("Starting listening loop on %s.", m.iface)
+	defer l.Printf("Leaving listening loop on %s.", m.iface)
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+			m.ipids.releaseUnanswered()
+		case s := <-m.incoming:
+			receivers[s.r] = s.f
+			m.receivers.Store(int32(len(receivers)))
+			m.updateFilter(receivers)
+			close(s.done)
+		case r := <-m.outgoing:
+			delete(receivers, r)
+			m.receivers.Store(int32(len(receivers)))
+			m.updateFilter(receivers)
+		case respPkt := <-pktStream:
+			if respPkt.fromClient {
+				// The client's TCP segments only concern the client's flow.
+				for r, f := range receivers {
+					if f.isFromClient(respPkt) && len(r) < cap(r) {
+						r <- respPkt
+					}
+				}
+				continue
+			}
+			if respPkt.outgoing {
+				// Our trace packets only concern their own flow, and
+				// mustn't crowd the responses out of other traceroutes'
+				// receivers.
+				for r, f := range receivers {
+					if f.isOutgoing(respPkt) && len(r) < cap(r) {
+						r <- respPkt
+					}
+				}
+				continue
+			}
+			m.ipids.release(respPkt.ipID)
+			// Fan-out new packet to all running traceroutes.
+			for r := range receivers {
+				// A receiver's channel may be full if the receiver is done with
+				// the scan and has already exited its event loop.
+				if len(r) < cap(r) {
+					r <- respPkt
+				}
+			}
+		}
+	}
+}
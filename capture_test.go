@@ -0,0 +1,103 @@
+package zerotrace
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// mockSource mocks a pcap handle by returning the given packets, followed by
+// io.EOF.
+type mockSource struct {
+	pkts [][]byte
+}
+
+func (s *mockSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(s.pkts) == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	pkt := s.pkts[0]
+	s.pkts = s.pkts[1:]
+	return pkt, gopacket.CaptureInfo{Timestamp: time.Now().UTC()}, nil
+}
+
+func TestCaptureManagerRead(t *testing.T) {
+	var (
+		m    = newCaptureManager("dummy")
+		pkts = make(chan *respPkt, 2)
+		src  = &mockSource{pkts: [][]byte{
+			newIcmpPkt(t, dummyAddr, 1),
+			{0x00}, // Garbage that fails to decode.
+			newIcmpPkt(t, dummyAddr, 2),
+		}}
+	)
+
+	m.read(src, newIcmpDecoder(layers.LayerTypeIPv4), pkts)
+	assertEqual(t, len(pkts), 2)
+	assertEqual(t, (<-pkts).ipID, uint16(1))
+	assertEqual(t, (<-pkts).ipID, uint16(2))
+}
+
+func TestCaptureManagerFanOut(t *testing.T) {
+	var (
+		m         = newCaptureManager("dummy")
+		pktStream = make(chan *respPkt)
+		r1        = make(receiver, 1)
+		r2        = make(receiver, 1)
+	)
+	go m.listen(pktStream)
+	defer close(m.quit)
+
+	ipID, err := m.ipids.borrow()
+	failOnErr(t, err)
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+
+	m.register(r1, f)
+	m.register(r2, f)
+	// Registration is complete by the time that register returns.
+	assertEqual(t, m.receivers.Load(), int32(2))
+	pktStream <- &respPkt{ipID: ipID, recvdFrom: dummyAddr}
+	for _, r := range []receiver{r1, r2} {
+		assertEqual(t, (<-r).ipID, ipID)
+	}
+	// The response packet must have returned the IP ID to the pool.
+	assertEqual(t, m.ipids.size(), 0)
+
+	// Our captured trace packets only go to the receivers of their flow,
+	// and keep their IP ID borrowed.
+	var (
+		other = *f
+		r3    = make(receiver, 1)
+	)
+	other.dstPort++
+	m.register(r3, &other)
+	ipID, err = m.ipids.borrow()
+	failOnErr(t, err)
+	pktStream <- &respPkt{
+		outgoing:   true,
+		ipID:       ipID,
+		recvdPort:  f.srcPort,
+		sentTo:     f.dstIP,
+		sentToPort: f.dstPort,
+	}
+	for _, r := range []receiver{r1, r2} {
+		assertEqual(t, (<-r).outgoing, true)
+	}
+	m.register(r3, &other)
+	assertEqual(t, len(r3), 0)
+	assertEqual(t, m.ipids.size(), 1)
+	m.unregister(r3)
+
+	// Once unregistered, a receiver must no longer see packets.
+	m.unregister(r2)
+	pktStream <- &respPkt{ipID: ipID, recvdFrom: dummyAddr}
+	<-r1
+	// The event loop has processed the packet by the time the following
+	// registration is accepted.
+	m.register(r1, f)
+	assertEqual(t, len(r2), 0)
+}
@@ -0,0 +1,78 @@
+// Command zerotrace-client runs the WebSocket measurement flow against a
+// deployed ZeroTrace server (see the example directory) without a browser.  It
+// answers the server's ping messages, which keeps the TCP connection busy while
+// the server runs its 0trace measurement, and prints the server's JSON result.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+var (
+	l = log.New(os.Stderr, "zerotrace-client: ", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile)
+)
+
+func main() {
+	var (
+		endpoint, cfgURL string
+		challengeURL     string
+		count            int
+		interval         time.Duration
+		timeout          time.Duration
+	)
+	flag.StringVar(&endpoint, "endpoint", "", "The server's WebSocket endpoint, e.g. wss://example.com:8443/wss")
+	flag.StringVar(&cfgURL, "config", "", "URL of the server's client configuration, e.g. https://example.com:8443/config, which sets -endpoint and -timeout unless given")
+	flag.DurationVar(&timeout, "timeout", 2*time.Minute, "Time after which we give up on a measurement (default: 2m)")
+	flag.IntVar(&count, "count", 1, "Number of measurements to run; 0 loops forever (default: 1)")
+	flag.DurationVar(&interval, "interval", time.Minute, "Time to wait between measurements (default: 1m)")
+	flag.Parse()
+
+	// The server's configuration fills in the flags that we weren't given.
+	if cfgURL != "" {
+		cfg, err := client.FetchConfig(context.Background(), cfgURL)
+		if err != nil {
+			l.Fatalf("Error fetching client configuration: %v", err)
+		}
+		set := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["endpoint"] {
+			endpoint = cfg.WssEndpoint
+		}
+		// A non-positive timeout would fail each measurement right away.
+		if !set["timeout"] && cfg.TimeoutMs > 0 {
+			timeout = cfg.Timeout()
+		}
+		challengeURL = cfg.ChallengeEndpoint
+	}
+	if endpoint == "" {
+		l.Fatal("Specify WebSocket endpoint by using the -endpoint or -config flag.")
+	}
+
+	c := client.New(endpoint).WithChallenge(challengeURL)
+	for i := 0; count == 0 || i < count; i++ {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		result, err := c.Measure(ctx)
+		cancel()
+		if err != nil {
+			l.Printf("Error running measurement: %v", err)
+			continue
+		}
+		out, err := json.Marshal(result)
+		if err != nil {
+			l.Printf("Error encoding result: %v", err)
+			continue
+		}
+		fmt.Println(string(out))
+	}
+}
@@ -1,42 +1,279 @@
 package zerotrace
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxPayloadSize is the largest TCP payload that fits into an IPv4 packet
+// along with the 20-byte IP and TCP headers of our trace packets.
+const maxPayloadSize = 0xffff - 20 - 20
+
+var errInvalidPayloadSize = errors.New("invalid payload size")
 
 // Config holds configuration options for the ZeroTrace object.
 type Config struct {
 	// NumProbes determines the number of probes we're sending for a given TTL.
 	NumProbes int
+	// WarmupProbes determines the number of probes that we send with TTLStart
+	// before all other probes of a traceroute.  The first packets toward a
+	// target often incur ARP or neighbor discovery and route cache misses.
+	// Warm-up probes take that hit instead, and while they are part of the
+	// result, they don't count toward its RTT and hop statistics.
+	WarmupProbes int
 	// TTLStart determines the TTL at which we start sending trace packets.
 	TTLStart int
 	// TTLEnd determines the TTL at which we stop sending trace packets.
 	TTLEnd int
+	// ShuffleTTLs determines if each traceroute sends its TTLs in random
+	// order rather than in increasing order.  Per-flow rate limiters and
+	// stateful firewalls drop or delay the later packets of a burst, which
+	// would otherwise systematically bias the higher TTLs.
+	ShuffleTTLs bool
 	// SnapLen determines the number of bytes per frame that we want libpcap to
 	// capture.  500 bytes is enough for ICMP TTL exceeded packets.
 	SnapLen int32
 	// PktBufTimeout determines the time we're willing to wait for packets to
 	// accumulate in our receive buffer.
 	PktBufTimeout time.Duration
+	// TimestampSource determines where the capture timestamps of the packets
+	// that we capture come from, using libpcap's names: "host" (the kernel's
+	// software timestamps), "host_hiprec", "adapter" (the NIC's hardware
+	// clock, synchronized to the host's), or "adapter_unsynced".  Hardware
+	// timestamps don't suffer from the kernel's scheduling delay.  Start
+	// fails if the interface doesn't support the given source.  An empty
+	// string uses libpcap's default.
+	TimestampSource string
 	// Interface determines the network interface that we're going to use to
 	// listen for incoming network packets.
 	Interface string
+	// PayloadSizes determines the sizes in bytes of our trace packets' TCP
+	// payload.  The probes for each TTL cycle through the given sizes, so
+	// setting multiple sizes (and at least as many probes) sweeps the sizes,
+	// which reveals differences in serialization delay.  If empty, we use
+	// the default size.  Start fails if a size is negative or exceeds 65495,
+	// the largest payload that fits into an IPv4 packet.
+	PayloadSizes []int
+	// ProbeInterval determines the time we wait between sending two trace
+	// packets for the same TTL.  Zero means that we send them back to back.
+	ProbeInterval time.Duration
+	// ProbeJitter determines the maximum random delay that we add to each
+	// wait between two trace packets for the same TTL, so our trace packets
+	// don't synchronize with periodic cross traffic or the refill interval
+	// of policers.  The delay is uniformly distributed and drawn anew for
+	// each wait.  Zero disables jitter.
+	ProbeJitter time.Duration
+	// MaxProbeInterval determines the maximum probe interval when backing off.
+	// If a traceroute encounters hops that rate-limit their ICMP responses, the
+	// next traceroute doubles its probe interval, up to this maximum.  We
+	// remember the doubled interval for the next calls to Trace toward the
+	// same destination.  Zero disables backing off.
+	MaxProbeInterval time.Duration
+	// TunnelHopDelta determines the difference between the client's hop
+	// distance (as inferred from the TTL of its TCP segments) and the number
+	// of hops that we traced, at which we consider the client to be behind an
+	// encapsulating tunnel.
+	TunnelHopDelta int
+	// PcapDir determines the directory to which we write the packets that we
+	// capture for each call to Trace, one pcap file per session ID.  This is
+	// meant for debugging anomalous results.  An empty string disables pcap
+	// files.
+	PcapDir string
+	// PcapRetention determines the number of pcap files that we keep in
+	// PcapDir.  Once there are more, we delete the oldest.  Files that aren't
+	// named after a session ID are never deleted.  Zero means that we keep
+	// all files.
+	PcapRetention int
+	// NumTraces determines the number of traceroutes that we run one after
+	// another for each call to Trace, so we can tell if the path to the target
+	// is stable.
+	NumTraces int
+	// TraceBudget determines the total time that a call to Trace may take.
+	// Once the budget is spent, we stop sending trace packets and waiting for
+	// responses, and skip the remaining traceroutes.  Zero means that there's no budget.
+	TraceBudget time.Duration
+	// Blocklist determines the networks that we never trace.  Nil means that
+	// we trace all destinations.
+	Blocklist *Blocklist
+	// IXPs determines the peering LANs of Internet exchange points, whose
+	// hops we flag in our results.  Nil means that we flag no hops.
+	IXPs *IXPList
+	// Aliases determines the router interface addresses that belong to the
+	// same router, so hops that respond from different interfaces of a router
+	// don't count as path changes.  Nil means that only equal addresses
+	// belong to the same router.
+	Aliases *Aliases
+	// DialTimeout determines the time we're willing to wait for a TCP
+	// connection to be established when tracing an address via TraceAddr.
+	DialTimeout time.Duration
+	// NumSenders determines the number of goroutines that send trace packets.
+	// The senders are shared by all concurrent traceroutes.
+	NumSenders int
+	// SendQueueSize determines the number of TTLs that may wait for a sender.
+	// Once the queue is full, new traceroutes block until there's room.
+	SendQueueSize int
+	// StartAttempts determines the number of times that Start tries to create
+	// our raw socket and pcap handle if doing so fails with a transient error,
+	// e.g., because the network interface is flapping.
+	StartAttempts int
+	// StartBackoff determines the time that Start waits after its first failed
+	// attempt.  The time doubles after each subsequent attempt.
+	StartBackoff time.Duration
+	// CaptureStallTimeout determines the time after which we consider our
+	// packet capture stalled if it made no progress, in which case we restart
+	// it.  It must exceed PktBufTimeout.  Zero disables restarts.
+	CaptureStallTimeout time.Duration
+	// MaxTraces determines the maximum number of calls to Trace that may run
+	// concurrently.  Each call consumes goroutines, IP IDs, and capacity of
+	// our pcap handle, so a burst of clients could otherwise overload us.
+	// Zero means that there's no limit.
+	MaxTraces int
+	// TraceQueueTimeout determines the time that a call to Trace waits for
+	// one of the MaxTraces slots to free up before it fails with ErrBusy.
+	// Zero means that Trace fails right away.
+	TraceQueueTimeout time.Duration
+	// SubnetLimit determines the maximum number of calls to Trace toward
+	// destinations in the same subnet (a /24 for IPv4 and a /48 for IPv6)
+	// within SubnetWindow.  Calls beyond the limit fail with ErrThrottled.
+	// Zero means that there's no limit.
+	SubnetLimit int
+	// SubnetWindow determines the sliding window of SubnetLimit.
+	SubnetWindow time.Duration
+	// CalibrationInterval determines the interval at which we measure our
+	// self-latency, i.e., the delay that our own send and capture path adds
+	// to RTTs, by probing the loopback interface.  Each result reports the
+	// most recent self-latency.  Zero disables calibration.
+	CalibrationInterval time.Duration
+	// SubtractSelfLatency determines if we subtract our self-latency from
+	// the RTT of each result.
+	SubtractSelfLatency bool
+	// Faults determines the faults, i.e., loss, delay, and reordering, that
+	// we inject into the responses to our trace packets, for testing.  Nil
+	// means that we inject no faults.
+	Faults *Faults
 }
 
 // NewDefaultConfig returns a configuration object containing the following
 // defaults.  *Note* that you probably need to change the networking interface.
 //
-//	NumProbes:     3
-//	TTLStart:      5
-//	TTLEnd:        32
-//	SnapLen:       500
-//	PktBufTimeout: time.Millisecond * 10
-//	Interface:     "eth0"
+//	NumProbes:           3
+//	WarmupProbes:        0
+//	TTLStart:            5
+//	TTLEnd:              32
+//	ShuffleTTLs:         false
+//	SnapLen:             500
+//	PktBufTimeout:       time.Millisecond * 10
+//	TimestampSource:     ""
+//	Interface:           "eth0"
+//	PayloadSizes:        []int{12}
+//	ProbeInterval:       0
+//	ProbeJitter:         0
+//	MaxProbeInterval:    time.Millisecond * 500
+//	TunnelHopDelta:      5
+//	PcapDir:             ""
+//	PcapRetention:       100
+//	NumTraces:           2
+//	TraceBudget:         0
+//	Blocklist:           nil
+//	IXPs:                nil
+//	Aliases:             nil
+//	DialTimeout:         time.Second * 10
+//	NumSenders:          4
+//	SendQueueSize:       128
+//	StartAttempts:       5
+//	StartBackoff:        time.Millisecond * 200
+//	CaptureStallTimeout: time.Second * 30
+//	MaxTraces:           64
+//	TraceQueueTimeout:   time.Second * 10
+//	SubnetLimit:         0
+//	SubnetWindow:        time.Hour
+//	CalibrationInterval: 0
+//	SubtractSelfLatency: false
+//	Faults:              nil
 func NewDefaultConfig() *Config {
 	return &Config{
-		NumProbes:     3,
-		TTLStart:      5,
-		TTLEnd:        32,
-		SnapLen:       500,
-		PktBufTimeout: time.Millisecond * 10,
-		Interface:     "eth0",
+		NumProbes:           3,
+		WarmupProbes:        0,
+		TTLStart:            5,
+		TTLEnd:              32,
+		ShuffleTTLs:         false,
+		SnapLen:             500,
+		PktBufTimeout:       time.Millisecond * 10,
+		TimestampSource:     "",
+		Interface:           "eth0",
+		PayloadSizes:        []int{len(tcpPayload)},
+		ProbeInterval:       0,
+		ProbeJitter:         0,
+		MaxProbeInterval:    time.Millisecond * 500,
+		TunnelHopDelta:      5,
+		PcapDir:             "",
+		PcapRetention:       100,
+		NumTraces:           2,
+		TraceBudget:         0,
+		Blocklist:           nil,
+		IXPs:                nil,
+		Aliases:             nil,
+		DialTimeout:         time.Second * 10,
+		NumSenders:          4,
+		SendQueueSize:       128,
+		StartAttempts:       5,
+		StartBackoff:        time.Millisecond * 200,
+		CaptureStallTimeout: time.Second * 30,
+		MaxTraces:           64,
+		TraceQueueTimeout:   time.Second * 10,
+		SubnetLimit:         0,
+		SubnetWindow:        time.Hour,
+		CalibrationInterval: 0,
+		SubtractSelfLatency: false,
+		Faults:              nil,
+	}
+}
+
+// payloadSizes returns the configured payload sizes, or the default size if
+// none are configured, e.g., because the Config was built as a struct literal
+// by code that predates PayloadSizes.
+func (c *Config) payloadSizes() []int {
+	if len(c.PayloadSizes) == 0 {
+		return []int{len(tcpPayload)}
+	}
+	return c.PayloadSizes
+}
+
+// validatePayloadSizes returns an error if a configured payload size doesn't
+// fit into a trace packet.  We check the sizes when starting rather than when
+// creating trace packets, which happens in the background of each traceroute.
+func (c *Config) validatePayloadSizes() error {
+	for _, size := range c.PayloadSizes {
+		if size < 0 || size > maxPayloadSize {
+			return fmt.Errorf("%w: %d is not within [0, %d]",
+				errInvalidPayloadSize, size, maxPayloadSize)
+		}
+	}
+	return nil
+}
+
+// minBackoffInterval is the probe interval that we back off to if we were
+// sending trace packets back to back.
+const minBackoffInterval = time.Millisecond * 10
+
+// backoffProbeInterval returns the probe interval that follows the given
+// interval once we detected ICMP rate limiting: twice the given interval (but
+// at least minBackoffInterval), capped at MaxProbeInterval.  If backing off is
+// disabled, the given interval is returned as is.
+func (c *Config) backoffProbeInterval(cur time.Duration) time.Duration {
+	if c.MaxProbeInterval <= 0 {
+		return cur
+	}
+	next := cur * 2
+	if next < minBackoffInterval {
+		next = minBackoffInterval
+	}
+	if next > c.MaxProbeInterval {
+		next = c.MaxProbeInterval
+	}
+	if next < cur {
+		return cur
 	}
+	return next
 }
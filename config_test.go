@@ -0,0 +1,59 @@
+package zerotrace
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoffProbeInterval(t *testing.T) {
+	c := NewDefaultConfig()
+	c.MaxProbeInterval = 50 * time.Millisecond
+
+	for _, test := range []struct {
+		cur, next time.Duration
+	}{
+		{0, minBackoffInterval},
+		{time.Millisecond, minBackoffInterval},
+		{20 * time.Millisecond, 40 * time.Millisecond},
+		{40 * time.Millisecond, 50 * time.Millisecond},
+		{50 * time.Millisecond, 50 * time.Millisecond},
+		// An interval that already exceeds the maximum is left alone.
+		{time.Second, time.Second},
+	} {
+		assertEqual(t, c.backoffProbeInterval(test.cur), test.next)
+	}
+
+	// Backing off is disabled.
+	c.MaxProbeInterval = 0
+	assertEqual(t, c.backoffProbeInterval(20*time.Millisecond), 20*time.Millisecond)
+}
+
+func TestPayloadSizes(t *testing.T) {
+	// A Config without payload sizes still sends trace packets.
+	sizes := (&Config{}).payloadSizes()
+	assertEqual(t, len(sizes), 1)
+	assertEqual(t, sizes[0], len(tcpPayload))
+
+	sizes = (&Config{PayloadSizes: []int{0, 100}}).payloadSizes()
+	assertEqual(t, len(sizes), 2)
+	assertEqual(t, sizes[1], 100)
+}
+
+func TestValidatePayloadSizes(t *testing.T) {
+	for _, test := range []struct {
+		sizes []int
+		valid bool
+	}{
+		{nil, true},
+		{[]int{0, len(tcpPayload), maxPayloadSize}, true},
+		{[]int{-1}, false},
+		{[]int{100, maxPayloadSize + 1}, false},
+	} {
+		err := (&Config{PayloadSizes: test.sizes}).validatePayloadSizes()
+		assertEqual(t, err == nil, test.valid)
+		if err != nil {
+			assertEqual(t, errors.Is(err, errInvalidPayloadSize), true)
+		}
+	}
+}
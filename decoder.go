@@ -0,0 +1,120 @@
+package zerotrace
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// icmpDecoder decodes captured ICMP packets and the client's TCP segments.
+// The decoder preallocates all the layers that it may encounter and decodes
+// packets into these layers, which avoids per-packet allocations in our
+// capture path.  An icmpDecoder is not safe for concurrent use.
+type icmpDecoder struct {
+	eth     layers.Ethernet
+	sll     layers.LinuxSLL
+	ip4     layers.IPv4
+	icmp4   layers.ICMPv4
+	tcp     layers.TCP
+	payload gopacket.Payload
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+	// keepRaw instructs the decoder to keep a copy of each packet's IP
+	// header and payload.
+	keepRaw bool
+}
+
+// newIcmpDecoder returns a new ICMP decoder for packets whose first layer is
+// of the given type, e.g., Ethernet.
+func newIcmpDecoder(first gopacket.LayerType) *icmpDecoder {
+	d := &icmpDecoder{
+		decoded: make([]gopacket.LayerType, 0, 4),
+	}
+	d.parser = gopacket.NewDecodingLayerParser(
+		first,
+		&d.eth,
+		&d.sll,
+		&d.ip4,
+		&d.icmp4,
+		&d.tcp,
+		&d.payload,
+	)
+	// We only care about the layers up to the ICMP payload and TCP header.
+	d.parser.IgnoreUnsupported = true
+	return d
+}
+
+// decode extracts what we need (IP ID, timestamp, address, ICMP type and code,
+// and interface information) from the given ICMP packet.  For TCP segments,
+// decode extracts the IP ID, size, address, port, and TTL instead.  TCP
+// segments with zero sequence and ack numbers are our own trace packets, and
+// all others are the client's.  The given byte slice is not referenced after
+// decode returns, so it's safe to reuse its buffer.
+func (d *icmpDecoder) decode(data []byte, ci gopacket.CaptureInfo) (*respPkt, error) {
+	if err := d.parser.DecodeLayers(data, &d.decoded); err != nil {
+		return nil, err
+	}
+	var haveIPv4, haveIcmp, haveTCP bool
+	for _, t := range d.decoded {
+		switch t {
+		case layers.LayerTypeIPv4:
+			haveIPv4 = true
+		case layers.LayerTypeTCP:
+			haveTCP = true
+		case gopacket.LayerTypePayload:
+			haveIcmp = !haveTCP
+		}
+	}
+	var raw []byte
+	if haveIPv4 && d.keepRaw {
+		raw = append(append(raw, d.ip4.Contents...), d.ip4.Payload...)
+	}
+	if haveIPv4 && haveTCP {
+		outgoing := d.tcp.Seq == 0 && d.tcp.Ack == 0
+		p := &respPkt{
+			ipID:       d.ip4.Id,
+			size:       d.ip4.Length,
+			recvd:      ci.Timestamp,
+			recvdFrom:  append(net.IP(nil), d.ip4.SrcIP...),
+			fromClient: !outgoing,
+			outgoing:   outgoing,
+			recvdPort:  uint16(d.tcp.SrcPort),
+			recvdTTL:   d.ip4.TTL,
+			raw:        raw,
+		}
+		if outgoing {
+			p.sentTo = append(net.IP(nil), d.ip4.DstIP...)
+			p.sentToPort = uint16(d.tcp.DstPort)
+		}
+		return p, nil
+	}
+	if !haveIPv4 || !haveIcmp {
+		return nil, errNoIcmp
+	}
+
+	ipID, err := extractIPID(d.payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var ifInfos []*InterfaceInfo
+	icmpType := d.icmp4.TypeCode.Type()
+	if hasIcmpExtensions(icmpType) {
+		// RFC 4884 stores the original datagram's length in the second
+		// octet of the ICMP header's "unused" field.
+		ifInfos = parseIfInfos(d.payload, uint8(d.icmp4.Id))
+	}
+
+	// We're not interested in the response packet's TTL because by definition,
+	// it's always going to be 1.
+	return &respPkt{
+		ipID:      ipID,
+		recvd:     ci.Timestamp,
+		recvdFrom: append(net.IP(nil), d.ip4.SrcIP...),
+		icmpType:  icmpType,
+		icmpCode:  d.icmp4.TypeCode.Code(),
+		ifInfos:   ifInfos,
+		raw:       raw,
+	}, nil
+}
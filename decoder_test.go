@@ -0,0 +1,165 @@
+package zerotrace
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newIcmpPkt returns an ICMP TTL exceeded packet (starting with its IPv4
+// header) that was sent by the given hop in response to the trace packet with
+// the given IP ID.
+func newIcmpPkt(t testing.TB, hop net.IP, ipID uint16) []byte {
+	t.Helper()
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	err := gopacket.SerializeLayers(buf, opts,
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: layers.IPProtocolICMPv4,
+			SrcIP:    hop,
+			DstIP:    net.ParseIP(srcAddr),
+		},
+		&layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(
+				layers.ICMPv4TypeTimeExceeded,
+				layers.ICMPv4CodeTTLExceeded,
+			),
+		},
+		// The IP header of the trace packet that triggered the ICMP response.
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      1,
+			Id:       ipID,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    net.ParseIP(srcAddr),
+			DstIP:    net.ParseIP(dstAddr),
+		},
+	)
+	if err != nil {
+		t.Fatalf("Failed to serialize ICMP packet: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	var (
+		hop = net.ParseIP("192.168.1.1")
+		now = time.Now().UTC()
+		d   = newIcmpDecoder(layers.LayerTypeIPv4)
+	)
+
+	pkt := newIcmpPkt(t, hop, 1234)
+	p, err := d.decode(pkt, gopacket.CaptureInfo{Timestamp: now})
+	failOnErr(t, err)
+	assertEqual(t, p.ipID, uint16(1234))
+	assertEqual(t, p.recvd, now)
+	assertEqual(t, p.icmpType, uint8(layers.ICMPv4TypeTimeExceeded))
+	assertEqual(t, p.icmpCode, uint8(layers.ICMPv4CodeTTLExceeded))
+	if !p.recvdFrom.Equal(hop) {
+		t.Fatalf("Expected response from %s but got %s.", hop, p.recvdFrom)
+	}
+
+	if p.raw != nil {
+		t.Fatal("Expected decoder not to keep a copy of the packet.")
+	}
+
+	d.keepRaw = true
+	p, err = d.decode(pkt, gopacket.CaptureInfo{Timestamp: now})
+	failOnErr(t, err)
+	if !bytes.Equal(p.raw, pkt) {
+		t.Fatal("Expected decoder to keep a copy of the packet.")
+	}
+
+	// The response packet must not reference the packet's buffer.
+	copy(pkt, make([]byte, len(pkt)))
+	if !p.recvdFrom.Equal(hop) || p.raw[0] == 0 {
+		t.Fatal("Expected response packet to be independent of packet buffer.")
+	}
+}
+
+func TestDecodeNoIcmp(t *testing.T) {
+	d := newIcmpDecoder(layers.LayerTypeIPv4)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	failOnErr(t, gopacket.SerializeLayers(buf, opts,
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    dummyAddr,
+			DstIP:    net.ParseIP(srcAddr),
+		},
+	))
+	if _, err := d.decode(buf.Bytes(), gopacket.CaptureInfo{}); err != errNoIcmp {
+		t.Fatalf("Expected error %v but got %v.", errNoIcmp, err)
+	}
+}
+
+func TestDecodeTCP(t *testing.T) {
+	d := newIcmpDecoder(layers.LayerTypeIPv4)
+
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      52,
+		Id:       4321,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    dummyAddr,
+		DstIP:    net.ParseIP(srcAddr),
+	}
+	tcp := &layers.TCP{SrcPort: 8080, DstPort: 12345, ACK: true, Seq: 1000, Ack: 2000}
+	failOnErr(t, tcp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	failOnErr(t, gopacket.SerializeLayers(buf, opts, ip, tcp))
+
+	p, err := d.decode(buf.Bytes(), gopacket.CaptureInfo{})
+	failOnErr(t, err)
+	assertEqual(t, p.fromClient, true)
+	assertEqual(t, p.outgoing, false)
+	assertEqual(t, p.recvdPort, uint16(8080))
+	assertEqual(t, p.recvdTTL, uint8(52))
+	assertEqual(t, p.ipID, uint16(4321))
+	assertEqual(t, p.size, uint16(40))
+	if !p.recvdFrom.Equal(dummyAddr) {
+		t.Fatalf("Expected segment from %s but got %s.", dummyAddr, p.recvdFrom)
+	}
+
+	// Our own trace packets have zero sequence and ack numbers.
+	tcp.Seq, tcp.Ack = 0, 0
+	failOnErr(t, gopacket.SerializeLayers(buf, opts, ip, tcp))
+	p, err = d.decode(buf.Bytes(), gopacket.CaptureInfo{})
+	failOnErr(t, err)
+	assertEqual(t, p.fromClient, false)
+	assertEqual(t, p.outgoing, true)
+	assertEqual(t, p.sentToPort, uint16(12345))
+	if !p.sentTo.Equal(net.ParseIP(srcAddr)) {
+		t.Fatalf("Expected trace packet to %s but got %s.", srcAddr, p.sentTo)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	var (
+		d   = newIcmpDecoder(layers.LayerTypeIPv4)
+		pkt = newIcmpPkt(b, dummyAddr, 1234)
+		ci  = gopacket.CaptureInfo{}
+	)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.decode(pkt, ci); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package zerotrace
+
+// Diagnostics is a snapshot of a ZeroTrace object's internal state.  It helps
+// with hunting down leaked traceroutes, goroutines, and pcap handles.
+type Diagnostics struct {
+	// ActiveTraces is the number of calls to Trace that are in progress.
+	ActiveTraces int
+	// Receivers is the number of traceroutes that are registered with the
+	// capture manager of our network interface.
+	Receivers int
+	// CaptureHandles is the number of pcap handles that are open, across all
+	// ZeroTrace objects.  Each handle has a goroutine that reads packets and
+	// one that dispatches them, plus a supervisor unless restarts are
+	// disabled.
+	CaptureHandles int
+	// CaptureRestarts is the number of times that the capture of our network
+	// interface was restarted because it stalled.
+	CaptureRestarts int64
+	// Senders is the number of goroutines that send trace packets.
+	Senders int
+	// SendQueueLen and SendQueueCap are the number of TTLs that wait for a
+	// sender and the capacity of the send queue, respectively.
+	SendQueueLen, SendQueueCap int
+	// IPIDsInFlight is the number of IP IDs that are borrowed by trace
+	// packets that were neither answered nor expired.
+	IPIDsInFlight int
+}
+
+// Diagnostics returns a snapshot of the ZeroTrace object's internal state.
+// The ZeroTrace object must be started.
+func (z *ZeroTrace) Diagnostics() *Diagnostics {
+	captureMgrsMutex.Lock()
+	numHandles := len(captureMgrs)
+	captureMgrsMutex.Unlock()
+
+	return &Diagnostics{
+		ActiveTraces:    int(z.active.Load()),
+		Receivers:       int(z.capture.receivers.Load()),
+		CaptureHandles:  numHandles,
+		CaptureRestarts: z.capture.restarts.Load(),
+		Senders:         z.cfg.numSenders(),
+		SendQueueLen:    len(z.sendQueue),
+		SendQueueCap:    cap(z.sendQueue),
+		IPIDsInFlight:   z.ipids.size(),
+	}
+}
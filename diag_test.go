@@ -0,0 +1,33 @@
+package zerotrace
+
+import (
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	z := NewZeroTrace(NewDefaultConfig())
+	z.capture = newCaptureManager("dummy")
+	z.ipids = z.capture.ipids
+	go z.capture.listen(make(chan *respPkt))
+	defer close(z.capture.quit)
+
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+	r := make(receiver, 1)
+	// The event loop has processed the first registration by the time the
+	// second one is accepted.
+	z.capture.register(r, f)
+	z.capture.register(r, f)
+	_, err = z.ipids.borrow()
+	failOnErr(t, err)
+	z.sendQueue <- &sendJob{}
+	z.active.Add(1)
+
+	d := z.Diagnostics()
+	assertEqual(t, d.ActiveTraces, 1)
+	assertEqual(t, d.Receivers, 1)
+	assertEqual(t, d.Senders, z.cfg.NumSenders)
+	assertEqual(t, d.SendQueueLen, 1)
+	assertEqual(t, d.SendQueueCap, z.cfg.SendQueueSize)
+	assertEqual(t, d.IPIDsInFlight, 1)
+}
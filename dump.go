@@ -0,0 +1,154 @@
+package zerotrace
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// sessionPcapName matches the names of the pcap files that we write, i.e., a
+// session ID followed by ".pcap".
+var sessionPcapName = regexp.MustCompile(
+	`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.pcap$`)
+
+// newSessionID returns a random (version 4) UUID.
+func newSessionID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4.
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// pcapDump writes a session's trace packets and captured packets to a pcap
+// file.  The packets start with their IP header because the capture manager
+// strips the link layer.  All methods are no-ops for a nil pcapDump.
+type pcapDump struct {
+	f *os.File
+	w *pcapgo.Writer
+}
+
+// newPcapDump creates the given pcap file.
+func newPcapDump(path string, snapLen int32) (*pcapDump, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(uint32(snapLen), layers.LinkTypeRaw); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pcapDump{f: f, w: w}, nil
+}
+
+// write writes the given packet, which was sent or received at the given time,
+// to the pcap file.  A nil packet means that we didn't keep a copy of it, so
+// there's nothing to write.
+func (d *pcapDump) write(raw []byte, t time.Time) {
+	if d == nil || raw == nil {
+		return
+	}
+	ci := gopacket.CaptureInfo{
+		Timestamp:     t,
+		CaptureLength: len(raw),
+		Length:        len(raw),
+	}
+	if err := d.w.WritePacket(ci, raw); err != nil {
+		l.Printf("Error writing packet to %s: %v", d.f.Name(), err)
+	}
+}
+
+// close closes the pcap file.
+func (d *pcapDump) close() error {
+	if d == nil {
+		return nil
+	}
+	return d.f.Close()
+}
+
+// openDump returns a pcap dump for the given session, or nil if pcap files are
+// disabled or we failed to create the file.
+func (z *ZeroTrace) openDump(sessionID string) *pcapDump {
+	if z.cfg.PcapDir == "" {
+		return nil
+	}
+	path := filepath.Join(z.cfg.PcapDir, sessionID+".pcap")
+	d, err := newPcapDump(path, z.cfg.SnapLen)
+	if err != nil {
+		l.Printf("Error creating pcap file: %v", err)
+		return nil
+	}
+	z.capture.startDump()
+	return d
+}
+
+// closeDump closes the given pcap dump and enforces our retention limit.
+func (z *ZeroTrace) closeDump(d *pcapDump) {
+	if d == nil {
+		return
+	}
+	z.capture.stopDump()
+	if err := d.close(); err != nil {
+		l.Printf("Error closing pcap file: %v", err)
+	}
+	if err := prunePcaps(z.cfg.PcapDir, z.cfg.PcapRetention); err != nil {
+		l.Printf("Error pruning pcap files: %v", err)
+	}
+}
+
+// prunePcaps deletes the oldest pcap files that we wrote to the given
+// directory until at most the given number of them remain.  Other files, e.g.,
+// captures that operators put there, are left alone.  If keep is zero, we keep
+// all files.
+func prunePcaps(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && sessionPcapName.MatchString(e.Name()) {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(paths) <= keep {
+		return nil
+	}
+
+	type pcapFile struct {
+		path string
+		info os.FileInfo
+	}
+	files := []pcapFile{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Another session may have pruned the file already.
+			continue
+		}
+		files = append(files, pcapFile{path, info})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for i := 0; i < len(files)-keep; i++ {
+		if err := os.Remove(files[i].path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
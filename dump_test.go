@@ -0,0 +1,97 @@
+package zerotrace
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestNewSessionID(t *testing.T) {
+	id1, err := newSessionID()
+	failOnErr(t, err)
+	id2, err := newSessionID()
+	failOnErr(t, err)
+
+	if !sessionPcapName.MatchString(id1 + ".pcap") {
+		t.Fatalf("Expected session ID %q to be a UUID.", id1)
+	}
+	if id1 == id2 {
+		t.Fatal("Expected session IDs to be unique.")
+	}
+}
+
+func TestPcapDump(t *testing.T) {
+	var (
+		path = filepath.Join(t.TempDir(), "session.pcap")
+		pkt  = newIcmpPkt(t, dummyAddr, 1234)
+		now  = time.Now().UTC().Truncate(time.Microsecond)
+	)
+	d, err := newPcapDump(path, 500)
+	failOnErr(t, err)
+	d.write(pkt, now)
+	// Packets that we didn't keep a copy of are skipped.
+	d.write(nil, now)
+	failOnErr(t, d.close())
+
+	f, err := os.Open(path)
+	failOnErr(t, err)
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	failOnErr(t, err)
+	assertEqual(t, r.LinkType(), layers.LinkTypeRaw)
+
+	data, ci, err := r.ReadPacketData()
+	failOnErr(t, err)
+	if !bytes.Equal(data, pkt) {
+		t.Fatal("Expected dumped packet to equal the captured packet.")
+	}
+	assertEqual(t, ci.Timestamp.UTC(), now)
+	if _, _, err := r.ReadPacketData(); err == nil {
+		t.Fatal("Expected pcap file to contain a single packet.")
+	}
+
+	// A nil dump is a no-op.
+	var nilDump *pcapDump
+	nilDump.write(pkt, now)
+	failOnErr(t, nilDump.close())
+}
+
+func TestPrunePcaps(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		now   = time.Now()
+		names = []string{
+			"manual.pcap", // An operator's capture, which is older than ours.
+			"0d8b1a6e-8a8e-4c43-9f3e-1b2d2c6d9a01.pcap",
+			"0d8b1a6e-8a8e-4c43-9f3e-1b2d2c6d9a02.pcap",
+			"0d8b1a6e-8a8e-4c43-9f3e-1b2d2c6d9a03.pcap",
+			"d.txt",
+		}
+	)
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		failOnErr(t, os.WriteFile(path, nil, 0o600))
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		failOnErr(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	failOnErr(t, prunePcaps(dir, 0))
+	failOnErr(t, prunePcaps(dir, 2))
+
+	entries, err := os.ReadDir(dir)
+	failOnErr(t, err)
+	got := []string{}
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	// Our oldest pcap file is gone; other files are left alone.
+	assertEqual(t, len(got), 4)
+	for i, name := range []string{names[2], names[3], "d.txt", "manual.pcap"} {
+		assertEqual(t, got[i], name)
+	}
+}
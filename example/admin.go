@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+var (
+	errNoClientCAs = errors.New("no CA certificates found")
+)
+
+// loadClientCAs reads the PEM-encoded CA certificates from the file at the
+// given path.  Clients of the admin listener must present a certificate that
+// one of these CAs signed.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errNoClientCAs
+	}
+	return pool, nil
+}
+
+// requireClientCerts modifies the given TLS configuration of the admin
+// listener, which serves the APIs that expose collected data.  Unlike the
+// public listener, it requires clients to authenticate with a certificate
+// signed by one of the given CAs.
+func requireClientCerts(cfg *tls.Config, clientCAs *x509.CertPool) {
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	cfg.ClientCAs = clientCAs
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCert returns a certificate for the given name that's signed by the
+// given parent, or self-signed if the parent is nil.
+func newTestCert(t *testing.T, name string, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	signerCert, signerKey := tmpl, any(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signerCert, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestLoadClientCAs(t *testing.T) {
+	var (
+		ca   = newTestCert(t, "ca", nil)
+		path = filepath.Join(t.TempDir(), "ca.pem")
+	)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	if err := os.WriteFile(path, pemData, 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := loadClientCAs(path); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("no certificates here"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+	if _, err := loadClientCAs(path); err != errNoClientCAs {
+		t.Fatalf("Expected error %v but got %v.", errNoClientCAs, err)
+	}
+}
+
+func TestRequireClientCerts(t *testing.T) {
+	var (
+		ca        = newTestCert(t, "ca", nil)
+		clientCrt = newTestCert(t, "client", ca)
+		otherCrt  = newTestCert(t, "other", newTestCert(t, "other-ca", nil))
+		clientCAs = x509.NewCertPool()
+	)
+	clientCAs.AddCert(ca.Leaf)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// The test server brings its own server certificate.
+	srv.TLS = &tls.Config{}
+	requireClientCerts(srv.TLS, clientCAs)
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	get := func(cert *tls.Certificate) error {
+		tlsCfg := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			tlsCfg.Certificates = []tls.Certificate{*cert}
+		}
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err := get(clientCrt); err != nil {
+		t.Fatalf("Expected client with valid certificate to succeed but got: %v", err)
+	}
+	if err := get(nil); err == nil {
+		t.Fatal("Expected client without certificate to fail.")
+	}
+	if err := get(otherCrt); err == nil {
+		t.Fatal("Expected client with certificate of unknown CA to fail.")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// alertMinSamples is the number of measurements that we need within the
+	// window before we alert on their failure rate.
+	alertMinSamples = 10
+	alertTimeout    = 10 * time.Second
+)
+
+// outcome represents whether the measurement at the given time failed.
+type outcome struct {
+	t      time.Time
+	failed bool
+}
+
+// alerter notifies operators via a webhook once the fraction of failed
+// measurements within a sliding window exceeds a threshold.  The webhook
+// receives a JSON object whose "text" field contains the alert, which is the
+// format of Slack's incoming webhooks.  After alerting, the alerter stays
+// quiet for a window's length.  A nil alerter discards all alerts.
+type alerter struct {
+	sync.Mutex // Guards outcomes and lastAlert.
+	url        string
+	threshold  float64
+	window     time.Duration
+	outcomes   []outcome
+	lastAlert  time.Time
+	now        func() time.Time
+	post       func(url string, body []byte) error
+}
+
+// newAlerter returns a new alerter that posts to the given webhook URL, or nil
+// if the URL is empty.
+func newAlerter(url string, threshold float64, window time.Duration) *alerter {
+	if url == "" {
+		return nil
+	}
+	return &alerter{
+		url:       url,
+		threshold: threshold,
+		window:    window,
+		now:       time.Now,
+		post:      postWebhook,
+	}
+}
+
+// postWebhook posts the given body to the given webhook URL.
+func postWebhook(url string, body []byte) error {
+	c := http.Client{Timeout: alertTimeout}
+	resp, err := c.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// alert sends the given message to the webhook.
+func (a *alerter) alert(msg string) {
+	if a == nil {
+		return
+	}
+	l.Printf("Alerting operators: %s", msg)
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{msg})
+	if err != nil {
+		l.Printf("Error encoding alert: %v", err)
+		return
+	}
+	if err := a.post(a.url, body); err != nil {
+		l.Printf("Error sending alert: %v", err)
+	}
+}
+
+// record records the outcome of a measurement, which failed if the given error
+// isn't nil, and alerts if the failure rate within the window is too high.
+func (a *alerter) record(err error) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	now := a.now()
+	a.outcomes = append(a.outcomes, outcome{t: now, failed: err != nil})
+	// Forget the outcomes that fell out of the window.
+	i := 0
+	for i < len(a.outcomes) && now.Sub(a.outcomes[i].t) > a.window {
+		i++
+	}
+	a.outcomes = a.outcomes[i:]
+
+	var failed int
+	for _, o := range a.outcomes {
+		if o.failed {
+			failed++
+		}
+	}
+	total := len(a.outcomes)
+	rate := float64(failed) / float64(total)
+	if total < alertMinSamples || rate <= a.threshold || now.Sub(a.lastAlert) < a.window {
+		a.Unlock()
+		return
+	}
+	a.lastAlert = now
+	a.Unlock()
+
+	go a.alert(fmt.Sprintf("%d of %d measurements (%.0f%%) failed within the last %v; last error: %v",
+		failed, total, rate*100, a.window, err))
+}
+
+// watchCertExpiry periodically fetches the certificate for the given domain
+// and alerts if it expires within the given duration, which means that its
+// renewal failed.
+func (a *alerter) watchCertExpiry(
+	getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+	domain string,
+	within time.Duration,
+	interval time.Duration,
+) {
+	if a == nil {
+		return
+	}
+	for ; ; time.Sleep(interval) {
+		cert, err := getCert(&tls.ClientHelloInfo{ServerName: domain})
+		if err != nil {
+			a.alert(fmt.Sprintf("Error getting certificate for %s: %v", domain, err))
+			continue
+		}
+		if cert.Leaf == nil || a.now().Add(within).Before(cert.Leaf.NotAfter) {
+			continue
+		}
+		a.alert(fmt.Sprintf("Certificate for %s expires at %v.", domain, cert.Leaf.NotAfter))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+	var (
+		now    = time.Now()
+		alerts = make(chan string, 10)
+		a      = newAlerter("https://example.com/hook", 0.5, 10*time.Minute)
+		errFoo = errors.New("foo")
+	)
+	a.now = func() time.Time { return now }
+	a.post = func(url string, body []byte) error {
+		var msg struct{ Text string }
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Errorf("Failed to decode alert: %v", err)
+		}
+		alerts <- msg.Text
+		return nil
+	}
+
+	// Too few samples don't trigger an alert, regardless of failure rate.
+	for i := 0; i < alertMinSamples-1; i++ {
+		a.record(errFoo)
+	}
+	// The tenth sample gets us to 90% failures.
+	a.record(nil)
+	select {
+	case <-alerts:
+	case <-time.After(time.Second):
+		t.Fatal("Expected alert for high failure rate.")
+	}
+
+	// We don't alert again within the window.
+	a.record(errFoo)
+
+	// Once the failures fell out of the window, successes don't alert.
+	now = now.Add(11 * time.Minute)
+	for i := 0; i < alertMinSamples; i++ {
+		a.record(nil)
+	}
+	select {
+	case msg := <-alerts:
+		t.Fatalf("Unexpected alert: %s", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNilAlerter(t *testing.T) {
+	a := newAlerter("", 0.5, time.Minute)
+	if a != nil {
+		t.Fatal("Expected nil alerter for empty webhook URL.")
+	}
+	a.record(errors.New("foo"))
+	a.alert("foo")
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"github.com/brave/zerotrace"
+)
+
+// loadITDKNodesFile loads the router aliases of the ITDK nodes file at the
+// given path.
+func loadITDKNodesFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return zerotrace.ParseITDKNodes(f)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi"
+)
+
+// The scopes that an API key may have.  The admin scope implies all others.
+const (
+	scopeReadResults        = "read-results"
+	scopeTriggerMeasurement = "trigger-measurement"
+	scopeAdmin              = "admin"
+)
+
+var (
+	errUnknownScope = errors.New("unknown scope")
+	errNoScopes     = errors.New("API key needs at least one scope")
+	errKeyExists    = errors.New("API key name already exists")
+	errNoSuchKey    = errors.New("no such API key")
+	errBadKeyName   = errors.New("API key name must be non-empty and contain no whitespace")
+)
+
+// apiKey is an API key as we store it.  We only keep the key's hash, so the
+// key file is useless to whoever obtains a copy of it.
+type apiKey struct {
+	Name   string   `json:"name"`
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+}
+
+// hasScope returns true if the API key has the given scope.
+func (k *apiKey) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == scopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// keyStore holds the API keys of the teams that use our APIs, each with its
+// own scopes, and persists them to a JSON file.  It's safe for concurrent use.
+// A nil keyStore authorizes all requests, which is how our APIs behave if no
+// key file is configured.
+type keyStore struct {
+	sync.Mutex // Guards keys.
+	path       string
+	keys       map[string]*apiKey // Indexed by the key's hash.
+}
+
+// loadKeyStore loads the API keys from the JSON file at the given path.  If the
+// file doesn't exist yet, the store starts out empty.
+func loadKeyStore(path string) (*keyStore, error) {
+	s := &keyStore{path: path, keys: make(map[string]*apiKey)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []*apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, k := range keys {
+		s.keys[k.Hash] = k
+	}
+	return s, nil
+}
+
+// hashKey returns the hex-encoded SHA-256 hash of the given API key.  API keys
+// are random, so they need no salt or key stretching.
+func hashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// validateScopes returns an error if the given scopes are empty or contain
+// an unknown scope.
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return errNoScopes
+	}
+	for _, s := range scopes {
+		switch s {
+		case scopeReadResults, scopeTriggerMeasurement, scopeAdmin:
+		default:
+			return fmt.Errorf("%w: %q", errUnknownScope, s)
+		}
+	}
+	return nil
+}
+
+// create creates and persists a new API key with the given name and scopes,
+// and returns the key.  The key can't be retrieved later.
+func (s *keyStore) create(name string, scopes []string) (string, error) {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return "", errBadKeyName
+	}
+	if err := validateScopes(scopes); err != nil {
+		return "", err
+	}
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(b[:])
+
+	s.Lock()
+	defer s.Unlock()
+	for _, k := range s.keys {
+		if k.Name == name {
+			return "", errKeyExists
+		}
+	}
+	hash := hashKey(key)
+	s.keys[hash] = &apiKey{Name: name, Hash: hash, Scopes: scopes}
+	if err := s.save(); err != nil {
+		delete(s.keys, hash)
+		return "", err
+	}
+	return key, nil
+}
+
+// revoke deletes the API key with the given name.
+func (s *keyStore) revoke(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for hash, k := range s.keys {
+		if k.Name != name {
+			continue
+		}
+		delete(s.keys, hash)
+		if err := s.save(); err != nil {
+			s.keys[hash] = k
+			return err
+		}
+		return nil
+	}
+	return errNoSuchKey
+}
+
+// lookup returns the API key that matches the given key, or nil.
+func (s *keyStore) lookup(key string) *apiKey {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.keys[hashKey(key)]
+}
+
+// save writes the store's keys to its file.  We write a temporary file first,
+// so a crash can't leave a truncated key file behind.  The caller must hold
+// the store's lock.
+func (s *keyStore) save() error {
+	keys := []*apiKey{}
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// requireScope returns middleware that only lets through requests that carry
+// an API key with the given scope as bearer token.
+func (s *keyStore) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, streamAuthPrefix) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			k := s.lookup(strings.TrimPrefix(auth, streamAuthPrefix))
+			if k == nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !k.hasScope(scope) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keyRequest is the body of a request to create an API key.
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// keyResponse is the response to a request to create an API key.  It's the
+// only time that we reveal the key.
+type keyResponse struct {
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+func getCreateKeyHandler(s *keyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req keyRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+		key, err := s.create(req.Name, req.Scopes)
+		switch {
+		case errors.Is(err, errKeyExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, errBadKeyName), errors.Is(err, errNoScopes), errors.Is(err, errUnknownScope):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			l.Printf("Error creating API key: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		l.Printf("Created API key %q with scopes %v.", req.Name, req.Scopes)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		resp := &keyResponse{Name: req.Name, Key: key, Scopes: req.Scopes}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			l.Printf("Error writing API key: %v", err)
+		}
+	}
+}
+
+func getRevokeKeyHandler(s *keyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		err := s.revoke(name)
+		switch {
+		case errors.Is(err, errNoSuchKey):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			l.Printf("Error revoking API key: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		l.Printf("Revoked API key %q.", name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
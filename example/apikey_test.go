@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func newTestKeyStore(t *testing.T) *keyStore {
+	t.Helper()
+	s, err := loadKeyStore(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatalf("Failed to load key store: %v", err)
+	}
+	return s
+}
+
+func TestKeyStore(t *testing.T) {
+	s := newTestKeyStore(t)
+	key, err := s.create("team-a", []string{scopeReadResults})
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if k := s.lookup(key); k == nil || k.Name != "team-a" {
+		t.Fatalf("Expected to find key of team-a but got %+v.", k)
+	}
+	if s.lookup("not a key") != nil {
+		t.Fatal("Expected unknown key not to be found.")
+	}
+
+	for _, test := range []struct {
+		name   string
+		scopes []string
+		err    error
+	}{
+		{"team-a", []string{scopeReadResults}, errKeyExists},
+		{"team b", []string{scopeReadResults}, errBadKeyName},
+		{"team-b", nil, errNoScopes},
+		{"team-b", []string{"root"}, errUnknownScope},
+	} {
+		if _, err := s.create(test.name, test.scopes); !errors.Is(err, test.err) {
+			t.Fatalf("Expected error %v but got %v.", test.err, err)
+		}
+	}
+
+	// Keys survive a restart, but only their hashes are stored.
+	s2, err := loadKeyStore(s.path)
+	if err != nil {
+		t.Fatalf("Failed to reload key store: %v", err)
+	}
+	if s2.lookup(key) == nil {
+		t.Fatal("Expected key to be persisted.")
+	}
+	for _, k := range s2.keys {
+		if k.Hash == key {
+			t.Fatal("Expected key file to contain no plaintext keys.")
+		}
+	}
+
+	if err := s.revoke("team-a"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if s.lookup(key) != nil {
+		t.Fatal("Expected revoked key not to be found.")
+	}
+	if err := s.revoke("team-a"); err != errNoSuchKey {
+		t.Fatalf("Expected error %v but got %v.", errNoSuchKey, err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	s := newTestKeyStore(t)
+	reader, err := s.create("reader", []string{scopeReadResults})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	admin, err := s.create("admin", []string{scopeAdmin})
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, test := range []struct {
+		store  *keyStore
+		scope  string
+		auth   string
+		status int
+	}{
+		{s, scopeReadResults, "Bearer " + reader, http.StatusOK},
+		{s, scopeTriggerMeasurement, "Bearer " + reader, http.StatusForbidden},
+		// The admin scope implies all others.
+		{s, scopeTriggerMeasurement, "Bearer " + admin, http.StatusOK},
+		{s, scopeReadResults, "Bearer foo", http.StatusUnauthorized},
+		{s, scopeReadResults, "", http.StatusUnauthorized},
+		// Without a key store, all requests are authorized.
+		{nil, scopeAdmin, "", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		test.store.requireScope(test.scope)(ok).ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Fatalf("Expected status %d for scope %q but got %d.", test.status, test.scope, w.Code)
+		}
+	}
+}
+
+func TestKeyHandlers(t *testing.T) {
+	s := newTestKeyStore(t)
+	router := chi.NewRouter()
+	router.Post("/api/v1/keys", getCreateKeyHandler(s))
+	router.Delete("/api/v1/keys/{name}", getRevokeKeyHandler(s))
+
+	w := httptest.NewRecorder()
+	body := `{"name":"team-a","scopes":["read-results"]}`
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/keys", strings.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d but got %d.", http.StatusCreated, w.Code)
+	}
+	var resp keyResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if s.lookup(resp.Key) == nil {
+		t.Fatal("Expected returned key to be valid.")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/v1/keys", strings.NewReader(body)))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d but got %d.", http.StatusConflict, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/keys/team-a", nil))
+	if w.Code != http.StatusNoContent || s.lookup(resp.Key) != nil {
+		t.Fatalf("Expected key to be revoked but got status %d.", w.Code)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/api/v1/keys/team-a", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d but got %d.", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// recordAvroSchema is the Avro schema of our measurement records.  Its fields
+// mirror the JSON encoding of the record struct, except that times are
+// microseconds since the Unix epoch.  Like in JSON lines, the signature covers
+// the record's JSON encoding.
+const recordAvroSchema = `{
+  "type": "record",
+  "name": "Record",
+  "namespace": "com.brave.zerotrace",
+  "fields": [
+    {"name": "time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+    {"name": "target", "type": "string"},
+    {"name": "rtt_ms", "type": "double"},
+    {"name": "tunneled", "type": "boolean", "default": false},
+    {"name": "error", "type": "string", "default": ""},
+    {"name": "sig", "type": "string", "default": ""}
+  ]
+}`
+
+// avroMagic starts every Avro object container file.
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// avroWriter writes measurement records as an Avro object container file
+// whose header embeds our record schema, so consumers need nothing else to
+// decode it.  We write one uncompressed block per record, which lets consumers
+// read records as soon as we wrote them.  It's not safe for concurrent use.
+type avroWriter struct {
+	w    io.Writer
+	sync [16]byte
+}
+
+// newAvroWriter writes the container file's header to the given writer and
+// returns a writer for the file's records.
+func newAvroWriter(w io.Writer) (*avroWriter, error) {
+	a := &avroWriter{w: w}
+	if _, err := rand.Read(a.sync[:]); err != nil {
+		return nil, err
+	}
+
+	// The file's metadata is an Avro map of bytes, encoded as a single block
+	// of entries followed by an empty block.
+	b := append([]byte{}, avroMagic...)
+	b = appendAvroLong(b, 2)
+	b = appendAvroString(b, "avro.schema")
+	b = appendAvroString(b, recordAvroSchema)
+	b = appendAvroString(b, "avro.codec")
+	b = appendAvroString(b, "null")
+	b = appendAvroLong(b, 0)
+	b = append(b, a.sync[:]...)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// write writes the given record as a block of its own.
+func (a *avroWriter) write(r *record) error {
+	var obj []byte
+	obj = appendAvroLong(obj, r.Time.UnixMicro())
+	obj = appendAvroString(obj, r.Target)
+	obj = appendAvroDouble(obj, r.RTT)
+	obj = appendAvroBool(obj, r.Tunneled)
+	obj = appendAvroString(obj, r.Error)
+	obj = appendAvroString(obj, r.Sig)
+
+	var b bytes.Buffer
+	b.Write(appendAvroLong(nil, 1))
+	b.Write(appendAvroLong(nil, int64(len(obj))))
+	b.Write(obj)
+	b.Write(a.sync[:])
+	_, err := a.w.Write(b.Bytes())
+	return err
+}
+
+// appendAvroLong appends the given integer as a zig-zag-encoded varint.
+func appendAvroLong(b []byte, n int64) []byte {
+	return binary.AppendUvarint(b, uint64((n<<1)^(n>>63)))
+}
+
+// appendAvroString appends the given string, prefixed by its length.
+func appendAvroString(b []byte, s string) []byte {
+	return append(appendAvroLong(b, int64(len(s))), s...)
+}
+
+// appendAvroDouble appends the given float as eight little-endian bytes.
+func appendAvroDouble(b []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+// appendAvroBool appends the given boolean as a single byte.
+func appendAvroBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// avroReader decodes the primitive types of Avro's binary encoding.
+type avroReader struct {
+	*bufio.Reader
+	t *testing.T
+}
+
+func (r *avroReader) long() int64 {
+	u, err := binary.ReadUvarint(r)
+	if err != nil {
+		r.t.Fatalf("Failed to read long: %v", err)
+	}
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *avroReader) bytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		r.t.Fatalf("Failed to read %d bytes: %v", n, err)
+	}
+	return b
+}
+
+func (r *avroReader) string() string {
+	return string(r.bytes(int(r.long())))
+}
+
+func TestAvroLong(t *testing.T) {
+	for n, expected := range map[int64][]byte{
+		0:    {0x00},
+		-1:   {0x01},
+		1:    {0x02},
+		-64:  {0x7f},
+		64:   {0x80, 0x01},
+		8192: {0x80, 0x80, 0x01},
+	} {
+		if b := appendAvroLong(nil, n); !bytes.Equal(b, expected) {
+			t.Fatalf("Expected %x for %d but got %x.", expected, n, b)
+		}
+	}
+}
+
+func TestAvroWriter(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		now = time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	)
+	w, err := newRecordWriter(&buf, formatAvro, nil)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	w.write(&record{Time: now, Target: "192.0.2.1:443", RTT: 1.5, Tunneled: true})
+	w.write(&record{Time: now, Target: "192.0.2.2:443", Error: "connection refused"})
+
+	r := &avroReader{bufio.NewReader(&buf), t}
+	if magic := r.bytes(4); !bytes.Equal(magic, avroMagic) {
+		t.Fatalf("Expected magic %q but got %q.", avroMagic, magic)
+	}
+	meta := make(map[string]string)
+	for n := r.long(); n > 0; n-- {
+		key := r.string()
+		meta[key] = r.string()
+	}
+	if n := r.long(); n != 0 {
+		t.Fatalf("Expected end of metadata but got %d entries.", n)
+	}
+	if meta["avro.codec"] != "null" || meta["avro.schema"] != recordAvroSchema {
+		t.Fatalf("Unexpected metadata: %v", meta)
+	}
+	sync := r.bytes(16)
+
+	for _, expected := range []*record{
+		{Time: now, Target: "192.0.2.1:443", RTT: 1.5, Tunneled: true},
+		{Time: now, Target: "192.0.2.2:443", Error: "connection refused"},
+	} {
+		if count := r.long(); count != 1 {
+			t.Fatalf("Expected one record per block but got %d.", count)
+		}
+		r.long() // The block's size in bytes.
+		got := &record{
+			Time:   time.UnixMicro(r.long()).UTC(),
+			Target: r.string(),
+			RTT:    math.Float64frombits(binary.LittleEndian.Uint64(r.bytes(8))),
+		}
+		got.Tunneled = r.bytes(1)[0] == 1
+		got.Error, got.Sig = r.string(), r.string()
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Expected record %+v but got %+v.", expected, got)
+		}
+		if b := r.bytes(16); !bytes.Equal(b, sync) {
+			t.Fatalf("Expected sync marker %x but got %x.", sync, b)
+		}
+	}
+}
+
+// TestAvroSchema fails if the record struct and our Avro schema diverge.
+func TestAvroSchema(t *testing.T) {
+	var schema struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(recordAvroSchema), &schema); err != nil {
+		t.Fatalf("Failed to decode Avro schema: %v", err)
+	}
+	var avroFields, jsonFields []string
+	for _, f := range schema.Fields {
+		avroFields = append(avroFields, f.Name)
+	}
+	typ := reflect.TypeOf(record{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ","); name != "" {
+			jsonFields = append(jsonFields, name)
+		}
+	}
+	if !reflect.DeepEqual(avroFields, jsonFields) {
+		t.Fatalf("Avro fields %v don't match record fields %v.", avroFields, jsonFields)
+	}
+}
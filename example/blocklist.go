@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+// loadBlocklistFile parses the blocklist file at the given path.
+func loadBlocklistFile(path string) ([]*net.IPNet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return zerotrace.ParseBlocklist(f)
+}
+
+// blocklistFetcher keeps the given blocklist in sync with a blocklist that's
+// served over HTTP(S), e.g., by an S3 bucket.  We remember the blocklist's
+// ETag, so we only download and parse it if it changed.
+type blocklistFetcher struct {
+	url    string
+	etag   string
+	client *http.Client
+	list   *zerotrace.Blocklist
+}
+
+// newBlocklistFetcher returns a new fetcher that updates the given blocklist
+// with the blocklist at the given URL.
+func newBlocklistFetcher(url string, list *zerotrace.Blocklist) *blocklistFetcher {
+	return &blocklistFetcher{
+		url:    url,
+		client: &http.Client{Timeout: 30 * time.Second},
+		list:   list,
+	}
+}
+
+// fetch fetches the remote blocklist and, if it changed since the last fetch,
+// atomically replaces our blocklist's networks.  The function returns true if
+// the blocklist changed.  If the remote blocklist is malformed, we keep the
+// current networks.
+func (f *blocklistFetcher) fetch(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return false, err
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	nets, err := zerotrace.ParseBlocklist(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	f.list.Set(nets)
+	f.etag = resp.Header.Get("ETag")
+	return true, nil
+}
+
+// run fetches the remote blocklist at the given interval, forever.
+func (f *blocklistFetcher) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		changed, err := f.fetch(context.Background())
+		if err != nil {
+			l.Printf("Error fetching blocklist: %v", err)
+			continue
+		}
+		if changed {
+			l.Printf("Updated blocklist to %d network(s).", f.list.Len())
+		}
+	}
+}
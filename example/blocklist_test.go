@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestBlocklistFetcher(t *testing.T) {
+	var (
+		body     = "10.0.0.0/8\n"
+		etag     = `"v1"`
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	var (
+		list = zerotrace.NewBlocklist(nil)
+		f    = newBlocklistFetcher(srv.URL, list)
+		ctx  = context.Background()
+	)
+	changed, err := f.fetch(ctx)
+	if err != nil || !changed {
+		t.Fatalf("Expected changed blocklist but got %v (error: %v).", changed, err)
+	}
+	if !list.Contains(net.ParseIP("10.1.2.3")) {
+		t.Fatal("Expected fetched network to be blocklisted.")
+	}
+
+	// An unchanged blocklist isn't downloaded again.
+	changed, err = f.fetch(ctx)
+	if err != nil || changed {
+		t.Fatalf("Expected unchanged blocklist but got %v (error: %v).", changed, err)
+	}
+
+	// A malformed blocklist leaves the current one in place.
+	body, etag = "foo\n", `"v2"`
+	if _, err := f.fetch(ctx); err == nil {
+		t.Fatal("Expected error for malformed blocklist.")
+	}
+	if !list.Contains(net.ParseIP("10.1.2.3")) {
+		t.Fatal("Expected malformed blocklist to be ignored.")
+	}
+
+	body, etag = "192.0.2.0/24\n", `"v3"`
+	changed, err = f.fetch(ctx)
+	if err != nil || !changed {
+		t.Fatalf("Expected changed blocklist but got %v (error: %v).", changed, err)
+	}
+	if list.Contains(net.ParseIP("10.1.2.3")) || !list.Contains(net.ParseIP("192.0.2.1")) {
+		t.Fatal("Expected blocklist to be replaced.")
+	}
+	if requests != 4 {
+		t.Fatalf("Expected 4 requests but got %d.", requests)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+// cacheEntry is a cached measurement result.
+type cacheEntry struct {
+	res client.Result
+	t   time.Time
+}
+
+// resultCache caches each client's measurement result for a window, so that
+// clients that reload the page get their cached result instead of being
+// measured again.  It's safe for concurrent use.  A nil resultCache caches
+// nothing.
+type resultCache struct {
+	sync.Mutex // Guards entries.
+	window     time.Duration
+	entries    map[string]*cacheEntry
+	now        func() time.Time
+}
+
+// newResultCache returns a new result cache that keeps results for the given
+// window, or nil if the window is zero.
+func newResultCache(window time.Duration) *resultCache {
+	if window <= 0 {
+		return nil
+	}
+	return &resultCache{
+		window:  window,
+		entries: make(map[string]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+// cacheKey returns the cache key of the client that sent the given request:
+// its IP address and user agent.
+func cacheKey(r *http.Request) string {
+	return remoteHost(r) + " " + r.UserAgent()
+}
+
+// get returns the given client's cached result, if it's still fresh.
+func (c *resultCache) get(key string) (client.Result, bool) {
+	if c == nil {
+		return client.Result{}, false
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	e, exists := c.entries[key]
+	if !exists || c.now().Sub(e.t) > c.window {
+		return client.Result{}, false
+	}
+	return e.res, true
+}
+
+// put caches the given client's result and forgets stale results.  Our cache
+// keys don't tell apart participants who share an address and browser, e.g.,
+// behind a NAT, so we drop the result's session ID, which grants access to
+// the participant's data.
+func (c *resultCache) put(key string, res client.Result) {
+	if c == nil {
+		return
+	}
+	res.SessionID = ""
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.now()
+	for k, e := range c.entries {
+		if now.Sub(e.t) > c.window {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &cacheEntry{res: res, t: now}
+}
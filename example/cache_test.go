@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+func TestResultCache(t *testing.T) {
+	var (
+		now = time.Now()
+		c   = newResultCache(time.Hour)
+		res = client.Result{RTT: 12.5}
+	)
+	c.now = func() time.Time { return now }
+
+	if _, exists := c.get("foo"); exists {
+		t.Fatal("Expected empty cache.")
+	}
+	c.put("foo", res)
+	now = now.Add(59 * time.Minute)
+	if cached, exists := c.get("foo"); !exists || cached != res {
+		t.Fatalf("Expected cached result %+v but got %+v.", res, cached)
+	}
+
+	// Once the window passed, the result is stale and eventually forgotten.
+	now = now.Add(2 * time.Minute)
+	if _, exists := c.get("foo"); exists {
+		t.Fatal("Expected stale result not to be returned.")
+	}
+	c.put("bar", res)
+	if len(c.entries) != 1 {
+		t.Fatalf("Expected stale result to be forgotten but got %d entries.", len(c.entries))
+	}
+}
+
+func TestResultCacheSessionID(t *testing.T) {
+	c := newResultCache(time.Hour)
+	c.put("foo", client.Result{RTT: 12.5, SessionID: "secret"})
+	// Whoever else shares the client's cache key mustn't get its session.
+	if cached, _ := c.get("foo"); cached.SessionID != "" || cached.RTT != 12.5 {
+		t.Fatalf("Expected cached result without session ID but got %+v.", cached)
+	}
+}
+
+func TestNilResultCache(t *testing.T) {
+	c := newResultCache(0)
+	if c != nil {
+		t.Fatal("Expected nil cache for zero window.")
+	}
+	c.put("foo", client.Result{})
+	if _, exists := c.get("foo"); exists {
+		t.Fatal("Expected nil cache to cache nothing.")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/wss", nil)
+	r.RemoteAddr = "192.0.2.1:54321"
+	r.Header.Set("User-Agent", "foo")
+	if key := cacheKey(r); key != "192.0.2.1 foo" {
+		t.Fatalf("Unexpected cache key %q.", key)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The environment variables that may hold our PEM-encoded certificate chain
+// and private key, e.g., as injected by a secret manager, instead of files.
+const (
+	certEnvVar = "ZEROTRACE_TLS_CERT"
+	keyEnvVar  = "ZEROTRACE_TLS_KEY"
+)
+
+var (
+	errNoKeyMaterial   = errors.New("no key material")
+	errCertExpired     = errors.New("certificate expired")
+	errCertNotYetValid = errors.New("certificate not yet valid")
+)
+
+// hasStaticCert returns true if we were given a certificate instead of having
+// to obtain one from Let's Encrypt.
+func hasStaticCert(certFile string) bool {
+	return certFile != "" || os.Getenv(certEnvVar) != ""
+}
+
+// readKeyMaterial returns the contents of the file at the given path or, if
+// the path is empty, the value of the given environment variable.
+func readKeyMaterial(path, envVar string) ([]byte, error) {
+	if path != "" {
+		return os.ReadFile(path)
+	}
+	if v := os.Getenv(envVar); v != "" {
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("%w: set a file or %s", errNoKeyMaterial, envVar)
+}
+
+// loadCertificate loads our certificate chain and private key from the given
+// files or, if a path is empty, from the corresponding environment variable.
+// We refuse certificates that aren't valid at the given time, so a stale
+// certificate fails our start rather than every client's TLS handshake.
+func loadCertificate(certFile, keyFile string, now time.Time) (*tls.Certificate, error) {
+	certPEM, err := readKeyMaterial(certFile, certEnvVar)
+	if err != nil {
+		return nil, fmt.Errorf("reading certificate: %w", err)
+	}
+	keyPEM, err := readKeyMaterial(keyFile, keyEnvVar)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	if now.After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("%w on %s", errCertExpired, cert.Leaf.NotAfter.UTC())
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return nil, fmt.Errorf("%w until %s", errCertNotYetValid, cert.Leaf.NotBefore.UTC())
+	}
+	return &cert, nil
+}
+
+// certReloader serves our static certificate and swaps in a new one when the
+// certificate files change, or when we're told to via SIGHUP, so a renewed
+// certificate doesn't require a restart that kills in-flight measurements.
+// It's safe for concurrent use.
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	// modTime is the most recent modification time of the certificate
+	// files, as of our last reload.
+	modTime time.Time
+	now     func() time.Time
+}
+
+// newCertReloader returns a new certReloader for the given files (see
+// loadCertificate), which must hold a valid certificate.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// filesModTime returns the most recent modification time of our certificate
+// files.  Key material from the environment never changes.
+func (c *certReloader) filesModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		if path == "" {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// reload loads our certificate and swaps it in.  If loading fails, we keep
+// serving the current certificate.
+func (c *certReloader) reload() error {
+	modTime := c.filesModTime()
+	cert, err := loadCertificate(c.certFile, c.keyFile, c.now())
+	if err != nil {
+		return err
+	}
+	c.modTime = modTime
+	c.cert.Store(cert)
+	return nil
+}
+
+// getCertificate returns our current certificate.  It implements
+// tls.Config.GetCertificate.
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// watch reloads our certificate whenever the given channel receives a signal,
+// and whenever the certificate files changed, which we check at the given
+// interval, unless it's zero or negative.  Renewal tools often write the
+// certificate and key one after another, so a reload may fail until both are
+// written; we retry at the next check.
+func (c *certReloader) watch(sigs <-chan os.Signal, interval time.Duration) {
+	var tick <-chan time.Time // Blocks forever unless we check the files.
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-sigs:
+			l.Println("Reloading TLS certificate on signal.")
+		case <-tick:
+			if !c.filesModTime().After(c.modTime) {
+				continue
+			}
+			l.Println("Reloading TLS certificate because its files changed.")
+		}
+		if err := c.reload(); err != nil {
+			l.Printf("Error reloading TLS certificate; keeping the current one: %v", err)
+			continue
+		}
+		cert := c.cert.Load()
+		l.Printf("Reloaded TLS certificate for %v, valid until %s.",
+			cert.Leaf.DNSNames, cert.Leaf.NotAfter.UTC())
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a new self-signed certificate and its private key to
+// files in a temporary directory, and returns their paths and PEM encodings.
+func writeTestCert(t *testing.T) (string, string, []byte, []byte) {
+	t.Helper()
+
+	cert := newTestCert(t, "server", nil)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	var (
+		dir      = t.TempDir()
+		certFile = filepath.Join(dir, "cert.pem")
+		keyFile  = filepath.Join(dir, "key.pem")
+		certPEM  = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+		keyPEM   = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	)
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	return certFile, keyFile, certPEM, keyPEM
+}
+
+func TestLoadCertificate(t *testing.T) {
+	certFile, keyFile, certPEM, keyPEM := writeTestCert(t)
+	now := time.Now()
+
+	cert, err := loadCertificate(certFile, keyFile, now)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "server" {
+		t.Fatal("Expected certificate's leaf to be parsed.")
+	}
+
+	// Test certificates are valid for an hour before and after now.
+	if _, err := loadCertificate(certFile, keyFile, now.Add(2*time.Hour)); !errors.Is(err, errCertExpired) {
+		t.Fatalf("Expected error %v but got %v.", errCertExpired, err)
+	}
+	if _, err := loadCertificate(certFile, keyFile, now.Add(-2*time.Hour)); !errors.Is(err, errCertNotYetValid) {
+		t.Fatalf("Expected error %v but got %v.", errCertNotYetValid, err)
+	}
+
+	// Key material may come from the environment instead.
+	if _, err := loadCertificate("", "", now); !errors.Is(err, errNoKeyMaterial) {
+		t.Fatalf("Expected error %v but got %v.", errNoKeyMaterial, err)
+	}
+	t.Setenv(certEnvVar, string(certPEM))
+	t.Setenv(keyEnvVar, string(keyPEM))
+	if !hasStaticCert("") {
+		t.Fatal("Expected certificate in environment to count as static certificate.")
+	}
+	if _, err := loadCertificate("", "", now); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	certFile, keyFile, _, _ := writeTestCert(t)
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	first, _ := c.getCertificate(nil)
+
+	// Swap in a new certificate, as a renewal would.
+	newCertFile, newKeyFile, _, _ := writeTestCert(t)
+	for src, dst := range map[string]string{newCertFile: certFile, newKeyFile: keyFile} {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", src, err)
+		}
+		if err := os.WriteFile(dst, data, 0o600); err != nil {
+			t.Fatalf("Failed to write %s: %v", dst, err)
+		}
+	}
+	sigs := make(chan os.Signal, 1)
+	// We don't check the files, so only the signal reloads them.
+	go c.watch(sigs, 0)
+	sigs <- syscall.SIGHUP
+	deadline := time.Now().Add(time.Second)
+	for {
+		if cert, _ := c.getCertificate(nil); cert != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected certificate to be reloaded.")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken certificate doesn't replace the current one.
+	current, _ := c.getCertificate(nil)
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := c.reload(); err == nil {
+		t.Fatal("Expected error when reloading broken certificate.")
+	}
+	if cert, _ := c.getCertificate(nil); cert != current {
+		t.Fatal("Expected current certificate to be kept.")
+	}
+}
+
+func TestCertReloaderModTime(t *testing.T) {
+	certFile, keyFile, _, _ := writeTestCert(t)
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if c.filesModTime().After(c.modTime) {
+		t.Fatal("Expected no change right after loading.")
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatalf("Failed to touch private key: %v", err)
+	}
+	if !c.filesModTime().After(c.modTime) {
+		t.Fatal("Expected change after touching private key.")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+
+	"github.com/brave/zerotrace"
+)
+
+// debugState is the JSON representation of our runtime state, which helps with
+// hunting down leaks.
+type debugState struct {
+	ActiveSessions int64           `json:"active_sessions"`
+	Goroutines     int             `json:"goroutines"`
+	OpenFDs        int             `json:"open_fds"`
+	ZeroTrace      *zeroTraceState `json:"zerotrace,omitempty"`
+}
+
+// zeroTraceState is the JSON representation of zerotrace.Diagnostics.
+type zeroTraceState struct {
+	ActiveTraces    int   `json:"active_traces"`
+	Receivers       int   `json:"receivers"`
+	CaptureHandles  int   `json:"capture_handles"`
+	CaptureRestarts int64 `json:"capture_restarts"`
+	Senders         int   `json:"senders"`
+	SendQueueLen    int   `json:"send_queue_len"`
+	SendQueueCap    int   `json:"send_queue_cap"`
+	IPIDsInFlight   int   `json:"ipids_in_flight"`
+}
+
+// countOpenFDs returns the number of our open file descriptors, or -1 if we
+// can't tell, e.g., because there's no /proc file system.
+func countOpenFDs() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// getDebugStateHandler returns a handler that reports our runtime state.  The
+// state reveals nothing about clients but is only meant for operators, so the
+// handler belongs on the internal listener.
+func getDebugStateHandler(z zerotrace.Tracer, s *stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := &debugState{
+			ActiveSessions: s.active.Load(),
+			Goroutines:     runtime.NumGoroutine(),
+			OpenFDs:        countOpenFDs(),
+		}
+		if zt, ok := z.(*zerotrace.ZeroTrace); ok {
+			d := zt.Diagnostics()
+			state.ZeroTrace = &zeroTraceState{
+				ActiveTraces:    d.ActiveTraces,
+				Receivers:       d.Receivers,
+				CaptureHandles:  d.CaptureHandles,
+				CaptureRestarts: d.CaptureRestarts,
+				Senders:         d.Senders,
+				SendQueueLen:    d.SendQueueLen,
+				SendQueueCap:    d.SendQueueCap,
+				IPIDsInFlight:   d.IPIDsInFlight,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			l.Printf("Error writing debug state: %v", err)
+		}
+	}
+}
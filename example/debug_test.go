@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugStateHandler(t *testing.T) {
+	s := newStats()
+	s.active.Add(2)
+
+	w := httptest.NewRecorder()
+	getDebugStateHandler(&fakeTracer{}, s)(w, httptest.NewRequest("GET", "/debug/state", nil))
+
+	var state debugState
+	if err := json.NewDecoder(w.Body).Decode(&state); err != nil {
+		t.Fatalf("Failed to decode debug state: %v", err)
+	}
+	if state.ActiveSessions != 2 || state.Goroutines == 0 {
+		t.Fatalf("Unexpected debug state: %+v", state)
+	}
+	// A fake tracer has no internal state to report.
+	if state.ZeroTrace != nil {
+		t.Fatalf("Expected no ZeroTrace state but got: %+v", state.ZeroTrace)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	securityHeaders(getIdxHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	for name, value := range map[string]string{
+		"Strict-Transport-Security": "max-age=31536000",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "no-referrer",
+	} {
+		if got := w.Header().Get(name); got != value {
+			t.Fatalf("Expected header %s to be %q but got %q.", name, value, got)
+		}
+	}
+	// The index page replaces the default policy with one that allows its
+	// script.
+	csp := w.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "script-src '"+scriptHash(idxScript)+"'") {
+		t.Fatalf("Expected CSP to allow the index page's script but got %q.", csp)
+	}
+	if !strings.Contains(w.Body.String(), "<script>"+idxScript+"</script>") {
+		t.Fatal("Expected index page to contain the script whose hash we allow.")
+	}
+
+	// Other responses get the restrictive default policy.
+	w = httptest.NewRecorder()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	securityHeaders(ok).ServeHTTP(w, httptest.NewRequest("GET", "/config", nil))
+	if csp := w.Header().Get("Content-Security-Policy"); !strings.HasPrefix(csp, "default-src 'none'") {
+		t.Fatalf("Unexpected default CSP %q.", csp)
+	}
+}
+
+func TestScriptHash(t *testing.T) {
+	// The example from the CSP specification.
+	if h := scriptHash("alert('Hello, world.');"); h != "sha256-qznLcsROx4GACP2dm0UCKCzCG+HiZ1guq6ZZDob/Tng=" {
+		t.Fatalf("Unexpected script hash %q.", h)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"github.com/brave/zerotrace"
+)
+
+// loadPeeringDBFile loads the IXP prefixes of the PeeringDB dump at the given
+// path.
+func loadPeeringDBFile(path string) ([]*zerotrace.IXPPrefix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return zerotrace.ParsePeeringDB(f)
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	_ "net/http/pprof"
 	"os"
-	"text/template"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/brave/zerotrace"
+	"github.com/brave/zerotrace/pkg/client"
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/acme/autocert"
 )
@@ -19,53 +28,189 @@ var (
 	l = log.New(os.Stderr, "example: ", log.Ldate|log.Ltime|log.LUTC|log.Lshortfile)
 )
 
-func getIdxHandler(domain, addr string) http.HandlerFunc {
-	idxPage := `
-<!doctype html>
-<html lang="en">
-  <head>
-    <meta charset = "utf-8">
-    <title>ZeroTrace test</title>
-  </head>
-  <body>
-    <p>Status: <span id="status">Running</span></p>
-    <script>
-      function getLatencyWebSocket(endpoint) {
+// idxScript is the index page's script, which runs the measurement.  We keep
+// it separate from the page because our Content-Security-Policy allows it by
+// its hash.
+const idxScript = `
+      function getLatencyWebSocket(endpoint, cfg) {
         return new Promise(function(resolve, reject) {
           var socket = new WebSocket(endpoint);
+          var timer = setTimeout(function() {
+            socket.close();
+            reject("Measurement timed out.");
+          }, cfg.timeout_ms);
           socket.onerror = function (err) {
+            clearTimeout(timer);
             reject(err.toString());
           }
           socket.onclose = function(event) {
+            clearTimeout(timer);
             resolve();
           }
           socket.onmessage = function(event) {
-            socket.send(event.data);
+            var msg = JSON.parse(event.data);
+            if (msg.type === "result") {
+              document.getElementById("result").textContent = JSON.stringify(msg.result);
+              if (cfg.session_endpoint && msg.result.session_id) {
+                var url = cfg.session_endpoint + encodeURIComponent(msg.result.session_id);
+                document.getElementById("download").href = url;
+                document.getElementById("summary").href = url + "?format=text";
+                document.getElementById("data").hidden = false;
+              }
+              return;
+            }
+            if (msg.type === "ping") {
+              socket.send(JSON.stringify({version: msg.version, type: "pong", seq: msg.seq}));
+            }
           }
+          socket.onopen = function() {
+            var timings = performance.getEntriesByType("navigation")
+              .concat(performance.getEntriesByType("resource"))
+              .slice(0, 64)
+              .map(function(e) {
+                var tlsStart = e.secureConnectionStart || e.connectEnd;
+                return {
+                  name: e.name,
+                  type: e.entryType,
+                  dns_ms: Math.max(0, e.domainLookupEnd - e.domainLookupStart),
+                  connect_ms: Math.max(0, tlsStart - e.connectStart),
+                  tls_ms: Math.max(0, e.connectEnd - tlsStart),
+                  ttfb_ms: Math.max(0, e.responseStart - e.requestStart)
+                };
+              });
+            if (timings.length > 0) {
+              socket.send(JSON.stringify({version: cfg.schema_version, type: "timings", timings: timings}));
+            }
+            if (cfg.stun_server) {
+              gatherCandidates(cfg.stun_server).then(function(candidates) {
+                if (candidates.length > 0 && socket.readyState === WebSocket.OPEN) {
+                  socket.send(JSON.stringify({version: cfg.schema_version, type: "candidates", candidates: candidates}));
+                }
+              });
+            }
+            var conn = navigator.connection;
+            if (conn && conn.effectiveType) {
+              socket.send(JSON.stringify({version: cfg.schema_version, type: "connection", connection: {
+                effective_type: conn.effectiveType,
+                rtt_ms: conn.rtt || 0,
+                downlink_mbps: conn.downlink || 0,
+                save_data: !!conn.saveData
+              }}));
+            }
+          }
+        });
+      }
+      function gatherCandidates(stunServer) {
+        return new Promise(function(resolve) {
+          var candidates = [];
+          var pc = new RTCPeerConnection({iceServers: [{urls: stunServer}]});
+          var finish = function() {
+            pc.close();
+            resolve(candidates.slice(0, 32));
+          };
+          var timer = setTimeout(finish, 5000);
+          pc.onicecandidate = function(event) {
+            if (!event.candidate) {
+              clearTimeout(timer);
+              finish();
+              return;
+            }
+            if (event.candidate.type && event.candidate.address) {
+              candidates.push({type: event.candidate.type, address: event.candidate.address});
+            }
+          };
+          pc.createDataChannel("");
+          pc.createOffer().then((offer) => pc.setLocalDescription(offer)).catch(finish);
         });
       }
-      getLatencyWebSocket("wss://{{.WssEndpoint}}/wss").then(() => {
-        document.getElementById("status").innerHTML = "Done.";
-      });
-    </script>
+      function leadingZeroBits(hash) {
+        var n = 0;
+        for (var i = 0; i < hash.length; i++) {
+          if (hash[i] !== 0) {
+            return n + Math.clz32(hash[i]) - 24;
+          }
+          n += 8;
+        }
+        return n;
+      }
+      async function solveChallenge(ch) {
+        var enc = new TextEncoder();
+        for (var nonce = 0; ; nonce++) {
+          var hash = await crypto.subtle.digest("SHA-256", enc.encode(ch.challenge + ":" + nonce));
+          if (leadingZeroBits(new Uint8Array(hash)) >= ch.bits) {
+            return nonce.toString();
+          }
+        }
+      }
+      async function getEndpoint(cfg) {
+        if (!cfg.challenge_endpoint) {
+          return cfg.wss_endpoint;
+        }
+        var ch = await fetch(cfg.challenge_endpoint).then((resp) => resp.json());
+        var endpoint = new URL(cfg.wss_endpoint);
+        endpoint.searchParams.set("challenge", ch.challenge);
+        endpoint.searchParams.set("nonce", await solveChallenge(ch));
+        return endpoint.toString();
+      }
+      fetch("/config")
+        .then((resp) => resp.json())
+        .then(async (cfg) => getLatencyWebSocket(await getEndpoint(cfg), cfg))
+        .then(() => {
+          document.getElementById("status").textContent = "Done.";
+        })
+        .catch((err) => {
+          document.getElementById("status").textContent = "Failed: " + err;
+        });
+    `
+
+func getIdxHandler() http.HandlerFunc {
+	idxPage := `
+<!doctype html>
+<html lang="en">
+  <head>
+    <meta charset = "utf-8">
+    <title>ZeroTrace test</title>
+  </head>
+  <body>
+    <p>Status: <span id="status">Running</span></p>
+    <p>Result: <span id="result"></span></p>
+    <p id="data" hidden>Your data: <a id="download">download (JSON)</a> or
+      <a id="summary">view summary</a></p>
+    <script>` + idxScript + `</script>
   </body>
 </html>`
-	idxTemplate := template.Must(template.New("idx").Parse(idxPage))
+	csp := "default-src 'none'; script-src '" + scriptHash(idxScript) +
+		"'; connect-src 'self'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		s := struct {
-			WssEndpoint string
-		}{
-			WssEndpoint: domain + addr,
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", csp)
+		if _, err := w.Write([]byte(idxPage)); err != nil {
+			l.Printf("Error writing index page: %v", err)
 		}
-		if err := idxTemplate.Execute(w, s); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	}
+}
+
+func getConfigHandler(cfg *client.ServerConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cfg); err != nil {
+			l.Printf("Error writing client configuration: %v", err)
 		}
 	}
 }
 
-func getWssHandler(z *zerotrace.ZeroTrace) http.HandlerFunc {
+func getWssHandler(
+	z zerotrace.Tracer,
+	a *alerter,
+	s *stats,
+	cache *resultCache,
+	b *broker,
+	refs *references,
+	rs *ripestat,
+	sessions *sessionStore,
+	candidateWait time.Duration,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		l.Println("Handling new WebSocket request.")
 
@@ -81,28 +226,99 @@ func getWssHandler(z *zerotrace.ZeroTrace) http.HandlerFunc {
 		}
 		defer c.Close()
 		l.Println("Successfully upgraded request to WebSocket.")
+		s.active.Add(1)
+		defer s.active.Add(-1)
 
-		done := make(chan bool)
+		// Spare clients that reload the page from being measured again.
+		key := cacheKey(r)
+		if res, exists := cache.get(key); exists {
+			l.Println("Returning cached result to client.")
+			if err := c.WriteJSON(client.NewResultMessage(&res)); err != nil {
+				l.Printf("Error writing result to WebSocket conn: %v", err)
+			}
+			return
+		}
+
+		var (
+			res       client.Result
+			done      = make(chan bool)
+			telemetry = newClientTelemetry(remoteHost(r))
+		)
 		// Start 0trace measurement in the background.
 		go func() {
+			// The control measurements run at the same time as the
+			// client's, so they see the same server load.
+			refsDone := make(chan []*record)
+			go func() { refsDone <- refs.measure() }()
+			netDone := make(chan *networkInfo)
+			go func() {
+				info, err := rs.lookup(context.Background(), net.ParseIP(remoteHost(r)))
+				if err != nil {
+					l.Printf("Error looking up client network in RIPEstat: %v", err)
+				}
+				netDone <- info
+			}()
+
 			myConn := c.UnderlyingConn()
-			rtt, err := z.CalcRTT(myConn)
+			trace, err := recoverTrace(func() (*zerotrace.Result, error) {
+				return z.Trace(myConn)
+			})
+			m := &measurement{Time: time.Now().UTC(), TLS: newTLSInfo(r.TLS)}
+			m.References = <-refsDone
+			m.Network = <-netDone
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				s.record(0, err)
+				l.Printf("Error running 0trace measurement: %v", err)
+				res.Error = err.Error()
+				m.Error = errorClass(err)
+			} else {
+				// The session ID names the session's pcap file, if any.
+				l.Printf("Round trip time to client: %dms (session %s)",
+					trace.RTT.Milliseconds(), trace.SessionID)
+				res.RTT = float64(trace.RTT) / float64(time.Millisecond)
+				res.SessionID = trace.SessionID
+				s.record(trace.RTT, nil)
+				cache.put(key, res)
+				m.SessionID, m.RTT, m.Tunneled = trace.SessionID, res.RTT, trace.Tunneled
+				m.SelfLatency = float64(trace.SelfLatency) / float64(time.Millisecond)
+			}
+			// Candidates that arrive after we stored the measurement
+			// would be lost, and their absence read as no mismatch.
+			if candidateWait > 0 && !telemetry.awaitCandidates(candidateWait) {
+				l.Println("Client reported no ICE candidates.")
+			}
+			telemetry.fill(m)
+			sessions.put(m)
+			b.publish(m)
+			// Blocked clients are no sign of broken data collection.
+			if err != zerotrace.ErrBlocked {
+				a.record(err)
 			}
-			l.Printf("Round trip time to client: %dms", rtt.Milliseconds())
 			close(done)
 		}()
 
 		// Keep the client around while the measurement is running because we need
 		// to take advantage of the already-established TCP connection.
+		var (
+			seq      int
+			ticker   = time.NewTicker(time.Second)
+			rejected = make(chan struct{})
+		)
+		defer ticker.Stop()
+		go readClientMessages(c, rejected, telemetry)
 		for {
 			select {
 			case <-done:
 				l.Println("0trace measurement is done.")
+				if err := c.WriteJSON(client.NewResultMessage(&res)); err != nil {
+					l.Printf("Error writing result to WebSocket conn: %v", err)
+				}
 				return
-			case <-time.Tick(time.Second):
-				if err := c.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+			case <-rejected:
+				return
+			case <-ticker.C:
+				seq++
+				if err := c.WriteJSON(client.NewPing(seq)); err != nil {
 					l.Printf("Error writing message to WebSocket conn: %v", err)
 				}
 			}
@@ -110,40 +326,452 @@ func getWssHandler(z *zerotrace.ZeroTrace) http.HandlerFunc {
 	}
 }
 
+// readClientMessages reads and validates the client's messages until the
+// connection is closed, and records the client's telemetry in the given
+// clientTelemetry.  If the client sends a malformed message, we close the
+// connection and close the given channel, so it can't corrupt our data.
+func readClientMessages(c *websocket.Conn, rejected chan struct{}, t *clientTelemetry) {
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg, err := client.ParseMessage(data)
+		if err == nil {
+			err = t.record(msg)
+		}
+		if err != nil {
+			l.Printf("Rejecting malformed client message: %v", err)
+			closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "malformed message")
+			_ = c.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+			close(rejected)
+			return
+		}
+	}
+}
+
 func main() {
-	var addr, domain, ifaceName string
+	var (
+		addr, domain, ifaceName, pprofAddr string
+		scheduleFile, seriesFile           string
+		targetsFile, format, pcapDir       string
+		blocklistFile, blocklistURL        string
+		peeringDBFile, itdkNodesFile       string
+		alertWebhook                       string
+		pcapRetention                      int
+		alertThreshold                     float64
+		clientTimeout, alertWindow         time.Duration
+		traceBudget, blocklistRefresh      time.Duration
+		dedupWindow                        time.Duration
+		hmacKeyFile, streamToken           string
+		hmacKey                            []byte
+		simulate                           bool
+		simPath                            string
+		simRTT, simJitter                  time.Duration
+		simLoss, faultLoss, faultReorder   float64
+		faultDelay                         time.Duration
+		adminAddr, adminClientCA           string
+		apiKeysFile, createAPIKey          string
+		certFile, keyFile, certCache       string
+		tlsMinVersion, tlsCurves, tlsALPN  string
+		referenceTargets, tsSource         string
+		stunServer                         string
+		maxTraces, subnetLimit, powBits    int
+		traceQueueTimeout, subnetWindow    time.Duration
+		calibrationInterval, ripestatTTL   time.Duration
+		sessionRetention                   time.Duration
+		certReloadInterval                 time.Duration
+		useRIPEstat, runSelfTest           bool
+		selfTestTarget                     string
+		keys                               *keyStore
+	)
 	flag.StringVar(&ifaceName, "iface", "eth0", "Network interface name to listen on (default: eth0)")
 	flag.StringVar(&addr, "addr", ":8443", "Address to listen on (default: :8443)")
 	flag.StringVar(&domain, "domain", "", "The Web server's domain name.")
+	flag.DurationVar(&clientTimeout, "client-timeout", 2*time.Minute, "Time after which clients give up on a measurement (default: 2m)")
+	flag.StringVar(&pprofAddr, "pprof", "", "Internal address to expose pprof and /debug/state endpoints on, e.g. localhost:6060 (default: disabled)")
+	flag.StringVar(&scheduleFile, "schedule", "", "File of targets to measure periodically, one \"host:port interval\" per line")
+	flag.StringVar(&seriesFile, "series", "series.jsonl", "File to append scheduled measurements to (default: series.jsonl)")
+	flag.StringVar(&targetsFile, "targets", "", "File of targets to measure once, one \"host:port\" per line; results go to stdout")
+	flag.StringVar(&format, "format", formatJSON, "Output format of -targets: json, text, warts, atlas, or avro (default: json)")
+	flag.StringVar(&pcapDir, "pcap-dir", "", "Directory to write each session's captured packets to, for debugging (default: disabled)")
+	flag.IntVar(&pcapRetention, "pcap-retention", 100, "Number of session pcap files to keep in -pcap-dir; 0 keeps all (default: 100)")
+	flag.DurationVar(&traceBudget, "trace-budget", time.Minute, "Total time that a measurement's traceroutes may take; 0 means no budget (default: 1m)")
+	flag.StringVar(&blocklistFile, "blocklist", "", "File of networks never to trace, one CIDR or address per line (default: none)")
+	flag.StringVar(&blocklistURL, "blocklist-url", "", "HTTP(S) URL of a blocklist to fetch periodically, e.g. an S3 object; overrides -blocklist (default: none)")
+	flag.DurationVar(&blocklistRefresh, "blocklist-refresh", 15*time.Minute, "Interval at which we refresh -blocklist-url (default: 15m)")
+	flag.DurationVar(&dedupWindow, "dedup-window", 0, "Window in which clients with the same IP address and user agent get their cached result instead of a new measurement (default: disabled)")
+	flag.StringVar(&hmacKeyFile, "hmac-key", "", "File containing the key (at least 32 bytes) with which we sign JSON measurement records (default: unsigned)")
+	flag.StringVar(&streamToken, "stream-token", "", "Bearer token that subscribers of the /api/v1/stream event stream must present (default: stream disabled)")
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "Slack-compatible webhook URL to alert operators on measurement failures (default: disabled)")
+	flag.Float64Var(&alertThreshold, "alert-threshold", 0.5, "Fraction of failed measurements within -alert-window that triggers an alert (default: 0.5)")
+	flag.DurationVar(&alertWindow, "alert-window", 10*time.Minute, "Sliding window over which we compute the failure rate (default: 10m)")
+	flag.BoolVar(&simulate, "simulate", false, "Return synthetic measurements instead of sending trace packets, for development (default: false)")
+	flag.StringVar(&simPath, "sim-path", "192.0.2.1,198.51.100.1,203.0.113.1", "Comma-separated router addresses of the path that -simulate traces, excluding the client")
+	flag.DurationVar(&simRTT, "sim-rtt", 30*time.Millisecond, "Mean RTT to the client in -simulate mode (default: 30ms)")
+	flag.DurationVar(&simJitter, "sim-jitter", 5*time.Millisecond, "Standard deviation of RTTs in -simulate mode (default: 5ms)")
+	flag.Float64Var(&simLoss, "sim-loss", 0.1, "Probability that a trace packet is lost in -simulate mode (default: 0.1)")
+	flag.StringVar(&adminAddr, "admin-addr", "", "Address of a separate listener for the stats and stream APIs that requires client certificates (default: serve APIs publicly)")
+	flag.StringVar(&adminClientCA, "admin-client-ca", "", "PEM file of the CA certificates that sign the client certificates of -admin-addr")
+	flag.StringVar(&apiKeysFile, "api-keys", "", "JSON file of API keys and their scopes; if set, all APIs require a key as bearer token (default: APIs unauthenticated)")
+	flag.StringVar(&createAPIKey, "create-api-key", "", "Create an API key in -api-keys, given as \"name:scope,...\", print it, and exit")
+	flag.StringVar(&certFile, "cert-file", "", "PEM file of the TLS certificate chain, instead of obtaining one from Let's Encrypt (default: $"+certEnvVar+" or Let's Encrypt)")
+	flag.StringVar(&keyFile, "key-file", "", "PEM file of the private key of -cert-file (default: $"+keyEnvVar+")")
+	flag.StringVar(&certCache, "cert-cache", "certs", "Directory in which we cache Let's Encrypt certificates (default: certs)")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "1.2", "Minimum TLS version that we accept: 1.2 or 1.3 (default: 1.2)")
+	flag.StringVar(&tlsCurves, "tls-curves", "", "Comma-separated curves in order of preference, out of X25519, P256, P384, and P521 (default: Go's preferences)")
+	flag.StringVar(&tlsALPN, "tls-alpn", "h2,http/1.1", "Comma-separated ALPN protocols that we offer, out of h2 and http/1.1 (default: h2,http/1.1)")
+	flag.IntVar(&maxTraces, "max-traces", 64, "Maximum number of measurements that may run concurrently; 0 means no limit (default: 64)")
+	flag.DurationVar(&traceQueueTimeout, "trace-queue-timeout", 10*time.Second, "Time that a measurement waits for a slot under -max-traces before we refuse it (default: 10s)")
+	flag.IntVar(&subnetLimit, "subnet-limit", 256, "Maximum number of measurements toward each /24 or /48 within -subnet-window; 0 means no limit (default: 256)")
+	flag.DurationVar(&subnetWindow, "subnet-window", time.Hour, "Sliding window of -subnet-limit (default: 1h)")
+	flag.IntVar(&powBits, "pow-bits", 0, "Number of leading zero bits of the proof-of-work challenge that clients must solve before we measure them; each bit doubles their work (default: disabled)")
+	flag.StringVar(&referenceTargets, "reference-targets", "", "Comma-separated host:port tuples of stable hosts that we control, which we measure alongside each client for calibration (default: none)")
+	flag.DurationVar(&calibrationInterval, "calibration-interval", 0, "Interval at which we measure our own send and capture latency over the loopback interface, which measurements report (default: disabled)")
+	flag.StringVar(&tsSource, "timestamp-source", "", "Source of capture timestamps, e.g. adapter for NIC hardware timestamps (default: libpcap's default)")
+	flag.BoolVar(&useRIPEstat, "ripestat", false, "Look up the prefix, origin ASes, abuse contacts, and RPKI status of each client's network in RIPEstat (default: false)")
+	flag.DurationVar(&ripestatTTL, "ripestat-ttl", 24*time.Hour, "Time for which we cache RIPEstat lookups of a client's network (default: 24h)")
+	flag.StringVar(&peeringDBFile, "peeringdb", "", "PeeringDB dump whose IXP peering LANs we flag hops in (default: none)")
+	flag.StringVar(&itdkNodesFile, "itdk-nodes", "", "Nodes file of a CAIDA ITDK snapshot, whose router aliases don't count as path changes (default: none)")
+	flag.StringVar(&stunServer, "stun-server", "", "STUN server, e.g. stun:stun.example.com:3478, with which browsers gather WebRTC ICE candidates that we compare against their HTTP address; measurements wait up to 6s for the candidates (default: disabled)")
+	flag.DurationVar(&sessionRetention, "session-retention", 7*24*time.Hour, "Time for which participants can download their measurement's data via the link on the index page; 0 disables downloads (default: 168h)")
+	flag.DurationVar(&certReloadInterval, "cert-reload-interval", time.Minute, "Interval at which we check -cert-file and -key-file for changes and reload them; 0 disables checks, but SIGHUP always reloads them immediately (default: 1m)")
+	flag.BoolVar(&runSelfTest, "selftest", false, "Run a self-test of the measurement pipeline against -selftest-target, print a pass/fail summary, and exit with status 1 if it failed")
+	flag.StringVar(&selfTestTarget, "selftest-target", "", "host:port tuple beyond our first router that the self-test measures (default: the first -reference-targets)")
+	flag.Float64Var(&faultLoss, "fault-loss", 0, "For testing, probability that we drop each captured response to a trace packet (default: 0)")
+	flag.DurationVar(&faultDelay, "fault-delay", 0, "For testing, time by which we delay each captured response to a trace packet (default: 0)")
+	flag.Float64Var(&faultReorder, "fault-reorder", 0, "For testing, probability that we hold back a captured response until after the next one (default: 0)")
 	flag.Parse()
 
-	if domain == "" {
+	if apiKeysFile != "" {
+		var err error
+		if keys, err = loadKeyStore(apiKeysFile); err != nil {
+			l.Fatalf("Error loading API keys: %v", err)
+		}
+	}
+	// Creating an API key is an offline task, so it needs no -domain.
+	if createAPIKey != "" {
+		name, scopes, _ := strings.Cut(createAPIKey, ":")
+		if keys == nil {
+			l.Fatal("Specify the key file by using the -api-keys flag.")
+		}
+		key, err := keys.create(name, strings.Split(scopes, ","))
+		if err != nil {
+			l.Fatalf("Error creating API key: %v", err)
+		}
+		fmt.Println(key)
+		return
+	}
+	if domain == "" && targetsFile == "" && !runSelfTest {
 		l.Fatal("Specify domain name by using the -domain flag.")
 	}
+	policy, err := parseTLSPolicy(tlsMinVersion, tlsCurves, tlsALPN)
+	if err != nil {
+		l.Fatalf("Error parsing TLS policy: %v", err)
+	}
+	if (adminAddr == "") != (adminClientCA == "") {
+		l.Fatal("Specify both -admin-addr and -admin-client-ca, or neither.")
+	}
+	if hmacKeyFile != "" {
+		var err error
+		if hmacKey, err = loadKeyFile(hmacKeyFile); err != nil {
+			l.Fatalf("Error loading HMAC key: %v", err)
+		}
+	}
 
-	z := zerotrace.NewZeroTrace(zerotrace.NewDefaultConfig())
-	if err := z.Start(); err != nil {
-		l.Fatalf("Error starting ZeroTrace: %v", err)
+	cfg := zerotrace.NewDefaultConfig()
+	cfg.Interface = ifaceName
+	cfg.TraceBudget = traceBudget
+	cfg.MaxTraces = maxTraces
+	cfg.TraceQueueTimeout = traceQueueTimeout
+	cfg.SubnetLimit = subnetLimit
+	cfg.SubnetWindow = subnetWindow
+	cfg.CalibrationInterval = calibrationInterval
+	cfg.TimestampSource = tsSource
+	if blocklistURL != "" {
+		// We refuse to start without the blocklist rather than probe
+		// networks that asked us not to.
+		cfg.Blocklist = zerotrace.NewBlocklist(nil)
+		f := newBlocklistFetcher(blocklistURL, cfg.Blocklist)
+		if _, err := f.fetch(context.Background()); err != nil {
+			l.Fatalf("Error fetching blocklist: %v", err)
+		}
+		l.Printf("Fetched %d blocklisted network(s).", cfg.Blocklist.Len())
+		go f.run(blocklistRefresh)
+	} else if blocklistFile != "" {
+		nets, err := loadBlocklistFile(blocklistFile)
+		if err != nil {
+			l.Fatalf("Error loading blocklist: %v", err)
+		}
+		l.Printf("Loaded %d blocklisted network(s).", len(nets))
+		cfg.Blocklist = zerotrace.NewBlocklist(nets)
+	}
+	if peeringDBFile != "" {
+		prefixes, err := loadPeeringDBFile(peeringDBFile)
+		if err != nil {
+			l.Fatalf("Error loading PeeringDB dump: %v", err)
+		}
+		l.Printf("Loaded %d IXP prefix(es).", len(prefixes))
+		cfg.IXPs = zerotrace.NewIXPList(prefixes)
+	}
+	if itdkNodesFile != "" {
+		routers, err := loadITDKNodesFile(itdkNodesFile)
+		if err != nil {
+			l.Fatalf("Error loading ITDK nodes: %v", err)
+		}
+		l.Printf("Loaded %d router alias(es).", len(routers))
+		cfg.Aliases = zerotrace.NewAliases(routers)
+	}
+	if faultLoss > 0 || faultDelay > 0 || faultReorder > 0 {
+		l.Println("Injecting faults into trace responses; don't use these measurements.")
+		cfg.Faults = &zerotrace.Faults{
+			Loss:    faultLoss,
+			Delay:   faultDelay,
+			Reorder: faultReorder,
+		}
+	}
+	cfg.PcapDir = pcapDir
+	cfg.PcapRetention = pcapRetention
+	a := newAlerter(alertWebhook, alertThreshold, alertWindow)
+	refTargets, err := parseReferenceTargets(referenceTargets)
+	if err != nil {
+		l.Fatalf("Error parsing reference targets: %v", err)
+	}
+	var (
+		z, refTracer zerotrace.Tracer
+		healthy      = func() error { return nil }
+	)
+	if simulate {
+		path, err := parseSimPath(simPath)
+		if err != nil {
+			l.Fatalf("Error parsing simulated path: %v", err)
+		}
+		l.Println("Simulating measurements; not sending any trace packets.")
+		z = newSimTracer(path, simRTT, simJitter, simLoss, cfg.NumProbes)
+		refTracer = z
+	} else {
+		zt := zerotrace.NewZeroTrace(cfg)
+		if err := zt.Start(); err != nil {
+			a.alert(fmt.Sprintf("Error starting ZeroTrace: %v", err))
+			l.Fatalf("Error starting ZeroTrace: %v", err)
+		}
+		defer zt.Close()
+		z, healthy = zt, zt.Healthy
+	}
+	if !simulate && len(refTargets) > 0 {
+		// Our reference targets would soon exceed the subnet limit, so
+		// they get a tracer of their own, which shares our pcap handle.
+		refCfg := *cfg
+		refCfg.SubnetLimit = 0
+		refZt := zerotrace.NewZeroTrace(&refCfg)
+		if err := refZt.Start(); err != nil {
+			l.Fatalf("Error starting ZeroTrace for reference targets: %v", err)
+		}
+		defer refZt.Close()
+		refTracer = refZt
+	}
+	refs := newReferences(refTracer, refTargets)
+	var rs *ripestat
+	if useRIPEstat {
+		rs = newRIPEstat(ripestatURL, ripestatTTL)
+	}
+
+	s := newStats()
+	if zt, ok := z.(*zerotrace.ZeroTrace); ok {
+		s.captureRestarts = zt.CaptureRestarts
+	}
+	st := &selfTest{z: z, healthy: healthy, target: selfTestTarget, key: hmacKey, stats: s, log: l}
+	if st.target == "" && len(refTargets) > 0 {
+		st.target = refTargets[0]
+	}
+	if runSelfTest {
+		if st.target == "" {
+			l.Fatal("Specify the self-test's target by using the -selftest-target or -reference-targets flag.")
+		}
+		if !st.run(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// In batch mode, we measure the given targets and exit without starting
+	// our Web service.
+	if targetsFile != "" {
+		targets, err := loadTargetFile(targetsFile)
+		if err != nil {
+			l.Fatalf("Error loading targets: %v", err)
+		}
+		w, err := newRecordWriter(os.Stdout, format, hmacKey)
+		if err != nil {
+			l.Fatalf("Error creating output writer: %v", err)
+		}
+		measureAll(z, targets, w)
+		return
+	}
+
+	// The pprof endpoints are registered with the default ServeMux, which is
+	// only exposed on the internal listener.
+	if pprofAddr != "" {
+		http.HandleFunc("/debug/state", getDebugStateHandler(z, s))
+		http.HandleFunc("/debug/selftest", getSelfTestHandler(st))
+		go func() {
+			l.Printf("Exposing pprof and debug endpoints on %s.", pprofAddr)
+			l.Println(http.ListenAndServe(pprofAddr, nil))
+		}()
+	}
+
+	if scheduleFile != "" {
+		targets, err := loadTargetFile(scheduleFile)
+		if err != nil {
+			l.Fatalf("Error loading schedule: %v", err)
+		}
+		f, err := os.OpenFile(seriesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			l.Fatalf("Error opening series file: %v", err)
+		}
+		defer f.Close()
+		l.Printf("Measuring %d scheduled target(s).", len(targets))
+		w, err := newRecordWriter(f, formatJSON, hmacKey)
+		if err != nil {
+			l.Fatalf("Error creating series writer: %v", err)
+		}
+		if err := schedule(z, targets, w); err != nil {
+			l.Fatalf("Error scheduling targets: %v", err)
+		}
 	}
 
+	// Without a token or API keys, nobody may subscribe to the stream, so
+	// we don't publish measurements at all.
+	var b *broker
+	if streamToken != "" || keys != nil {
+		b = newBroker()
+	}
 	router := chi.NewRouter()
-	router.Get("/wss", getWssHandler(z))
-	router.Get("/", getIdxHandler(domain, addr))
+	router.Use(middleware.Recoverer)
+	router.Use(securityHeaders)
+	// The APIs expose collected data, so if we have an admin listener, they
+	// are only served there.
+	apiRouter := router
+	if adminAddr != "" {
+		apiRouter = chi.NewRouter()
+		apiRouter.Use(middleware.Recoverer)
+		apiRouter.Use(securityHeaders)
+	}
+	readResults := apiRouter.With(keys.requireScope(scopeReadResults))
+	if b != nil {
+		// API keys take the place of the stream token.
+		token := streamToken
+		if keys != nil {
+			token = ""
+		}
+		readResults.Get("/api/v1/stream", getStreamHandler(b, token))
+	}
+	readResults.Get("/api/v1/stats", getStatsHandler(s))
+	// The following APIs would be open to abuse without API keys.
+	if keys != nil {
+		apiRouter.With(keys.requireScope(scopeTriggerMeasurement)).
+			Post("/api/v1/measurements", getMeasureHandler(z))
+		apiRouter.With(keys.requireScope(scopeAdmin)).
+			Post("/api/v1/keys", getCreateKeyHandler(keys))
+		apiRouter.With(keys.requireScope(scopeAdmin)).
+			Delete("/api/v1/keys/{name}", getRevokeKeyHandler(keys))
+	}
+	challenges, err := newChallenger(powBits)
+	if err != nil {
+		l.Fatalf("Error creating challenger: %v", err)
+	}
+	serverCfg := &client.ServerConfig{
+		SchemaVersion: client.SchemaVersion,
+		WssEndpoint:   "wss://" + domain + addr + "/wss",
+		TimeoutMs:     clientTimeout.Milliseconds(),
+		STUNServer:    stunServer,
+	}
+	var candidateWait time.Duration
+	if stunServer != "" {
+		candidateWait = candidatesTimeout
+	}
+	sessions := newSessionStore(sessionRetention)
+	if sessions != nil {
+		serverCfg.SessionEndpoint = "https://" + domain + addr + "/sessions/"
+		router.Get("/sessions/{id}", getSessionHandler(sessions))
+	}
+	if challenges != nil {
+		serverCfg.ChallengeEndpoint = "https://" + domain + addr + "/challenge"
+		router.Get("/challenge", getChallengeHandler(challenges))
+	}
+	router.With(challenges.require).
+		Get("/wss", getWssHandler(z, a, s, newResultCache(dedupWindow), b, refs, rs, sessions, candidateWait))
+	router.Get("/config", getConfigHandler(serverCfg))
+	router.Handle("/schemas/*", getSchemaHandler())
+	router.Get("/", getIdxHandler())
 
-	certManager := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache("certs"),
-		HostPolicy: autocert.HostWhitelist(domain),
+	var getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if hasStaticCert(certFile) {
+		certs, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			l.Fatalf("Error loading TLS certificate: %v", err)
+		}
+		cert, _ := certs.getCertificate(nil)
+		l.Printf("Loaded TLS certificate for %v, valid until %s.",
+			cert.Leaf.DNSNames, cert.Leaf.NotAfter.UTC())
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGHUP)
+		go certs.watch(sigs, certReloadInterval)
+		getCert = certs.getCertificate
+	} else {
+		certManager := autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(certCache),
+			HostPolicy: autocert.HostWhitelist(domain),
+		}
+		go http.ListenAndServe(":http", certManager.HTTPHandler(nil)) //nolint:errcheck
+		getCert = certManager.GetCertificate
+	}
+	// autocert renews certificates 30 days before they expire, so a
+	// certificate that expires within a week failed to renew.  Static
+	// certificates deserve the same warning.
+	go a.watchCertExpiry(getCert, domain, 7*24*time.Hour, 12*time.Hour)
+	if adminAddr != "" {
+		clientCAs, err := loadClientCAs(adminClientCA)
+		if err != nil {
+			l.Fatalf("Error loading client CAs: %v", err)
+		}
+		adminServer := &http.Server{
+			Addr:    adminAddr,
+			Handler: apiRouter,
+		}
+		policy.apply(adminServer, getCert)
+		requireClientCerts(adminServer.TLSConfig, clientCAs)
+		adminLn, err := net.Listen("tcp", adminAddr)
+		if err != nil {
+			l.Fatalf("Error listening on %s: %v", adminAddr, err)
+		}
+		go func() {
+			l.Printf("Starting admin service to listen on %s.", adminAddr)
+			l.Println(adminServer.ServeTLS(adminLn, "", ""))
+		}()
 	}
-	go http.ListenAndServe(":http", certManager.HTTPHandler(nil)) //nolint:errcheck
 	server := &http.Server{
 		Addr:    addr,
 		Handler: router,
-		TLSConfig: &tls.Config{
-			GetCertificate: certManager.GetCertificate,
-		},
+	}
+	policy.apply(server, getCert)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		l.Fatalf("Error listening on %s: %v", addr, err)
+	}
+
+	// Our listeners, capture, and record writers are up, so we're ready to
+	// serve.  If systemd watches us, it gets to know once our measurement
+	// pipeline wedges.
+	notifySocket := os.Getenv("NOTIFY_SOCKET")
+	if err := sdNotify(notifySocket, "READY=1"); err != nil {
+		l.Printf("Error notifying systemd: %v", err)
+	}
+	if interval := sdWatchdogInterval(os.Getenv("WATCHDOG_USEC"), os.Getenv("WATCHDOG_PID")); interval > 0 {
+		go runWatchdog(notifySocket, interval, healthy)
 	}
 
 	l.Printf("Starting Web service to listen on %s.", addr)
-	l.Println(server.ListenAndServeTLS("", ""))
+	l.Println(server.ServeTLS(ln, "", ""))
 }
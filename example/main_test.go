@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+	"github.com/brave/zerotrace/pkg/client"
+	"github.com/gorilla/websocket"
+)
+
+// fakeTracer implements zerotrace.Tracer without touching the network.
+type fakeTracer struct {
+	res    *zerotrace.Result
+	err    error
+	delay  time.Duration
+	panics bool
+}
+
+func (f *fakeTracer) Trace(conn net.Conn) (*zerotrace.Result, error) {
+	time.Sleep(f.delay)
+	if f.panics {
+		panic("fake tracer panicked")
+	}
+	return f.res, f.err
+}
+
+func (f *fakeTracer) TraceAddr(addr string) (*zerotrace.Result, error) {
+	return f.res, f.err
+}
+
+func newWssServer(tr zerotrace.Tracer, s *stats) *httptest.Server {
+	return httptest.NewServer(getWssHandler(tr, nil, s, nil, nil, nil, nil, nil, 0))
+}
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestWssHandler(t *testing.T) {
+	var (
+		s  = newStats()
+		tr = &fakeTracer{
+			res:   &zerotrace.Result{RTT: 10 * time.Millisecond, SessionID: "foo"},
+			delay: 1500 * time.Millisecond, // Long enough for a ping.
+		}
+	)
+	srv := newWssServer(tr, s)
+	defer srv.Close()
+
+	res, err := client.New(wsURL(srv)).Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if res.RTT != 10 || res.Error != "" || res.SessionID != "foo" {
+		t.Fatalf("Unexpected result: %+v", res)
+	}
+	if snap := s.snapshot(); snap.Sessions != 1 || snap.CompletionRate != 1 {
+		t.Fatalf("Unexpected statistics: %+v", snap)
+	}
+}
+
+func TestWssHandlerError(t *testing.T) {
+	srv := newWssServer(&fakeTracer{err: zerotrace.ErrUnresponsive}, newStats())
+	defer srv.Close()
+
+	res, err := client.New(wsURL(srv)).Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if res.Error != zerotrace.ErrUnresponsive.Error() {
+		t.Fatalf("Expected error %q but got %q.", zerotrace.ErrUnresponsive, res.Error)
+	}
+}
+
+func TestWssHandlerErrorClass(t *testing.T) {
+	var (
+		b   = newBroker()
+		sub = b.subscribe()
+		srv = httptest.NewServer(getWssHandler(
+			&fakeTracer{err: zerotrace.ErrUnresponsive}, nil, newStats(), nil, b, nil, nil, nil, 0))
+	)
+	defer srv.Close()
+
+	if _, err := client.New(wsURL(srv)).Measure(context.Background()); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	// Subscribers only learn the error's class, whose text can't contain
+	// addresses.
+	var m measurement
+	if err := json.Unmarshal(<-sub, &m); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if m.Error != "unresponsive" {
+		t.Fatalf("Expected error class %q but got %q.", "unresponsive", m.Error)
+	}
+}
+
+func TestWssHandlerPanic(t *testing.T) {
+	s := newStats()
+	srv := newWssServer(&fakeTracer{panics: true}, s)
+	defer srv.Close()
+
+	// A panicking traceroute fails the measurement, not the service.
+	res, err := client.New(wsURL(srv)).Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if res.Error != errTracePanic.Error() {
+		t.Fatalf("Expected error %q but got %q.", errTracePanic, res.Error)
+	}
+	if snap := s.snapshot(); snap.Errors["internal"] != 1 {
+		t.Fatalf("Unexpected statistics: %+v", snap)
+	}
+}
+
+func TestWssHandlerMalformed(t *testing.T) {
+	srv := newWssServer(&fakeTracer{delay: time.Minute}, newStats())
+	defer srv.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial(wsURL(srv), nil)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	defer c.Close()
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`{"version":3,"type":"hello"}`)); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+
+	// The server must hang up on us.
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := c.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("Expected policy violation but got: %v", err)
+		}
+		return
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+var (
+	errBadChallenge     = errors.New("invalid challenge")
+	errExpiredChallenge = errors.New("challenge expired")
+	errUsedChallenge    = errors.New("challenge already used")
+	errBadSolution      = errors.New("nonce doesn't solve challenge")
+)
+
+// challengeTTL is the time that clients have to solve a challenge and connect.
+const challengeTTL = 5 * time.Minute
+
+// challenger hands out proof-of-work challenges and verifies their solutions,
+// so clients must spend CPU time before we send trace packets toward them.
+// Challenges are stateless: each carries its expiry and an HMAC that binds it
+// to the client's IP address.  We only remember the challenges that were used,
+// until they expire, so each can start a single measurement.  It's safe for
+// concurrent use.  A nil challenger lets all clients through.
+type challenger struct {
+	sync.Mutex // Guards used.
+	key        []byte
+	bits       int
+	used       map[string]time.Time // Maps challenges to their expiry.
+	now        func() time.Time
+}
+
+// newChallenger returns a new challenger whose challenges require the given
+// number of leading zero bits, or nil if the number is zero.
+func newChallenger(bits int) (*challenger, error) {
+	if bits <= 0 {
+		return nil, nil
+	}
+	key := make([]byte, minKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return &challenger{
+		key:  key,
+		bits: bits,
+		used: make(map[string]time.Time),
+		now:  time.Now,
+	}, nil
+}
+
+// remoteHost returns the IP address of the client that sent the given request.
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// mac returns the hex-encoded HMAC of the given challenge payload for the
+// given client.
+func (c *challenger) mac(payload, host string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload + " " + host))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// issue returns a new challenge for the given client.
+func (c *challenger) issue(host string) (*client.Challenge, error) {
+	var b [24]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(c.now().Add(challengeTTL).Unix()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		return nil, err
+	}
+	payload := hex.EncodeToString(b[:])
+	return &client.Challenge{
+		Challenge: payload + "." + c.mac(payload, host),
+		Bits:      c.bits,
+	}, nil
+}
+
+// verify returns nil if the given nonce solves the given challenge, which we
+// issued to the given client and which wasn't used yet.
+func (c *challenger) verify(challenge, nonce, host string) error {
+	payload, mac, found := strings.Cut(challenge, ".")
+	if !found || !hmac.Equal([]byte(mac), []byte(c.mac(payload, host))) {
+		return errBadChallenge
+	}
+	b, err := hex.DecodeString(payload)
+	if err != nil || len(b) != 24 {
+		return errBadChallenge
+	}
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(b[:8])), 0)
+	now := c.now()
+	if now.After(expiry) {
+		return errExpiredChallenge
+	}
+	ch := &client.Challenge{Challenge: challenge, Bits: c.bits}
+	if !ch.Check(nonce) {
+		return errBadSolution
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for used, t := range c.used {
+		if now.After(t) {
+			delete(c.used, used)
+		}
+	}
+	if _, exists := c.used[challenge]; exists {
+		return errUsedChallenge
+	}
+	c.used[challenge] = expiry
+	return nil
+}
+
+// require returns middleware that only lets through requests that carry a
+// solved challenge in their "challenge" and "nonce" query parameters.
+func (c *challenger) require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if err := c.verify(q.Get("challenge"), q.Get("nonce"), remoteHost(r)); err != nil {
+			l.Printf("Refusing measurement: %v", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func getChallengeHandler(c *challenger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ch, err := c.issue(remoteHost(r))
+		if err != nil {
+			l.Printf("Error issuing challenge: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(ch); err != nil {
+			l.Printf("Error writing challenge: %v", err)
+		}
+	}
+}
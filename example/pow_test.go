@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+func TestChallenger(t *testing.T) {
+	c, err := newChallenger(8)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	ch, err := c.issue("192.0.2.1")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	nonce, err := ch.Solve(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	// Another client can't use the challenge...
+	if err := c.verify(ch.Challenge, nonce, "192.0.2.2"); err != errBadChallenge {
+		t.Fatalf("Expected error %v but got %v.", errBadChallenge, err)
+	}
+	// ...and neither can the client without solving it.
+	bogus := "x"
+	for ch.Check(bogus) {
+		bogus += "x"
+	}
+	if err := c.verify(ch.Challenge, bogus, "192.0.2.1"); err != errBadSolution {
+		t.Fatalf("Expected error %v but got %v.", errBadSolution, err)
+	}
+	if err := c.verify(ch.Challenge, nonce, "192.0.2.1"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	// Each challenge starts a single measurement.
+	if err := c.verify(ch.Challenge, nonce, "192.0.2.1"); err != errUsedChallenge {
+		t.Fatalf("Expected error %v but got %v.", errUsedChallenge, err)
+	}
+
+	ch, _ = c.issue("192.0.2.1")
+	nonce, _ = ch.Solve(context.Background())
+	now = now.Add(challengeTTL + time.Second)
+	if err := c.verify(ch.Challenge, nonce, "192.0.2.1"); err != errExpiredChallenge {
+		t.Fatalf("Expected error %v but got %v.", errExpiredChallenge, err)
+	}
+	if err := c.verify("foo", "0", "192.0.2.1"); err != errBadChallenge {
+		t.Fatalf("Expected error %v but got %v.", errBadChallenge, err)
+	}
+}
+
+func TestChallengeGate(t *testing.T) {
+	c, _ := newChallenger(8)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(getChallengeHandler(c))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to fetch challenge: %v", err)
+	}
+	defer resp.Body.Close()
+	var ch client.Challenge
+	if err := json.NewDecoder(resp.Body).Decode(&ch); err != nil {
+		t.Fatalf("Failed to decode challenge: %v", err)
+	}
+	if ch.Bits != 8 {
+		t.Fatalf("Expected 8 bits but got %d.", ch.Bits)
+	}
+	nonce, _ := ch.Solve(context.Background())
+
+	// The test server's client connects from 127.0.0.1.
+	for query, status := range map[string]int{
+		"": http.StatusForbidden,
+		"challenge=" + url.QueryEscape(ch.Challenge) + "&nonce=" + nonce: http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/wss?"+query, nil)
+		r.RemoteAddr = "127.0.0.1:1234"
+		c.require(ok).ServeHTTP(w, r)
+		if w.Code != status {
+			t.Fatalf("Expected status %d for %q but got %d.", status, query, w.Code)
+		}
+	}
+
+	// Without a challenger, all requests pass.
+	var nilChallenger *challenger
+	w := httptest.NewRecorder()
+	nilChallenger.require(ok).ServeHTTP(w, httptest.NewRequest("GET", "/wss", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but got %d.", http.StatusOK, w.Code)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"runtime/debug"
+
+	"github.com/brave/zerotrace"
+)
+
+var (
+	// errTracePanic is the error of a measurement whose traceroute panicked.
+	errTracePanic = errors.New("measurement failed unexpectedly")
+)
+
+// recoverTrace runs the given traceroute and turns a panic into an error.
+// Traceroutes run in their own goroutine, out of reach of the router's panic
+// recovery, so a bug in the measurement code would otherwise crash the entire
+// service rather than fail a single measurement.
+func recoverTrace(trace func() (*zerotrace.Result, error)) (res *zerotrace.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.Printf("Recovered from panic in traceroute: %v\n%s", r, debug.Stack())
+			res, err = nil, errTracePanic
+		}
+	}()
+	return trace()
+}
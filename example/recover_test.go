@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestRecoverTrace(t *testing.T) {
+	res, err := recoverTrace(func() (*zerotrace.Result, error) {
+		return &zerotrace.Result{RTT: time.Millisecond}, nil
+	})
+	if err != nil || res.RTT != time.Millisecond {
+		t.Fatalf("Expected traceroute's result but got %v, %v.", res, err)
+	}
+
+	res, err = recoverTrace(func() (*zerotrace.Result, error) {
+		panic("boom")
+	})
+	if res != nil || err != errTracePanic {
+		t.Fatalf("Expected error %v but got %v, %v.", errTracePanic, res, err)
+	}
+}
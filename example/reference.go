@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/brave/zerotrace"
+)
+
+// references runs control measurements toward reference targets that we
+// control and that are known to be stable, alongside each client's
+// measurement.  If the reference RTTs are inflated too, the server or its
+// upstream is to blame rather than the client's path.  A nil references
+// measures nothing.
+type references struct {
+	z       zerotrace.Tracer
+	targets []string
+}
+
+// newReferences returns a new references object that measures the given
+// targets with the given tracer, or nil if there are no targets.
+func newReferences(z zerotrace.Tracer, targets []string) *references {
+	if len(targets) == 0 {
+		return nil
+	}
+	return &references{z: z, targets: targets}
+}
+
+// parseReferenceTargets parses the given comma-separated list of host:port
+// tuples.
+func parseReferenceTargets(s string) ([]string, error) {
+	var targets []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(field); err != nil {
+			return nil, fmt.Errorf("invalid reference target %q: %w", field, err)
+		}
+		targets = append(targets, field)
+	}
+	return targets, nil
+}
+
+// measure measures all reference targets concurrently and returns their
+// records in the order of the targets.
+func (r *references) measure() []*record {
+	if r == nil {
+		return nil
+	}
+	var (
+		wg      sync.WaitGroup
+		records = make([]*record, len(r.targets))
+	)
+	for i, addr := range r.targets {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			records[i] = measureAddr(r.z, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+	return records
+}
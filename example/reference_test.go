@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestParseReferenceTargets(t *testing.T) {
+	targets, err := parseReferenceTargets(" 192.0.2.1:443, ref.example.com:80,,")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(targets) != 2 || targets[1] != "ref.example.com:80" {
+		t.Fatalf("Unexpected targets: %v", targets)
+	}
+	if _, err := parseReferenceTargets("192.0.2.1"); err == nil {
+		t.Fatal("Expected error for reference target without port.")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	if refs := newReferences(&fakeTracer{}, nil); refs != nil || refs.measure() != nil {
+		t.Fatal("Expected no reference measurements without targets.")
+	}
+
+	tr := &fakeTracer{res: &zerotrace.Result{RTT: 5 * time.Millisecond}}
+	records := newReferences(tr, []string{"192.0.2.1:443", "192.0.2.2:443"}).measure()
+	if len(records) != 2 {
+		t.Fatalf("Expected two records but got %d.", len(records))
+	}
+	for i, target := range []string{"192.0.2.1:443", "192.0.2.2:443"} {
+		if records[i].Target != target || records[i].RTT != 5 {
+			t.Fatalf("Unexpected record for %s: %+v", target, records[i])
+		}
+	}
+}
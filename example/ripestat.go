@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ripestatURL is the base URL of RIPEstat's data API.
+const ripestatURL = "https://stat.ripe.net/data"
+
+// networkInfo describes the network that a client's address belongs to, as
+// seen by RIPEstat.
+type networkInfo struct {
+	Prefix        string      `json:"prefix"`
+	Announced     bool        `json:"announced"`
+	Origins       []*asnInfo  `json:"origins,omitempty"`
+	AbuseContacts []string    `json:"abuse_contacts,omitempty"`
+	RPKI          []*rpkiInfo `json:"rpki,omitempty"`
+}
+
+// asnInfo is an AS that originates a prefix.
+type asnInfo struct {
+	ASN    int    `json:"asn"`
+	Holder string `json:"holder"`
+}
+
+// rpkiInfo is the RPKI validation status of a prefix's origin AS, e.g.,
+// "valid", "invalid_asn", or "unknown".
+type rpkiInfo struct {
+	ASN    int    `json:"asn"`
+	Status string `json:"status"`
+}
+
+// ripestatEntry is a cached network lookup.
+type ripestatEntry struct {
+	info *networkInfo
+	t    time.Time
+}
+
+// ripestat looks up the prefix, origin ASes, abuse contacts, and RPKI status
+// of client addresses in RIPEstat.  Lookups are cached per /24 (or /48) for the
+// given TTL, which spares RIPEstat repeated queries for the same network.  It's
+// safe for concurrent use.  A nil ripestat looks up nothing.
+type ripestat struct {
+	sync.Mutex // Guards cache.
+	baseURL    string
+	client     *http.Client
+	ttl        time.Duration
+	cache      map[string]*ripestatEntry
+	now        func() time.Time
+}
+
+// newRIPEstat returns a new RIPEstat client that queries the API at the given
+// base URL and caches lookups for the given TTL.
+func newRIPEstat(baseURL string, ttl time.Duration) *ripestat {
+	return &ripestat{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		ttl:     ttl,
+		cache:   make(map[string]*ripestatEntry),
+		now:     time.Now,
+	}
+}
+
+// networkKey returns the cache key of the given address's network.
+func networkKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// get queries the given RIPEstat data call and decodes its data into v.
+func (r *ripestat) get(ctx context.Context, call string, params url.Values, v any) error {
+	params.Set("sourceapp", "zerotrace")
+	u := r.baseURL + "/" + call + "/data.json?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status %s", call, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(&struct {
+		Data any `json:"data"`
+	}{Data: v})
+}
+
+// lookup returns information about the network of the given address.
+func (r *ripestat) lookup(ctx context.Context, ip net.IP) (*networkInfo, error) {
+	if r == nil {
+		return nil, nil
+	}
+	key := networkKey(ip)
+	r.Lock()
+	e, exists := r.cache[key]
+	r.Unlock()
+	if exists && r.now().Sub(e.t) < r.ttl {
+		return e.info, nil
+	}
+
+	var overview struct {
+		Resource  string     `json:"resource"`
+		Announced bool       `json:"announced"`
+		ASNs      []*asnInfo `json:"asns"`
+	}
+	if err := r.get(ctx, "prefix-overview", url.Values{"resource": {ip.String()}}, &overview); err != nil {
+		return nil, err
+	}
+	info := &networkInfo{
+		Prefix:    overview.Resource,
+		Announced: overview.Announced,
+		Origins:   overview.ASNs,
+	}
+
+	var abuse struct {
+		AbuseContacts []string `json:"abuse_contacts"`
+	}
+	if err := r.get(ctx, "abuse-contact-finder", url.Values{"resource": {info.Prefix}}, &abuse); err != nil {
+		return nil, err
+	}
+	info.AbuseContacts = abuse.AbuseContacts
+
+	for _, origin := range info.Origins {
+		var rpki struct {
+			Status string `json:"status"`
+		}
+		params := url.Values{
+			"resource": {strconv.Itoa(origin.ASN)},
+			"prefix":   {info.Prefix},
+		}
+		if err := r.get(ctx, "rpki-validation", params, &rpki); err != nil {
+			return nil, err
+		}
+		info.RPKI = append(info.RPKI, &rpkiInfo{ASN: origin.ASN, Status: rpki.Status})
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	// Forget expired lookups, so we don't accumulate all networks that we
+	// ever looked up.
+	now := r.now()
+	for k, e := range r.cache {
+		if now.Sub(e.t) >= r.ttl {
+			delete(r.cache, k)
+		}
+	}
+	r.cache[key] = &ripestatEntry{info: info, t: now}
+	return info, nil
+}
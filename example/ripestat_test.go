@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRIPEstatServer(t *testing.T, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/prefix-overview/data.json":
+			if q.Get("resource") != "192.0.2.1" {
+				t.Errorf("Unexpected resource %q.", q.Get("resource"))
+			}
+			fmt.Fprint(w, `{"data": {"resource": "192.0.2.0/24", "announced": true,
+				"asns": [{"asn": 64496, "holder": "EXAMPLE-AS"}]}}`)
+		case "/abuse-contact-finder/data.json":
+			fmt.Fprint(w, `{"data": {"abuse_contacts": ["abuse@example.com"]}}`)
+		case "/rpki-validation/data.json":
+			if q.Get("resource") != "64496" || q.Get("prefix") != "192.0.2.0/24" {
+				t.Errorf("Unexpected RPKI query %q.", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `{"data": {"status": "valid"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRIPEstat(t *testing.T) {
+	var requests int
+	srv := newRIPEstatServer(t, &requests)
+	defer srv.Close()
+
+	rs := newRIPEstat(srv.URL, time.Hour)
+	info, err := rs.lookup(context.Background(), net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if info.Prefix != "192.0.2.0/24" || !info.Announced {
+		t.Fatalf("Unexpected prefix: %+v", info)
+	}
+	if len(info.Origins) != 1 || info.Origins[0].ASN != 64496 || info.Origins[0].Holder != "EXAMPLE-AS" {
+		t.Fatalf("Unexpected origins: %+v", info.Origins)
+	}
+	if len(info.AbuseContacts) != 1 || info.AbuseContacts[0] != "abuse@example.com" {
+		t.Fatalf("Unexpected abuse contacts: %v", info.AbuseContacts)
+	}
+	if len(info.RPKI) != 1 || info.RPKI[0].Status != "valid" {
+		t.Fatalf("Unexpected RPKI status: %+v", info.RPKI)
+	}
+	if requests != 3 {
+		t.Fatalf("Expected 3 requests but got %d.", requests)
+	}
+}
+
+func TestRIPEstatCache(t *testing.T) {
+	var requests int
+	srv := newRIPEstatServer(t, &requests)
+	defer srv.Close()
+
+	var (
+		now = time.Now()
+		rs  = newRIPEstat(srv.URL, time.Hour)
+		ctx = context.Background()
+	)
+	rs.now = func() time.Time { return now }
+	if _, err := rs.lookup(ctx, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	// Clients in the same /24 are served from the cache.
+	info, err := rs.lookup(ctx, net.ParseIP("192.0.2.200"))
+	if err != nil || info == nil || requests != 3 {
+		t.Fatalf("Expected cached lookup but got %d requests (error: %v).", requests, err)
+	}
+
+	// Expired lookups are repeated.
+	now = now.Add(time.Hour)
+	if _, err := rs.lookup(ctx, net.ParseIP("192.0.2.1")); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if requests != 6 {
+		t.Fatalf("Expected 6 requests but got %d.", requests)
+	}
+}
+
+func TestRIPEstatErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	if _, err := newRIPEstat(srv.URL, time.Hour).lookup(context.Background(), net.ParseIP("192.0.2.1")); err == nil {
+		t.Fatal("Expected error for failed RIPEstat request.")
+	}
+	// A nil ripestat looks up nothing.
+	var rs *ripestat
+	if info, err := rs.lookup(context.Background(), net.ParseIP("192.0.2.1")); info != nil || err != nil {
+		t.Fatalf("Expected nothing but got %v (error: %v).", info, err)
+	}
+}
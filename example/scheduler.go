@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+// target represents a host that we measure on a recurring schedule.
+type target struct {
+	// addr is the host:port tuple that we establish a TCP connection to, so
+	// 0trace has a connection to piggyback on.
+	addr     string
+	interval time.Duration
+}
+
+// record represents a single measurement of a scheduled target.
+type record struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	RTT      float64   `json:"rtt_ms"`
+	Tunneled bool      `json:"tunneled,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Sig      string    `json:"sig,omitempty"`
+	result   *zerotrace.Result
+}
+
+// loadTargets parses the given target file.  Each line contains a host:port
+// tuple and, optionally separated by whitespace, the interval at which we
+// measure the target, e.g., "192.0.2.1:443 15m".  Empty lines and lines
+// starting with '#' are ignored.
+func loadTargets(r io.Reader) ([]*target, error) {
+	var (
+		targets []*target
+		lineNum int
+		scanner = bufio.NewScanner(r)
+	)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expected \"host:port [interval]\"", lineNum)
+		}
+		if _, _, err := net.SplitHostPort(fields[0]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		t := &target{addr: fields[0]}
+		if len(fields) == 2 {
+			interval, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			if interval <= 0 {
+				return nil, fmt.Errorf("line %d: interval must be positive", lineNum)
+			}
+			t.interval = interval
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
+
+// loadTargetFile parses the target file at the given path.
+func loadTargetFile(path string) ([]*target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return loadTargets(f)
+}
+
+// measureAddr runs a 0trace measurement toward the given address.
+func measureAddr(z zerotrace.Tracer, addr string) *record {
+	r := &record{
+		Time:   time.Now().UTC(),
+		Target: addr,
+	}
+	res, err := recoverTrace(func() (*zerotrace.Result, error) {
+		return z.TraceAddr(addr)
+	})
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.RTT = float64(res.RTT) / float64(time.Millisecond)
+	r.Tunneled = res.Tunneled
+	r.result = res
+	return r
+}
+
+// Output formats of the record writer.
+const (
+	formatJSON  = "json"
+	formatText  = "text"
+	formatWarts = "warts"
+	formatAtlas = "atlas"
+	formatAvro  = "avro"
+)
+
+// recordWriter writes measurement records in the given format: as JSON lines,
+// as traceroute-style text, as scamper's warts-JSON, as RIPE Atlas JSON, or as
+// an Avro object container file.  If the writer has an HMAC key, JSON lines and
+// Avro records are signed.  JSON lines that violate our record schema are
+// logged and dropped.  It's safe for concurrent use.
+type recordWriter struct {
+	sync.Mutex // Guards w and enc.
+	w          io.Writer
+	enc        *json.Encoder
+	avro       *avroWriter
+	format     string
+	key        []byte
+}
+
+func newRecordWriter(w io.Writer, format string, key []byte) (*recordWriter, error) {
+	rw := &recordWriter{w: w, enc: json.NewEncoder(w), format: format, key: key}
+	switch format {
+	case formatJSON, formatText, formatWarts, formatAtlas:
+	case formatAvro:
+		var err error
+		if rw.avro, err = newAvroWriter(w); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported output format %q", format)
+	}
+	return rw, nil
+}
+
+func (w *recordWriter) write(r *record) {
+	w.Lock()
+	defer w.Unlock()
+
+	var err error
+	switch {
+	case w.format == formatJSON:
+		// Refuse to emit records that downstream parsers won't expect.
+		if err = validateRecord(recordSchema, r); err != nil {
+			break
+		}
+		if w.key != nil {
+			if err = signRecord(r, w.key); err != nil {
+				break
+			}
+		}
+		err = w.enc.Encode(r)
+	case w.format == formatAvro:
+		if w.key != nil {
+			if err = signRecord(r, w.key); err != nil {
+				break
+			}
+		}
+		err = w.avro.write(r)
+	case r.result == nil:
+		_, err = fmt.Fprintf(w.w, "Error measuring %s: %s\n", r.Target, r.Error)
+	case w.format == formatText:
+		err = r.result.WriteText(w.w, true)
+	case w.format == formatWarts:
+		err = r.result.WriteWartsJSON(w.w)
+	case w.format == formatAtlas:
+		err = r.result.WriteAtlasJSON(w.w)
+	}
+	if err != nil {
+		l.Printf("Error writing measurement record: %v", err)
+	}
+}
+
+// measureAll measures each of the given targets once, one after another, and
+// writes the resulting records to the given writer.
+func measureAll(z zerotrace.Tracer, targets []*target, w *recordWriter) {
+	for _, t := range targets {
+		w.write(measureAddr(z, t.addr))
+	}
+}
+
+// schedule measures each of the given targets at its interval, and writes the
+// resulting records to the given writer.  The measurements run in the
+// background.
+func schedule(z zerotrace.Tracer, targets []*target, w *recordWriter) error {
+	for _, t := range targets {
+		if t.interval == 0 {
+			return fmt.Errorf("no interval for scheduled target %s", t.addr)
+		}
+	}
+	for _, t := range targets {
+		go func(t *target) {
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				w.write(measureAddr(z, t.addr))
+				<-ticker.C
+			}
+		}(t)
+	}
+	return nil
+}
+
+// measureRequest is the body of a request to measure a target on demand.
+type measureRequest struct {
+	Target string `json:"target"`
+}
+
+// getMeasureHandler returns a handler that measures the requested host:port
+// tuple and responds with the measurement record.
+func getMeasureHandler(z zerotrace.Tracer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req measureRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "malformed request", http.StatusBadRequest)
+			return
+		}
+		if _, _, err := net.SplitHostPort(req.Target); err != nil {
+			http.Error(w, "target must be a host:port tuple", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(measureAddr(z, req.Target)); err != nil {
+			l.Printf("Error writing measurement record: %v", err)
+		}
+	}
+}
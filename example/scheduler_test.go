@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestLoadTargets(t *testing.T) {
+	targets, err := loadTargets(strings.NewReader(`
+# Our VPN egress nodes.
+192.0.2.1:443 15m
+
+198.51.100.7:22	1h
+203.0.113.9:80
+`))
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("Expected 3 targets but got %d.", len(targets))
+	}
+	if targets[0].addr != "192.0.2.1:443" || targets[0].interval != 15*time.Minute {
+		t.Fatalf("Unexpected first target: %+v", targets[0])
+	}
+	if targets[1].addr != "198.51.100.7:22" || targets[1].interval != time.Hour {
+		t.Fatalf("Unexpected second target: %+v", targets[1])
+	}
+	if targets[2].addr != "203.0.113.9:80" || targets[2].interval != 0 {
+		t.Fatalf("Unexpected third target: %+v", targets[2])
+	}
+}
+
+func TestScheduleWithoutInterval(t *testing.T) {
+	targets := []*target{{addr: "192.0.2.1:443"}}
+	if err := schedule(nil, targets, nil); err == nil {
+		t.Fatal("Expected error for target without interval.")
+	}
+}
+
+func TestLoadInvalidTargets(t *testing.T) {
+	for _, input := range []string{
+		"192.0.2.1:443 15m 1h",
+		"192.0.2.1 15m",
+		"192.0.2.1:443 fortnightly",
+		"192.0.2.1:443 -1m",
+	} {
+		if _, err := loadTargets(strings.NewReader(input)); err == nil {
+			t.Fatalf("Expected error for input %q.", input)
+		}
+	}
+}
+
+func TestRecordWriter(t *testing.T) {
+	if _, err := newRecordWriter(nil, "xml", nil); err == nil {
+		t.Fatal("Expected error for unsupported format.")
+	}
+
+	var b strings.Builder
+	w, err := newRecordWriter(&b, formatText, nil)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	w.write(&record{Target: "192.0.2.1:443", Error: "connection refused"})
+	expected := "Error measuring 192.0.2.1:443: connection refused\n"
+	if b.String() != expected {
+		t.Fatalf("Expected output %q but got %q.", expected, b.String())
+	}
+
+	b.Reset()
+	w, _ = newRecordWriter(&b, formatJSON, nil)
+	w.write(&record{Target: "192.0.2.1:443", RTT: 1.5})
+	expected = `{"time":"0001-01-01T00:00:00Z","target":"192.0.2.1:443","rtt_ms":1.5}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("Expected output %q but got %q.", expected, b.String())
+	}
+}
+
+func TestMeasureAddr(t *testing.T) {
+	tr := &fakeTracer{res: &zerotrace.Result{RTT: 20 * time.Millisecond, Tunneled: true}}
+	r := measureAddr(tr, "192.0.2.1:443")
+	if r.RTT != 20 || !r.Tunneled || r.Error != "" || r.result != tr.res {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+
+	tr = &fakeTracer{err: zerotrace.ErrBlocked}
+	r = measureAddr(tr, "192.0.2.1:443")
+	if r.Error != zerotrace.ErrBlocked.Error() || r.result != nil {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+}
+
+func TestMeasureHandler(t *testing.T) {
+	tr := &fakeTracer{res: &zerotrace.Result{RTT: 20 * time.Millisecond}}
+
+	w := httptest.NewRecorder()
+	body := strings.NewReader(`{"target":"192.0.2.1:443"}`)
+	getMeasureHandler(tr)(w, httptest.NewRequest("POST", "/api/v1/measurements", body))
+	var r record
+	if err := json.NewDecoder(w.Body).Decode(&r); err != nil {
+		t.Fatalf("Failed to decode record: %v", err)
+	}
+	if r.Target != "192.0.2.1:443" || r.RTT != 20 {
+		t.Fatalf("Unexpected record: %+v", r)
+	}
+
+	w = httptest.NewRecorder()
+	body = strings.NewReader(`{"target":"192.0.2.1"}`)
+	getMeasureHandler(tr)(w, httptest.NewRequest("POST", "/api/v1/measurements", body))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d but got %d.", http.StatusBadRequest, w.Code)
+	}
+}
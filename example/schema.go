@@ -0,0 +1,244 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The JSON Schema draft that our schemas follow.
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+var errSchemaViolation = errors.New("schema violation")
+
+// schemaFiles holds the JSON Schemas of the records that we emit, one file per
+// record type.  Downstream parsers can fetch them from /schemas/.  The files
+// are generated from our structs by "go test -run TestSchemas -update", and
+// the test fails if a struct no longer matches its schema file.
+//
+//go:embed schemas
+var schemaFiles embed.FS
+
+// schemaTypes maps the names of our schema files to the types that they
+// describe.
+var schemaTypes = map[string]reflect.Type{
+	"record":      reflect.TypeOf(record{}),
+	"measurement": reflect.TypeOf(measurement{}),
+}
+
+var (
+	recordSchema      = mustLoadSchema("record")
+	measurementSchema = mustLoadSchema("measurement")
+)
+
+// schemaPath returns the path of the given schema's file.
+func schemaPath(name string) string {
+	return "schemas/" + name + ".schema.json"
+}
+
+// mustLoadSchema returns the given embedded schema, and panics if it's
+// missing or malformed.
+func mustLoadSchema(name string) map[string]any {
+	data, err := schemaFiles.ReadFile(schemaPath(name))
+	if err != nil {
+		panic(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		panic(fmt.Sprintf("%s: %v", schemaPath(name), err))
+	}
+	return schema
+}
+
+// generateSchema returns the JSON Schema of the given named struct type, as
+// encoding/json marshals it.
+func generateSchema(name string, t reflect.Type) map[string]any {
+	schema := typeSchema(t)
+	schema["$schema"] = schemaDraft
+	schema["$id"] = name + ".schema.json"
+	schema["title"] = name
+	return schema
+}
+
+// typeSchema returns the JSON Schema of the given type.  Objects don't allow
+// properties that aren't in their struct, so a record with an unexpected field
+// fails validation instead of surprising a downstream parser.
+func typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return typeSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		var (
+			props    = make(map[string]any)
+			required = []string{}
+		)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			prop := typeSchema(f.Type)
+			omitEmpty := strings.Contains(opts, "omitempty")
+			if !omitEmpty {
+				required = append(required, name)
+				// Nil pointers and slices are marshaled as null.
+				if k := f.Type.Kind(); k == reflect.Pointer || k == reflect.Slice || k == reflect.Map {
+					prop["type"] = []any{prop["type"], "null"}
+				}
+			}
+			props[name] = prop
+		}
+		sort.Strings(required)
+		return map[string]any{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	default:
+		return map[string]any{}
+	}
+}
+
+// validateRecord returns an error if the JSON encoding of the given value
+// violates the given schema.
+func validateRecord(schema map[string]any, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	return validateSchema(schema, doc, "$")
+}
+
+// jsonType returns the JSON Schema type of the given decoded JSON value.
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return ""
+}
+
+// typeMatches returns true if the given JSON value has one of the types that
+// the given schema allows.
+func typeMatches(schema map[string]any, v any) bool {
+	var types []any
+	switch t := schema["type"].(type) {
+	case nil:
+		return true
+	case string:
+		types = []any{t}
+	case []any:
+		types = t
+	}
+	actual := jsonType(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSchema returns an error if the given decoded JSON value, found at the
+// given path of its document, violates the given schema.  We only support the
+// keywords that typeSchema emits.
+func validateSchema(schema map[string]any, v any, path string) error {
+	if !typeMatches(schema, v) {
+		return fmt.Errorf("%w: %s: expected type %v but got %s",
+			errSchemaViolation, path, schema["type"], jsonType(v))
+	}
+	switch v := v.(type) {
+	case string:
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+				return fmt.Errorf("%w: %s: invalid date-time %q", errSchemaViolation, path, v)
+			}
+		}
+	case []any:
+		items, _ := schema["items"].(map[string]any)
+		for i, item := range v {
+			if err := validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, exists := v[name.(string)]; !exists {
+				return fmt.Errorf("%w: %s: missing property %q", errSchemaViolation, path, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for name, value := range v {
+			prop, exists := props[name].(map[string]any)
+			if !exists {
+				switch extra := schema["additionalProperties"].(type) {
+				case bool:
+					if !extra {
+						return fmt.Errorf("%w: %s: unexpected property %q",
+							errSchemaViolation, path, name)
+					}
+				case map[string]any:
+					prop = extra
+				}
+			}
+			if err := validateSchema(prop, value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getSchemaHandler returns a handler that serves our schema files.
+func getSchemaHandler() http.Handler {
+	return http.FileServer(http.FS(schemaFiles))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the schema files")
+
+// TestSchemas fails if a struct that we emit no longer matches its schema
+// file.  If the change is intentional, regenerate the schema files by running
+// "go test -run TestSchemas -update", and tell downstream parsers.
+func TestSchemas(t *testing.T) {
+	for name, typ := range schemaTypes {
+		got, err := json.MarshalIndent(generateSchema(name, typ), "", "  ")
+		if err != nil {
+			t.Fatalf("Failed to encode schema: %v", err)
+		}
+		got = append(got, '\n')
+		if *update {
+			if err := os.WriteFile(schemaPath(name), got, 0o644); err != nil {
+				t.Fatalf("Failed to write schema: %v", err)
+			}
+		}
+		want, err := os.ReadFile(schemaPath(name))
+		if err != nil {
+			t.Fatalf("Failed to read schema: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("The %s struct doesn't match %s:\n%s", name, schemaPath(name), got)
+		}
+	}
+}
+
+func TestValidateRecord(t *testing.T) {
+	r := &record{Time: time.Now().UTC(), Target: "192.0.2.1:443", RTT: 12.5}
+	if err := validateRecord(recordSchema, r); err != nil {
+		t.Fatalf("Expected valid record but got: %v", err)
+	}
+	m := &measurement{
+		Time:       time.Now().UTC(),
+		TLS:        &tlsInfo{Version: "TLS 1.3"},
+		References: []*record{r},
+		Network:    &networkInfo{Prefix: "192.0.2.0/24", Origins: []*asnInfo{{ASN: 64496}}},
+	}
+	if err := validateRecord(measurementSchema, m); err != nil {
+		t.Fatalf("Expected valid measurement but got: %v", err)
+	}
+
+	for _, doc := range []string{
+		`{"time": "2024-01-01T00:00:00Z", "target": "192.0.2.1:443"}`,
+		`{"time": "yesterday", "target": "192.0.2.1:443", "rtt_ms": 1}`,
+		`{"time": "2024-01-01T00:00:00Z", "target": 1, "rtt_ms": 1}`,
+		`{"time": "2024-01-01T00:00:00Z", "target": "192.0.2.1:443", "rtt_ms": 1, "hops": 3}`,
+		`[]`,
+	} {
+		var v any
+		if err := json.Unmarshal([]byte(doc), &v); err != nil {
+			t.Fatalf("Failed to decode document: %v", err)
+		}
+		if err := validateSchema(recordSchema, v, "$"); !errors.Is(err, errSchemaViolation) {
+			t.Fatalf("Expected error %v for %s but got %v.", errSchemaViolation, doc, err)
+		}
+	}
+}
+
+func TestRecordWriterValidates(t *testing.T) {
+	var b bytes.Buffer
+	w, err := newRecordWriter(&b, formatJSON, nil)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	w.write(&record{Time: time.Now().UTC(), Target: "192.0.2.1:443"})
+	if !strings.Contains(b.String(), `"target":"192.0.2.1:443"`) {
+		t.Fatalf("Expected record to be written but got: %s", b.String())
+	}
+}
+
+func TestSchemaHandler(t *testing.T) {
+	srv := httptest.NewServer(getSchemaHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/schemas/record.schema.json")
+	if err != nil {
+		t.Fatalf("Failed to fetch schema: %v", err)
+	}
+	defer resp.Body.Close()
+	var schema map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&schema); err != nil {
+		t.Fatalf("Failed to decode schema: %v", err)
+	}
+	if schema["$id"] != "record.schema.json" {
+		t.Fatalf("Unexpected schema: %v", schema)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+var (
+	errNoMeasurement = errors.New("no measurement to check")
+	errNoTarget      = errors.New("no target to measure")
+	errLogDiscarded  = errors.New("log discards its lines")
+)
+
+// selfCheck is a single check of our self-test.  A check that returns
+// errNoMeasurement is skipped rather than failed because it depends on an
+// earlier check that failed.
+type selfCheck struct {
+	name string
+	run  func() error
+}
+
+// selfTest runs our measurement pipeline end to end: it checks that our
+// packet capture is healthy, measures a target with raw sockets and capture,
+// validates and signs the resulting record, aggregates it in the server's
+// statistics, and writes a line to the server's log.  That's what a freshly-provisioned measurement box must
+// be capable of before it serves participants.  The target must be a host
+// beyond our first router: nothing on the loopback interface answers our trace
+// packets with ICMP errors, so a self-test without target fails.
+type selfTest struct {
+	sync.Mutex // Serializes runs, which share rec.
+	z          zerotrace.Tracer
+	healthy    func() error
+	target     string
+	key        []byte
+	stats      *stats
+	log        *log.Logger
+	rec        *record // The measurement of the trace check.
+}
+
+// checks returns the self-test's checks in the order in which they run.
+func (t *selfTest) checks() []selfCheck {
+	return []selfCheck{
+		{"capture", t.healthy},
+		{"trace", t.checkTrace},
+		{"record", t.checkRecord},
+		{"stats", t.checkStats},
+		{"logging", t.checkLogging},
+	}
+}
+
+func (t *selfTest) checkTrace() error {
+	t.rec = nil
+	if t.target == "" {
+		return errNoTarget
+	}
+	res, err := recoverTrace(func() (*zerotrace.Result, error) {
+		return t.z.TraceAddr(t.target)
+	})
+	if err != nil {
+		return fmt.Errorf("measuring %s: %w", t.target, err)
+	}
+	if len(res.Hops) == 0 || res.RTT <= 0 {
+		return fmt.Errorf("measuring %s: no hops or RTT", t.target)
+	}
+	t.rec = &record{
+		Time:     res.Start,
+		Target:   t.target,
+		RTT:      float64(res.RTT) / float64(time.Millisecond),
+		Tunneled: res.Tunneled,
+		result:   res,
+	}
+	return nil
+}
+
+// checkRecord checks that the trace check's record satisfies our record
+// schema and, if we have an HMAC key, that its signature verifies.
+func (t *selfTest) checkRecord() error {
+	if t.rec == nil {
+		return errNoMeasurement
+	}
+	if err := validateRecord(recordSchema, t.rec); err != nil {
+		return err
+	}
+	if t.key == nil {
+		return nil
+	}
+	r := *t.rec
+	if err := signRecord(&r, t.key); err != nil {
+		return err
+	}
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	return verifyRecord(line, t.key)
+}
+
+// checkStats checks that the server's statistics account for the trace check's
+// measurement, which counts toward them like any other.
+func (t *selfTest) checkStats() error {
+	if t.rec == nil {
+		return errNoMeasurement
+	}
+	before := t.stats.snapshot()
+	t.stats.record(t.rec.result.RTT, nil)
+	after := t.stats.snapshot()
+	if after.Sessions <= before.Sessions || after.CompletionRate <= 0 || after.MedianRTT <= 0 {
+		return fmt.Errorf("statistics didn't account for measurement: %+v", after)
+	}
+	return nil
+}
+
+// checkLogging checks that a line makes it to the server's log, which fails if
+// the log discards its lines, or if its writer fails, e.g., because the other
+// end of stderr is gone.
+func (t *selfTest) checkLogging() error {
+	if t.log.Writer() == io.Discard {
+		return errLogDiscarded
+	}
+	return t.log.Output(2, "Self-test log line.")
+}
+
+// run runs the self-test's checks, writes a pass/fail summary to the given
+// writer, and returns true if no check failed.
+func (t *selfTest) run(w io.Writer) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	var passed, failed, skipped int
+	for _, c := range t.checks() {
+		err := c.run()
+		switch {
+		case errors.Is(err, errNoMeasurement):
+			skipped++
+			fmt.Fprintf(w, "SKIP  %s: %v\n", c.name, err)
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", c.name, err)
+		default:
+			passed++
+			fmt.Fprintf(w, "PASS  %s\n", c.name)
+		}
+	}
+	verdict := "passed"
+	if failed > 0 {
+		verdict = "failed"
+	}
+	fmt.Fprintf(w, "Self-test %s: %d passed, %d failed, %d skipped.\n",
+		verdict, passed, failed, skipped)
+	return failed == 0
+}
+
+// getSelfTestHandler returns a handler that runs the given self-test on
+// demand.  It responds with the pass/fail summary, and with status code 503 if
+// a check failed.  Self-tests send trace packets, so the handler belongs on
+// the internal listener.
+func getSelfTestHandler(t *selfTest) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var summary bytes.Buffer
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !t.run(&summary) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := summary.WriteTo(w); err != nil {
+			l.Printf("Error writing self-test summary: %v", err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSelfTest(target string) *selfTest {
+	path := []net.IP{net.ParseIP("192.0.2.1")}
+	return &selfTest{
+		z:       newSimTracer(path, 30*time.Millisecond, 0, 0, 3),
+		healthy: func() error { return nil },
+		target:  target,
+		key:     bytes.Repeat([]byte{1}, 32),
+		stats:   newStats(),
+		log:     log.New(&bytes.Buffer{}, "", 0),
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	var (
+		st  = newTestSelfTest("203.0.113.1:443")
+		buf bytes.Buffer
+	)
+	if !st.run(&buf) {
+		t.Fatalf("Expected self-test to pass but got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "5 passed, 0 failed, 0 skipped") {
+		t.Fatalf("Unexpected summary:\n%s", buf.String())
+	}
+	// The measurement counts toward the server's statistics, and the log
+	// line made it to the server's log.
+	if snap := st.stats.snapshot(); snap.Sessions != 1 || snap.MedianRTT <= 0 {
+		t.Fatalf("Unexpected statistics: %+v", snap)
+	}
+	if logged := st.log.Writer().(*bytes.Buffer).String(); !strings.Contains(logged, "Self-test log line.") {
+		t.Fatalf("Expected log line but got %q.", logged)
+	}
+}
+
+func TestSelfTestLogging(t *testing.T) {
+	var (
+		st  = newTestSelfTest("203.0.113.1:443")
+		buf bytes.Buffer
+	)
+	// A log that discards its lines fails its check, as does one whose
+	// writer fails.
+	st.log = log.New(io.Discard, "", 0)
+	if st.run(&buf) || !strings.Contains(buf.String(), "FAIL  logging: "+errLogDiscarded.Error()) {
+		t.Fatalf("Expected logging check to fail but got:\n%s", buf.String())
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	r.Close()
+	w.Close()
+	st.log = log.New(w, "", 0)
+	buf.Reset()
+	if st.run(&buf) || !strings.Contains(buf.String(), "FAIL  logging:") {
+		t.Fatalf("Expected logging check to fail but got:\n%s", buf.String())
+	}
+}
+
+func TestSelfTestNoTarget(t *testing.T) {
+	var (
+		st  = newTestSelfTest("")
+		buf bytes.Buffer
+	)
+	// Without target, there's nothing to measure, which fails the self-test
+	// rather than passing it without measuring anything.
+	if st.run(&buf) {
+		t.Fatalf("Expected self-test without target to fail but got:\n%s", buf.String())
+	}
+	for _, want := range []string{
+		"FAIL  trace: " + errNoTarget.Error(),
+		"SKIP  record:",
+		"Self-test failed: 2 passed, 1 failed, 2 skipped.",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("Expected summary to contain %q but got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestSelfTestFailure(t *testing.T) {
+	var (
+		st  = newTestSelfTest("203.0.113.1:443")
+		buf bytes.Buffer
+	)
+	// A broken capture fails its check, and an unresponsive target leaves
+	// the checks that depend on its measurement without one.
+	st.healthy = func() error { return errors.New("capture is down") }
+	st.z = newSimTracer(nil, time.Millisecond, 0, 1, 1)
+	if st.run(&buf) {
+		t.Fatalf("Expected self-test to fail but got:\n%s", buf.String())
+	}
+	for _, want := range []string{
+		"FAIL  capture: capture is down",
+		"FAIL  trace:",
+		"SKIP  record:",
+		"SKIP  stats:",
+		"PASS  logging",
+		"Self-test failed: 1 passed, 2 failed, 2 skipped.",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("Expected summary to contain %q but got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestSelfTestHandler(t *testing.T) {
+	st := newTestSelfTest("203.0.113.1:443")
+	w := httptest.NewRecorder()
+	getSelfTestHandler(st)(w, httptest.NewRequest(http.MethodGet, "/debug/selftest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d.", http.StatusOK, w.Code)
+	}
+
+	st.healthy = func() error { return errors.New("capture is down") }
+	w = httptest.NewRecorder()
+	getSelfTestHandler(st)(w, httptest.NewRequest(http.MethodGet, "/debug/selftest", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code %d but got %d.", http.StatusServiceUnavailable, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Self-test failed") {
+		t.Fatalf("Unexpected summary:\n%s", w.Body.String())
+	}
+}
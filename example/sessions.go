@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// sessionEntry is a stored measurement.
+type sessionEntry struct {
+	m *measurement
+	t time.Time
+}
+
+// sessionStore keeps each participant's measurement, keyed by its session ID,
+// for a retention period, so participants can download the data that we
+// collected about them.  Session IDs are random UUIDs that we only send to the
+// measured participant, never along with cached results, so they double as
+// download tokens.  It's safe for concurrent use.  A nil sessionStore keeps
+// nothing.
+type sessionStore struct {
+	sync.Mutex // Guards entries.
+	retention  time.Duration
+	entries    map[string]*sessionEntry
+	now        func() time.Time
+}
+
+// newSessionStore returns a new session store that keeps measurements for the
+// given retention period, or nil if the period is zero.
+func newSessionStore(retention time.Duration) *sessionStore {
+	if retention <= 0 {
+		return nil
+	}
+	return &sessionStore{
+		retention: retention,
+		entries:   make(map[string]*sessionEntry),
+		now:       time.Now,
+	}
+}
+
+// put stores the given measurement under its session ID and forgets expired
+// measurements.  Measurements without session ID aren't stored.
+func (s *sessionStore) put(m *measurement) {
+	if s == nil || m.SessionID == "" {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	for id, e := range s.entries {
+		if now.Sub(e.t) > s.retention {
+			delete(s.entries, id)
+		}
+	}
+	// Our reference measurements are about our own hosts, not the
+	// participant.
+	participant := *m
+	participant.References = nil
+	s.entries[m.SessionID] = &sessionEntry{m: &participant, t: now}
+}
+
+// get returns the measurement of the given session, if we still have it.
+func (s *sessionStore) get(id string) (*measurement, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	e, exists := s.entries[id]
+	if !exists || s.now().Sub(e.t) > s.retention {
+		return nil, false
+	}
+	return e.m, true
+}
+
+// writeSummary writes a human-readable summary of the given measurement.
+func writeSummary(w io.Writer, m *measurement) error {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	lines := []string{
+		fmt.Sprintf("Session:            %s", m.SessionID),
+		fmt.Sprintf("Measured at:        %s", m.Time.Format(time.RFC1123)),
+		fmt.Sprintf("Round trip time:    %.1f ms", m.RTT),
+		fmt.Sprintf("Behind a tunnel:    %s", yesNo(m.Tunneled)),
+	}
+	if m.Network != nil {
+		lines = append(lines, fmt.Sprintf("Network prefix:     %s", m.Network.Prefix))
+	}
+	if m.TLS != nil {
+		lines = append(lines, fmt.Sprintf("TLS version:        %s", m.TLS.Version))
+	}
+	if m.ClientConnection != nil {
+		lines = append(lines, fmt.Sprintf("Browser's estimate: %.0f ms (%s)",
+			m.ClientConnection.RTT, m.ClientConnection.EffectiveType))
+	}
+	if m.ICECandidates != nil {
+		lines = append(lines, fmt.Sprintf("WebRTC mismatch:    %s", yesNo(m.ICEMismatch)))
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getSessionHandler returns a handler that lets participants download the
+// measurement of their session: as JSON by default, and as a human-readable
+// summary if the "format" query parameter is "text".
+func getSessionHandler(s *sessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, exists := s.get(chi.URLParam(r, "id"))
+		if !exists {
+			http.Error(w, "no such session", http.StatusNotFound)
+			return
+		}
+		// Keep participants' data out of shared caches.
+		w.Header().Set("Cache-Control", "no-store")
+		if r.URL.Query().Get("format") == formatText {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if err := writeSummary(w, m); err != nil {
+				l.Printf("Error writing session summary: %v", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="zerotrace-`+m.SessionID+`.json"`)
+		if err := json.NewEncoder(w).Encode(m); err != nil {
+			l.Printf("Error writing session data: %v", err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func TestSessionStore(t *testing.T) {
+	var (
+		now = time.Now()
+		s   = newSessionStore(time.Hour)
+		m   = &measurement{SessionID: "foo", RTT: 12.5, References: []*record{{}}}
+	)
+	s.now = func() time.Time { return now }
+
+	if _, exists := s.get("foo"); exists {
+		t.Fatal("Expected empty session store.")
+	}
+	s.put(m)
+	stored, exists := s.get("foo")
+	if !exists || stored.RTT != 12.5 {
+		t.Fatalf("Expected stored measurement but got %+v.", stored)
+	}
+	// Participants don't get our reference measurements.
+	if stored.References != nil || m.References == nil {
+		t.Fatalf("Expected references to be stripped from stored copy only.")
+	}
+
+	// Measurements without session ID aren't stored.
+	s.put(&measurement{Error: "unresponsive"})
+	if len(s.entries) != 1 {
+		t.Fatalf("Expected one stored measurement but got %d.", len(s.entries))
+	}
+
+	// Once the retention period passed, the measurement is forgotten.
+	now = now.Add(61 * time.Minute)
+	if _, exists := s.get("foo"); exists {
+		t.Fatal("Expected expired measurement not to be returned.")
+	}
+	s.put(&measurement{SessionID: "bar"})
+	if len(s.entries) != 1 {
+		t.Fatalf("Expected expired measurement to be forgotten but got %d entries.", len(s.entries))
+	}
+
+	// A nil session store keeps nothing.
+	var nilStore *sessionStore
+	nilStore.put(m)
+	if _, exists := nilStore.get("foo"); exists || newSessionStore(0) != nil {
+		t.Fatal("Expected nil session store to keep nothing.")
+	}
+}
+
+func TestSessionHandler(t *testing.T) {
+	s := newSessionStore(time.Hour)
+	s.put(&measurement{SessionID: "foo", RTT: 12.5, Tunneled: true})
+	router := chi.NewRouter()
+	router.Get("/sessions/{id}", getSessionHandler(s))
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/sessions/foo")
+	if err != nil {
+		t.Fatalf("Failed to fetch session: %v", err)
+	}
+	defer resp.Body.Close()
+	var m measurement
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("Failed to decode session: %v", err)
+	}
+	if m.SessionID != "foo" || m.RTT != 12.5 {
+		t.Fatalf("Unexpected measurement: %+v", m)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Fatalf("Expected uncacheable response but got %q.", resp.Header.Get("Cache-Control"))
+	}
+
+	resp, err = http.Get(srv.URL + "/sessions/foo?format=text")
+	if err != nil {
+		t.Fatalf("Failed to fetch session summary: %v", err)
+	}
+	defer resp.Body.Close()
+	summary, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read session summary: %v", err)
+	}
+	if !strings.Contains(string(summary), "Round trip time:    12.5 ms") ||
+		!strings.Contains(string(summary), "Behind a tunnel:    yes") {
+		t.Fatalf("Unexpected summary:\n%s", summary)
+	}
+
+	resp, err = http.Get(srv.URL + "/sessions/bar")
+	if err != nil {
+		t.Fatalf("Failed to fetch session: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status %d but got %d.", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// minKeyLen is the minimum length of our HMAC key in bytes.
+const minKeyLen = 32
+
+var (
+	errBadSig        = errors.New("record signature mismatch")
+	errNoSig         = errors.New("record is not signed")
+	errShortKey      = errors.New("HMAC key is too short")
+	errAlreadySigned = errors.New("record is already signed")
+)
+
+// loadKeyFile reads the HMAC key from the file at the given path.  Trailing
+// whitespace is ignored.
+func loadKeyFile(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key = bytes.TrimRight(key, " \t\r\n")
+	if len(key) < minKeyLen {
+		return nil, errShortKey
+	}
+	return key, nil
+}
+
+// recordMAC returns the HMAC-SHA256 of the given record's JSON encoding,
+// which must not contain a signature yet.
+func recordMAC(r *record, key []byte) ([]byte, error) {
+	if r.Sig != "" {
+		return nil, errAlreadySigned
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// signRecord sets the given record's signature, so consumers of our records
+// can verify that a record wasn't altered after we wrote it.
+func signRecord(r *record, key []byte) error {
+	r.Sig = ""
+	mac, err := recordMAC(r, key)
+	if err != nil {
+		return err
+	}
+	r.Sig = hex.EncodeToString(mac)
+	return nil
+}
+
+// verifyRecord verifies the signature of the given JSON-encoded record.
+func verifyRecord(line []byte, key []byte) error {
+	var r record
+	if err := json.Unmarshal(line, &r); err != nil {
+		return err
+	}
+	if r.Sig == "" {
+		return errNoSig
+	}
+	sig, err := hex.DecodeString(r.Sig)
+	if err != nil {
+		return err
+	}
+	r.Sig = ""
+	mac, err := recordMAC(&r, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(sig, mac) {
+		return errBadSig
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte(strings.Repeat("k", minKeyLen))
+
+func TestSignRecord(t *testing.T) {
+	var b bytes.Buffer
+	w, err := newRecordWriter(&b, formatJSON, testKey)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	w.write(&record{
+		Time:   time.Now().UTC(),
+		Target: "192.0.2.1:443",
+		RTT:    12.345,
+	})
+	line := b.Bytes()
+	if err := verifyRecord(line, testKey); err != nil {
+		t.Fatalf("Expected valid signature but got: %v", err)
+	}
+
+	// Verification fails for the wrong key and for altered records.
+	if err := verifyRecord(line, []byte("wrong")); err != errBadSig {
+		t.Fatalf("Expected error %v but got %v.", errBadSig, err)
+	}
+	altered := bytes.Replace(line, []byte("12.345"), []byte("1.234"), 1)
+	if err := verifyRecord(altered, testKey); err != errBadSig {
+		t.Fatalf("Expected error %v but got %v.", errBadSig, err)
+	}
+	// So does verification of truncated records.
+	if err := verifyRecord(line[:len(line)/2], testKey); err == nil {
+		t.Fatal("Expected error for truncated record.")
+	}
+}
+
+func TestUnsignedRecord(t *testing.T) {
+	var b bytes.Buffer
+	w, _ := newRecordWriter(&b, formatJSON, nil)
+	w.write(&record{Target: "192.0.2.1:443"})
+	if strings.Contains(b.String(), "sig") {
+		t.Fatalf("Expected unsigned record but got: %s", b.String())
+	}
+	if err := verifyRecord(b.Bytes(), testKey); err != errNoSig {
+		t.Fatalf("Expected error %v but got %v.", errNoSig, err)
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, append(testKey, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := loadKeyFile(path)
+	if err != nil || !bytes.Equal(key, testKey) {
+		t.Fatalf("Expected key %q but got %q (error: %v).", testKey, key, err)
+	}
+
+	if err := os.WriteFile(path, []byte("short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKeyFile(path); err != errShortKey {
+		t.Fatalf("Expected error %v but got %v.", errShortKey, err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+// simTracer implements zerotrace.Tracer without sending a single packet.  Its
+// traceroutes follow a scripted path of routers toward the client, and the RTT
+// and loss of each trace packet are drawn at random: RTTs grow linearly along
+// the path toward the given mean RTT to the client, plus normally-distributed
+// jitter with the given standard deviation, and each trace packet is lost with
+// the given probability.  This lets us exercise the Web service and everything
+// downstream of it without root privileges or a network interface to capture
+// on.
+type simTracer struct {
+	sync.Mutex // Guards rand.
+	rand       *rand.Rand
+	path       []net.IP
+	rtt        time.Duration
+	jitter     time.Duration
+	loss       float64
+	numProbes  int
+}
+
+var _ zerotrace.Tracer = (*simTracer)(nil)
+
+func newSimTracer(
+	path []net.IP,
+	rtt, jitter time.Duration,
+	loss float64,
+	numProbes int,
+) *simTracer {
+	return &simTracer{
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		path:      path,
+		rtt:       rtt,
+		jitter:    jitter,
+		loss:      loss,
+		numProbes: numProbes,
+	}
+}
+
+// parseSimPath parses the given comma-separated list of router addresses.
+func parseSimPath(s string) ([]net.IP, error) {
+	var path []net.IP
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid router address %q", field)
+		}
+		path = append(path, ip)
+	}
+	return path, nil
+}
+
+// Trace returns a synthetic traceroute toward the remote end of the given
+// connection.
+func (s *simTracer) Trace(conn net.Conn) (*zerotrace.Result, error) {
+	src, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection: %s", conn.LocalAddr())
+	}
+	dst, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection: %s", conn.RemoteAddr())
+	}
+	res := s.trace(dst.IP)
+	if res == nil {
+		return nil, zerotrace.ErrUnresponsive
+	}
+	res.Src, res.SrcPort = src.IP, uint16(src.Port)
+	res.DstPort = uint16(dst.Port)
+	return res, nil
+}
+
+// TraceAddr returns a synthetic traceroute toward the given host:port tuple.
+func (s *simTracer) TraceAddr(addr string) (*zerotrace.Result, error) {
+	dst, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	res := s.trace(dst.IP)
+	if res == nil {
+		return nil, zerotrace.ErrUnresponsive
+	}
+	res.DstPort = uint16(dst.Port)
+	return res, nil
+}
+
+// trace returns a synthetic traceroute toward the given client, or nil if all
+// of its trace packets were lost.
+func (s *simTracer) trace(client net.IP) *zerotrace.Result {
+	s.Lock()
+	defer s.Unlock()
+
+	var (
+		now  = time.Now().UTC()
+		path = append(append([]net.IP{}, s.path...), client)
+		res  = &zerotrace.Result{
+			Start: now,
+			Dst:   client,
+		}
+	)
+	for i, addr := range path {
+		hopRTT := s.rtt * time.Duration(i+1) / time.Duration(len(path))
+		h := &zerotrace.Hop{TTL: i + 1, Sent: s.numProbes}
+		for n := 0; n < s.numProbes; n++ {
+			p := &zerotrace.Probe{Sent: now}
+			h.Probes = append(h.Probes, p)
+			if s.rand.Float64() < s.loss {
+				continue
+			}
+			rtt := hopRTT + time.Duration(s.rand.NormFloat64()*float64(s.jitter))
+			if rtt <= 0 {
+				rtt = time.Microsecond
+			}
+			p.RTT, p.From = rtt, addr
+			h.Addr = addr
+			h.RTTs = append(h.RTTs, rtt)
+		}
+		res.Hops = append(res.Hops, h)
+	}
+
+	// Like 0trace, we report the RTT of the answered hop that's closest to
+	// the client.
+	for i := len(res.Hops) - 1; i >= 0; i-- {
+		h := res.Hops[i]
+		if len(h.RTTs) == 0 {
+			continue
+		}
+		res.RTT = h.RTTs[0]
+		for _, rtt := range h.RTTs {
+			if rtt < res.RTT {
+				res.RTT = rtt
+			}
+		}
+		res.TracedHops = h.TTL
+		break
+	}
+	if res.TracedHops == 0 {
+		return nil
+	}
+	path = make([]net.IP, len(res.Hops))
+	for i, h := range res.Hops {
+		path[i] = h.Addr
+	}
+	res.Paths = [][]net.IP{path}
+	return res
+}
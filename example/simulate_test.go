@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestParseSimPath(t *testing.T) {
+	path, err := parseSimPath(" 192.0.2.1, 198.51.100.1,,")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(path) != 2 || !path[1].Equal(net.ParseIP("198.51.100.1")) {
+		t.Fatalf("Unexpected path: %v", path)
+	}
+	if _, err := parseSimPath("192.0.2.1,foo"); err == nil {
+		t.Fatal("Expected error for invalid router address.")
+	}
+}
+
+func TestSimTracer(t *testing.T) {
+	var (
+		path = []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.1")}
+		s    = newSimTracer(path, 30*time.Millisecond, 0, 0, 3)
+	)
+	res, err := s.TraceAddr("203.0.113.1:443")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if len(res.Hops) != 3 || res.TracedHops != 3 {
+		t.Fatalf("Expected three hops but got %d.", len(res.Hops))
+	}
+	if !res.Hops[2].Addr.Equal(net.ParseIP("203.0.113.1")) {
+		t.Fatalf("Expected last hop to be the client but got %s.", res.Hops[2].Addr)
+	}
+	// Without jitter, RTTs grow linearly toward the mean RTT.
+	if res.RTT != 30*time.Millisecond || res.Hops[0].RTTs[0] != 10*time.Millisecond {
+		t.Fatalf("Unexpected RTTs: %v, %v", res.RTT, res.Hops[0].RTTs)
+	}
+	if res.DstPort != 443 {
+		t.Fatalf("Expected destination port 443 but got %d.", res.DstPort)
+	}
+
+	// If all trace packets are lost, the client is unresponsive.
+	s = newSimTracer(path, 30*time.Millisecond, 0, 1, 3)
+	if _, err := s.TraceAddr("203.0.113.1:443"); err != zerotrace.ErrUnresponsive {
+		t.Fatalf("Expected error %v but got %v.", zerotrace.ErrUnresponsive, err)
+	}
+}
+
+func TestSimTracerConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	res, err := newSimTracer(nil, time.Millisecond, time.Millisecond, 0, 1).Trace(conn)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if !res.Dst.Equal(net.ParseIP("127.0.0.1")) || res.SrcPort == 0 {
+		t.Fatalf("Unexpected result: %+v", res)
+	}
+}
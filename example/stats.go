@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+// maxStatsRTTs is the number of recent RTTs that we keep to compute the
+// median RTT.
+const maxStatsRTTs = 1000
+
+// stats keeps aggregate statistics of the measurements since we started, so
+// operators can check our health without analyzing results offline.  It's
+// safe for concurrent use.
+type stats struct {
+	sync.Mutex // Guards the fields up to and including now.
+	since      time.Time
+	sessions   int
+	completed  int
+	errors     map[string]int
+	rtts       []time.Duration // The most recent RTTs, in no particular order.
+	nextRTT    int
+	recent     []time.Time // The times of the last day's sessions.
+	now        func() time.Time
+	// active is the number of sessions in progress.
+	active atomic.Int64
+	// captureRestarts returns the number of times that our packet capture
+	// was restarted, or is nil if we don't capture packets.
+	captureRestarts func() int64
+}
+
+// statsSnapshot is the JSON representation of our statistics.
+type statsSnapshot struct {
+	Since           time.Time      `json:"since"`
+	Sessions        int            `json:"sessions"`
+	SessionsLastDay int            `json:"sessions_last_day"`
+	CompletionRate  float64        `json:"completion_rate"`
+	MedianRTT       float64        `json:"median_rtt_ms"`
+	Errors          map[string]int `json:"errors"`
+	CaptureRestarts int64          `json:"capture_restarts"`
+}
+
+func newStats() *stats {
+	return &stats{
+		since:  time.Now().UTC(),
+		errors: make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+// errorClass returns the class of the given measurement error.  We don't use
+// the error's text because it may contain addresses.
+func errorClass(err error) string {
+	switch err {
+	case zerotrace.ErrBlocked:
+		return "blocked"
+	case zerotrace.ErrUnresponsive:
+		return "unresponsive"
+	case zerotrace.ErrBusy:
+		return "busy"
+	case zerotrace.ErrThrottled:
+		return "throttled"
+	case errTracePanic:
+		return "internal"
+	}
+	return "other"
+}
+
+// record records a measurement that resulted in the given RTT or error.
+func (s *stats) record(rtt time.Duration, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	s.sessions++
+	s.recent = append(s.recent, now)
+	s.pruneRecent(now)
+	if err != nil {
+		s.errors[errorClass(err)]++
+		return
+	}
+	s.completed++
+	if len(s.rtts) < maxStatsRTTs {
+		s.rtts = append(s.rtts, rtt)
+		return
+	}
+	s.rtts[s.nextRTT] = rtt
+	s.nextRTT = (s.nextRTT + 1) % maxStatsRTTs
+}
+
+// pruneRecent forgets the sessions that are more than a day old.
+func (s *stats) pruneRecent(now time.Time) {
+	i := 0
+	for i < len(s.recent) && now.Sub(s.recent[i]) > 24*time.Hour {
+		i++
+	}
+	s.recent = s.recent[i:]
+}
+
+// snapshot returns a snapshot of our statistics.
+func (s *stats) snapshot() *statsSnapshot {
+	s.Lock()
+	defer s.Unlock()
+
+	s.pruneRecent(s.now())
+	snap := &statsSnapshot{
+		Since:           s.since,
+		Sessions:        s.sessions,
+		SessionsLastDay: len(s.recent),
+		Errors:          make(map[string]int),
+	}
+	for class, n := range s.errors {
+		snap.Errors[class] = n
+	}
+	if s.captureRestarts != nil {
+		snap.CaptureRestarts = s.captureRestarts()
+	}
+	if s.sessions > 0 {
+		snap.CompletionRate = float64(s.completed) / float64(s.sessions)
+	}
+	if len(s.rtts) > 0 {
+		rtts := append([]time.Duration(nil), s.rtts...)
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		median := rtts[len(rtts)/2]
+		if len(rtts)%2 == 0 {
+			median = (rtts[len(rtts)/2-1] + median) / 2
+		}
+		snap.MedianRTT = float64(median) / float64(time.Millisecond)
+	}
+	return snap
+}
+
+func getStatsHandler(s *stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.snapshot()); err != nil {
+			l.Printf("Error writing statistics: %v", err)
+		}
+	}
+}
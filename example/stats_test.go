@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+func TestStats(t *testing.T) {
+	var (
+		s   = newStats()
+		now = time.Now()
+	)
+	s.now = func() time.Time { return now }
+
+	// A day-old session only counts toward the total.
+	s.record(40*time.Millisecond, nil)
+	now = now.Add(25 * time.Hour)
+	for _, rtt := range []time.Duration{10, 30, 20} {
+		s.record(rtt*time.Millisecond, nil)
+	}
+	s.record(0, zerotrace.ErrUnresponsive)
+	s.record(0, zerotrace.ErrBlocked)
+	s.record(0, errors.New("dial tcp4 192.0.2.1:443: i/o timeout"))
+	s.record(0, errTracePanic)
+	s.record(0, zerotrace.ErrBusy)
+	s.record(0, zerotrace.ErrThrottled)
+
+	snap := s.snapshot()
+	if snap.Sessions != 10 || snap.SessionsLastDay != 9 {
+		t.Fatalf("Expected 10 sessions (9 in the last day) but got %d (%d).",
+			snap.Sessions, snap.SessionsLastDay)
+	}
+	if snap.CompletionRate != 4.0/10.0 {
+		t.Fatalf("Unexpected completion rate %f.", snap.CompletionRate)
+	}
+	if snap.MedianRTT != 25 {
+		t.Fatalf("Expected median RTT of 25 ms but got %f.", snap.MedianRTT)
+	}
+	for _, class := range []string{"unresponsive", "blocked", "busy", "throttled", "internal", "other"} {
+		if snap.Errors[class] != 1 {
+			t.Fatalf("Expected one %q error but got %d.", class, snap.Errors[class])
+		}
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	s := newStats()
+	s.record(10*time.Millisecond, nil)
+	s.captureRestarts = func() int64 { return 2 }
+
+	w := httptest.NewRecorder()
+	getStatsHandler(s)(w, httptest.NewRequest("GET", "/api/v1/stats", nil))
+
+	var snap statsSnapshot
+	if err := json.NewDecoder(w.Body).Decode(&snap); err != nil {
+		t.Fatalf("Failed to decode statistics: %v", err)
+	}
+	if snap.Sessions != 1 || snap.MedianRTT != 10 || snap.CaptureRestarts != 2 {
+		t.Fatalf("Unexpected statistics: %+v", snap)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+const (
+	// streamBufSize is the number of measurements that we buffer for each
+	// subscriber.  Subscribers that fall further behind miss measurements.
+	streamBufSize    = 64
+	streamKeepalive  = 15 * time.Second
+	streamAuthPrefix = "Bearer "
+)
+
+// measurement is the summary of a completed measurement that we stream to
+// subscribers.
+type measurement struct {
+	Time        time.Time `json:"time"`
+	SessionID   string    `json:"session_id,omitempty"`
+	RTT         float64   `json:"rtt_ms"`
+	SelfLatency float64   `json:"self_latency_ms,omitempty"`
+	Tunneled    bool      `json:"tunneled,omitempty"`
+	// Error is the class of the error that failed the measurement (see
+	// errorClass), if any.  The error's text may contain addresses.
+	Error string   `json:"error,omitempty"`
+	TLS   *tlsInfo `json:"tls,omitempty"`
+	// References holds the control measurements toward our reference
+	// targets that ran alongside the client's measurement.
+	References []*record `json:"references,omitempty"`
+	// Network describes the client's network, as seen by RIPEstat.
+	Network *networkInfo `json:"network,omitempty"`
+	// ClientConnection is the client's view of its connection, as reported
+	// by its browser's Network Information API.
+	ClientConnection *client.ConnectionInfo `json:"client_connection,omitempty"`
+	// ClientTimings are the browser's timings of the measurement page's
+	// fetches.
+	ClientTimings []*client.Timing `json:"client_timings,omitempty"`
+	// BrowserRTT is the browser's fastest TCP handshake in milliseconds,
+	// which approximates its RTT to whatever terminated its connection.
+	BrowserRTT float64 `json:"browser_rtt_ms,omitempty"`
+	// TimingMismatch is true if BrowserRTT is much lower than RTT, which
+	// suggests a TCP- and TLS-terminating middlebox close to the client.
+	TimingMismatch bool `json:"timing_mismatch,omitempty"`
+	// ICECandidates counts the WebRTC ICE candidates that the client
+	// gathered, by candidate type.
+	ICECandidates map[string]int `json:"ice_candidates,omitempty"`
+	// ICEMismatch is true if the client's server-reflexive ICE candidates
+	// don't match its address as seen over HTTP, which suggests split
+	// tunneling or a proxy that only the browser uses.
+	ICEMismatch bool `json:"ice_mismatch,omitempty"`
+}
+
+// broker fans out completed measurements to subscribers.  It's safe for
+// concurrent use.  A nil broker discards all measurements.
+type broker struct {
+	sync.Mutex // Guards subs.
+	subs       map[chan []byte]struct{}
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan []byte]struct{})}
+}
+
+// subscribe returns a new channel over which the subscriber receives the
+// JSON encoding of each measurement.
+func (b *broker) subscribe() chan []byte {
+	b.Lock()
+	defer b.Unlock()
+
+	c := make(chan []byte, streamBufSize)
+	b.subs[c] = struct{}{}
+	return c
+}
+
+// unsubscribe stops sending measurements to the given channel.
+func (b *broker) unsubscribe(c chan []byte) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.subs, c)
+}
+
+// publish sends the given measurement to all subscribers.  We never block on
+// slow subscribers; they miss the measurement instead.  Measurements that
+// violate our measurement schema are logged and dropped.
+func (b *broker) publish(m *measurement) {
+	if b == nil {
+		return
+	}
+	if err := validateRecord(measurementSchema, m); err != nil {
+		l.Printf("Error validating measurement: %v", err)
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		l.Printf("Error encoding measurement: %v", err)
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	for c := range b.subs {
+		select {
+		case c <- data:
+		default:
+		}
+	}
+}
+
+// isAuthorized returns true if the given request carries the given bearer
+// token.
+func isAuthorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, streamAuthPrefix) {
+		return false
+	}
+	given := strings.TrimPrefix(auth, streamAuthPrefix)
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// getStreamHandler returns a handler that streams completed measurements as
+// Server-Sent Events to clients that authenticate with the given token.  If
+// the token is empty, the handler leaves authentication to middleware.
+func getStreamHandler(b *broker, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && !isAuthorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		c := b.subscribe()
+		defer b.unsubscribe(c)
+		ticker := time.NewTicker(streamKeepalive)
+		defer ticker.Stop()
+
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case data := <-c:
+				_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			case <-ticker.C:
+				// Comments keep proxies from closing idle connections.
+				_, err = fmt.Fprint(w, ": keepalive\n\n")
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
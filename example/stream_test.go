@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	b := newBroker()
+	srv := httptest.NewServer(getStreamHandler(b, "secret"))
+	defer srv.Close()
+
+	// Unauthenticated subscribers are turned away.
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d but got %d.", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Publish until the subscription is registered.
+	m := &measurement{Time: time.Now().UTC(), SessionID: "foo", RTT: 12.5}
+	go func() {
+		for i := 0; i < 50; i++ {
+			b.publish(m)
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var got measurement
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if got.SessionID != m.SessionID || got.RTT != m.RTT {
+			t.Fatalf("Expected measurement %+v but got %+v.", m, got)
+		}
+		return
+	}
+	t.Fatalf("Stream ended without measurement: %v", s.Err())
+}
+
+func TestNilBroker(t *testing.T) {
+	var b *broker
+	b.publish(&measurement{})
+}
+
+func TestIsAuthorized(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if isAuthorized(r, "secret") {
+		t.Fatal("Expected request without token to be unauthorized.")
+	}
+	r.Header.Set("Authorization", "Bearer wrong")
+	if isAuthorized(r, "secret") {
+		t.Fatal("Expected request with wrong token to be unauthorized.")
+	}
+	r.Header.Set("Authorization", "Bearer secret")
+	if !isAuthorized(r, "secret") {
+		t.Fatal("Expected request with token to be authorized.")
+	}
+}
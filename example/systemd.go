@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends the given state, e.g., "READY=1", to systemd's notification
+// socket at the given path, i.e., $NOTIFY_SOCKET.  If the path is empty, we
+// don't run under systemd (or not as Type=notify service), and sdNotify does
+// nothing.
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return nil
+	}
+	// A leading '@' denotes a socket in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the interval at which we should ping systemd's
+// watchdog, given $WATCHDOG_USEC and $WATCHDOG_PID.  We ping twice per timeout,
+// as systemd recommends.  If the watchdog is disabled, or meant for another
+// process, the interval is zero.
+func sdWatchdogInterval(usec, pid string) time.Duration {
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Microsecond / 2
+}
+
+// runWatchdog pings systemd's watchdog at the given interval for as long as
+// the given health check passes.  Once it fails, we stop pinging, so systemd
+// restarts us when our measurement pipeline is wedged, and not only when our
+// process exits.
+func runWatchdog(socket string, interval time.Duration, healthy func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := healthy(); err != nil {
+			l.Printf("Withholding watchdog ping: %v", err)
+			continue
+		}
+		if err := sdNotify(socket, "WATCHDOG=1"); err != nil {
+			l.Printf("Error pinging systemd watchdog: %v", err)
+		}
+	}
+}
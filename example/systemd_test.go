@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	if err := sdNotify(path, "READY=1"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if string(buf[:n]) != "READY=1" {
+		t.Fatalf("Expected notification %q but got %q.", "READY=1", buf[:n])
+	}
+
+	// Without notification socket, there's nothing to notify.
+	if err := sdNotify("", "READY=1"); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := sdNotify(filepath.Join(t.TempDir(), "missing.sock"), "READY=1"); err == nil {
+		t.Fatal("Expected error for missing socket.")
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		usec, pid string
+		interval  time.Duration
+	}{
+		{"30000000", "", 15 * time.Second},
+		{"30000000", pid, 15 * time.Second},
+		// The watchdog is meant for another process.
+		{"30000000", "1", 0},
+		{"", "", 0},
+		{"foo", "", 0},
+	} {
+		if got := sdWatchdogInterval(test.usec, test.pid); got != test.interval {
+			t.Fatalf("Expected interval %s for %q but got %s.", test.interval, test.usec, got)
+		}
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	var checks int
+	go runWatchdog(path, 10*time.Millisecond, func() error {
+		// Park the watchdog after its first ping, so it doesn't outlive
+		// our socket.
+		if checks++; checks > 1 {
+			select {}
+		}
+		return nil
+	})
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("Failed to set deadline: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected watchdog ping but got: %v", err)
+	}
+	if string(buf[:n]) != "WATCHDOG=1" {
+		t.Fatalf("Expected ping %q but got %q.", "WATCHDOG=1", buf[:n])
+	}
+}
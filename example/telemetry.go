@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+var errDuplicateMessage = errors.New("duplicate message")
+
+// timingMismatchRatio determines how much shorter than our RTT the browser's
+// TCP handshakes must be for us to flag a timing mismatch.
+const timingMismatchRatio = 0.5
+
+// candidatesTimeout is the time that we wait for the client's ICE candidates
+// once its measurement is done.  Browsers stop gathering candidates after five
+// seconds (see gatherCandidates), which started when the client connected.
+const candidatesTimeout = 6 * time.Second
+
+// clientTelemetry collects what a client reports about itself while we
+// measure it, so we can store it with the client's measurement.  Clients
+// report over the WebSocket connection that we measure rather than to separate
+// endpoints, which ties each report to its measurement without a token that
+// could be replayed, and lets us compare ICE candidates against the address of
+// the very connection that we trace.  It's safe for concurrent use.
+type clientTelemetry struct {
+	sync.Mutex // Guards the fields below.
+	host       string
+	connection *client.ConnectionInfo
+	timings    []*client.Timing
+	candidates []*client.Candidate
+	// candidatesRecvd is closed once the client reported its candidates.
+	candidatesRecvd chan struct{}
+}
+
+// newClientTelemetry returns a new clientTelemetry for the client with the
+// given address, as seen over HTTP.
+func newClientTelemetry(host string) *clientTelemetry {
+	return &clientTelemetry{host: host, candidatesRecvd: make(chan struct{})}
+}
+
+// record records the given client message.  Clients may only send pongs and
+// telemetry, and each kind of telemetry only once.
+func (t *clientTelemetry) record(msg *client.Message) error {
+	t.Lock()
+	defer t.Unlock()
+
+	switch msg.Type {
+	case client.TypePong:
+	case client.TypeConnection:
+		if t.connection != nil {
+			return fmt.Errorf("%w: %q from client", errDuplicateMessage, msg.Type)
+		}
+		t.connection = msg.Connection
+	case client.TypeTimings:
+		if t.timings != nil {
+			return fmt.Errorf("%w: %q from client", errDuplicateMessage, msg.Type)
+		}
+		t.timings = msg.Timings
+	case client.TypeCandidates:
+		if t.candidates != nil {
+			return fmt.Errorf("%w: %q from client", errDuplicateMessage, msg.Type)
+		}
+		t.candidates = msg.Candidates
+		if t.candidatesRecvd != nil {
+			close(t.candidatesRecvd)
+		}
+	default:
+		return fmt.Errorf("%w: %q from client", client.ErrUnknownType, msg.Type)
+	}
+	return nil
+}
+
+// awaitCandidates waits up to the given time for the client to report its ICE
+// candidates, which browsers gather in the background, and returns false if
+// they didn't arrive in time.  Browsers that gathered no candidates never
+// report them.
+func (t *clientTelemetry) awaitCandidates(timeout time.Duration) bool {
+	select {
+	case <-t.candidatesRecvd:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// fill adds the telemetry that the client reported so far to the given
+// measurement, whose RTT must be set.  We flag the measurement if the
+// browser's TCP handshakes were much faster than our RTT to the client.  That
+// happens if a middlebox close to the client terminates its TCP and TLS
+// connections: the browser then times its handshake with the middlebox, while
+// our RTT covers the entire path.
+func (t *clientTelemetry) fill(m *measurement) {
+	t.Lock()
+	defer t.Unlock()
+
+	m.ClientConnection = t.connection
+	m.ClientTimings = t.timings
+	m.BrowserRTT = 0
+	for _, timing := range t.timings {
+		if timing.Connect > 0 && (m.BrowserRTT == 0 || timing.Connect < m.BrowserRTT) {
+			m.BrowserRTT = timing.Connect
+		}
+	}
+	m.TimingMismatch = m.BrowserRTT > 0 && m.BrowserRTT < m.RTT*timingMismatchRatio
+
+	m.ICECandidates = nil
+	for _, c := range t.candidates {
+		if m.ICECandidates == nil {
+			m.ICECandidates = make(map[string]int)
+		}
+		m.ICECandidates[c.Type]++
+	}
+	m.ICEMismatch = isICEMismatch(t.candidates, net.ParseIP(t.host))
+}
+
+// isICEMismatch returns true if the given ICE candidates include
+// server-reflexive candidates of the same address family as the given client
+// address, but none of them has the client's address.  That means that the
+// browser's UDP traffic leaves through a different network than its HTTP
+// traffic, e.g., because of split tunneling, or because the browser uses a
+// proxy that the rest of the system doesn't.
+func isICEMismatch(candidates []*client.Candidate, clientIP net.IP) bool {
+	if clientIP == nil {
+		return false
+	}
+	var mismatch bool
+	for _, c := range candidates {
+		ip := net.ParseIP(c.Address)
+		if c.Type != "srflx" || ip == nil || (ip.To4() == nil) != (clientIP.To4() == nil) {
+			continue
+		}
+		if ip.Equal(clientIP) {
+			return false
+		}
+		mismatch = true
+	}
+	return mismatch
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace/pkg/client"
+)
+
+func TestClientTelemetry(t *testing.T) {
+	var (
+		tel  = &clientTelemetry{}
+		conn = &client.ConnectionInfo{EffectiveType: "4g", RTT: 50, Downlink: 10}
+		m    = &measurement{}
+	)
+	if err := tel.record(client.NewPong(client.NewPing(1))); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	tel.fill(m)
+	if m.ClientConnection != nil {
+		t.Fatalf("Expected no connection information but got %+v.", m.ClientConnection)
+	}
+
+	if err := tel.record(client.NewConnectionMessage(conn)); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	tel.fill(m)
+	if m.ClientConnection != conn {
+		t.Fatalf("Expected connection information %+v but got %+v.", conn, m.ClientConnection)
+	}
+
+	// Clients may report their connection only once.
+	if err := tel.record(client.NewConnectionMessage(conn)); !errors.Is(err, errDuplicateMessage) {
+		t.Fatalf("Expected error %v but got %v.", errDuplicateMessage, err)
+	}
+	// Clients may not send results.
+	err := tel.record(client.NewResultMessage(&client.Result{}))
+	if !errors.Is(err, client.ErrUnknownType) {
+		t.Fatalf("Expected error %v but got %v.", client.ErrUnknownType, err)
+	}
+}
+
+func TestClientTelemetryTimings(t *testing.T) {
+	var (
+		tel     = &clientTelemetry{}
+		timings = []*client.Timing{
+			{Type: "navigation", Connect: 30},
+			{Type: "resource"}, // Reused connection.
+			{Type: "resource", Connect: 25},
+		}
+	)
+	if err := tel.record(client.NewTimingsMessage(timings)); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := tel.record(client.NewTimingsMessage(timings)); !errors.Is(err, errDuplicateMessage) {
+		t.Fatalf("Expected error %v but got %v.", errDuplicateMessage, err)
+	}
+
+	// The browser's handshakes roughly match our RTT.
+	m := &measurement{RTT: 30}
+	tel.fill(m)
+	if len(m.ClientTimings) != 3 || m.BrowserRTT != 25 || m.TimingMismatch {
+		t.Fatalf("Unexpected measurement: %+v", m)
+	}
+
+	// The browser's handshakes are much faster than our RTT.
+	m = &measurement{RTT: 100}
+	tel.fill(m)
+	if !m.TimingMismatch {
+		t.Fatalf("Expected timing mismatch for measurement: %+v", m)
+	}
+
+	// Without our RTT, there's nothing to compare against.
+	m = &measurement{}
+	tel.fill(m)
+	if m.TimingMismatch {
+		t.Fatalf("Expected no timing mismatch for measurement: %+v", m)
+	}
+}
+
+func TestClientTelemetryCandidates(t *testing.T) {
+	tel := newClientTelemetry("192.0.2.1")
+	candidates := []*client.Candidate{
+		{Type: "host", Address: "0c2f7a1e-4b5d.local"},
+		{Type: "srflx", Address: "198.51.100.1"},
+		{Type: "srflx", Address: "2001:db8::1"},
+	}
+	if err := tel.record(client.NewCandidatesMessage(candidates)); err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if err := tel.record(client.NewCandidatesMessage(candidates)); !errors.Is(err, errDuplicateMessage) {
+		t.Fatalf("Expected error %v but got %v.", errDuplicateMessage, err)
+	}
+	if !tel.awaitCandidates(time.Second) {
+		t.Fatal("Expected candidates to have arrived.")
+	}
+	m := &measurement{}
+	tel.fill(m)
+	if m.ICECandidates["host"] != 1 || m.ICECandidates["srflx"] != 2 || !m.ICEMismatch {
+		t.Fatalf("Unexpected measurement: %+v", m)
+	}
+}
+
+func TestClientTelemetryAwaitCandidates(t *testing.T) {
+	tel := newClientTelemetry("192.0.2.1")
+	if tel.awaitCandidates(10 * time.Millisecond) {
+		t.Fatal("Expected no candidates to have arrived.")
+	}
+
+	// Candidates that arrive while we wait end the wait.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = tel.record(client.NewCandidatesMessage([]*client.Candidate{
+			{Type: "srflx", Address: "192.0.2.1"},
+		}))
+	}()
+	if !tel.awaitCandidates(time.Second) {
+		t.Fatal("Expected candidates to have arrived.")
+	}
+	m := &measurement{}
+	tel.fill(m)
+	if m.ICECandidates["srflx"] != 1 || m.ICEMismatch {
+		t.Fatalf("Unexpected measurement: %+v", m)
+	}
+}
+
+func TestIsICEMismatch(t *testing.T) {
+	var (
+		clientIP = net.ParseIP("192.0.2.1")
+		host     = &client.Candidate{Type: "host", Address: "10.0.0.2"}
+		match    = &client.Candidate{Type: "srflx", Address: "192.0.2.1"}
+		other    = &client.Candidate{Type: "srflx", Address: "198.51.100.1"}
+		otherV6  = &client.Candidate{Type: "srflx", Address: "2001:db8::1"}
+	)
+	for _, test := range []struct {
+		candidates []*client.Candidate
+		clientIP   net.IP
+		mismatch   bool
+	}{
+		{[]*client.Candidate{host}, clientIP, false},
+		{[]*client.Candidate{host, match}, clientIP, false},
+		{[]*client.Candidate{host, other}, clientIP, true},
+		{[]*client.Candidate{other, match}, clientIP, false},
+		// Candidates of another address family tell us nothing.
+		{[]*client.Candidate{otherV6}, clientIP, false},
+		{[]*client.Candidate{other}, nil, false},
+	} {
+		if got := isICEMismatch(test.candidates, test.clientIP); got != test.mismatch {
+			t.Fatalf("Expected mismatch %v for %v but got %v.", test.mismatch, test.candidates, got)
+		}
+	}
+}
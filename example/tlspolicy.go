@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// tlsVersions maps the names of the TLS versions that we accept as minimum to
+// their identifiers.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsCurves maps curve names to their identifiers.
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// tlsPolicy determines the TLS parameters that our listeners negotiate.
+type tlsPolicy struct {
+	minVersion uint16
+	curves     []tls.CurveID
+	alpn       []string
+}
+
+// splitList splits the given comma-separated list and drops empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// parseTLSPolicy parses the given minimum TLS version (e.g., "1.3"), and the
+// comma-separated lists of curves in order of preference (e.g., "X25519,P256")
+// and ALPN protocols (e.g., "h2,http/1.1").  An empty list of curves means
+// Go's default preferences.
+func parseTLSPolicy(minVersion, curves, alpn string) (*tlsPolicy, error) {
+	p := &tlsPolicy{}
+	var ok bool
+	if p.minVersion, ok = tlsVersions[minVersion]; !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", minVersion)
+	}
+	for _, name := range splitList(curves) {
+		c, ok := tlsCurves[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", name)
+		}
+		p.curves = append(p.curves, c)
+	}
+	p.alpn = splitList(alpn)
+	if len(p.alpn) == 0 {
+		return nil, fmt.Errorf("no ALPN protocols given")
+	}
+	for _, proto := range p.alpn {
+		if proto != "h2" && proto != "http/1.1" {
+			return nil, fmt.Errorf("unsupported ALPN protocol %q", proto)
+		}
+	}
+	return p, nil
+}
+
+// offersHTTP2 returns true if the policy's ALPN protocols include HTTP/2.
+func (p *tlsPolicy) offersHTTP2() bool {
+	for _, proto := range p.alpn {
+		if proto == "h2" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply configures the given server to negotiate TLS according to the policy,
+// using the given function to look up our certificate.
+func (p *tlsPolicy) apply(
+	srv *http.Server,
+	getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) {
+	srv.TLSConfig = &tls.Config{
+		GetCertificate:   getCert,
+		MinVersion:       p.minVersion,
+		CurvePreferences: p.curves,
+		NextProtos:       p.alpn,
+	}
+	if !p.offersHTTP2() {
+		// A non-nil map keeps net/http from enabling HTTP/2 by itself.
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+}
+
+// tlsInfo holds the TLS parameters that a client negotiated with us.  They
+// tell us what a client supports, which makes them a useful fingerprint.
+type tlsInfo struct {
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ALPN        string `json:"alpn,omitempty"`
+	ServerName  string `json:"server_name,omitempty"`
+}
+
+// newTLSInfo returns the TLS parameters of the given connection state, or nil
+// if the connection doesn't use TLS.
+func newTLSInfo(cs *tls.ConnectionState) *tlsInfo {
+	if cs == nil {
+		return nil
+	}
+	return &tlsInfo{
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		ServerName:  cs.ServerName,
+	}
+}
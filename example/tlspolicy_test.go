@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestParseTLSPolicy(t *testing.T) {
+	p, err := parseTLSPolicy("1.3", "X25519, P256", "h2,http/1.1")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if p.minVersion != tls.VersionTLS13 || len(p.curves) != 2 || p.curves[0] != tls.X25519 {
+		t.Fatalf("Unexpected policy: %+v", p)
+	}
+	if !p.offersHTTP2() {
+		t.Fatal("Expected policy to offer HTTP/2.")
+	}
+
+	for _, args := range [][3]string{
+		{"1.1", "", "http/1.1"},
+		{"1.2", "P224", "http/1.1"},
+		{"1.2", "", ""},
+		{"1.2", "", "spdy/3"},
+	} {
+		if _, err := parseTLSPolicy(args[0], args[1], args[2]); err == nil {
+			t.Fatalf("Expected error for policy %q.", args)
+		}
+	}
+}
+
+func TestTLSPolicyApply(t *testing.T) {
+	p, err := parseTLSPolicy("1.2", "", "http/1.1")
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	srv := &http.Server{}
+	p.apply(srv, nil)
+	if srv.TLSConfig.MinVersion != tls.VersionTLS12 || srv.TLSConfig.CurvePreferences != nil {
+		t.Fatalf("Unexpected TLS configuration: %+v", srv.TLSConfig)
+	}
+	// Without h2, net/http must not enable HTTP/2.
+	if srv.TLSNextProto == nil {
+		t.Fatal("Expected HTTP/2 to be disabled.")
+	}
+}
+
+func TestNewTLSInfo(t *testing.T) {
+	if newTLSInfo(nil) != nil {
+		t.Fatal("Expected no TLS parameters for plaintext connection.")
+	}
+	info := newTLSInfo(&tls.ConnectionState{
+		Version:            tls.VersionTLS13,
+		CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+		NegotiatedProtocol: "http/1.1",
+		ServerName:         "example.com",
+	})
+	if info.Version != "TLS 1.3" || info.CipherSuite != "TLS_AES_128_GCM_SHA256" ||
+		info.ALPN != "http/1.1" || info.ServerName != "example.com" {
+		t.Fatalf("Unexpected TLS parameters: %+v", info)
+	}
+}
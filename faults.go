@@ -0,0 +1,124 @@
+package zerotrace
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Faults determines the faults that we inject into the responses to our trace
+// packets after capturing them, i.e., in our own code rather than in the
+// network.  This is meant for testing how our results, backoff, deadlines, and
+// the statistics of our callers hold up under adverse conditions; it has no
+// place in production.
+type Faults struct {
+	// Loss determines the probability that we drop a response.
+	Loss float64
+	// Delay determines the time by which we delay each response that we
+	// don't drop.  Its capture timestamp is shifted by as much, so delayed
+	// responses inflate RTTs.
+	Delay time.Duration
+	// Reorder determines the probability that we hold back a response until
+	// after the next one.  Its capture timestamp is shifted to the time of
+	// its release, i.e., that of the next response, or the time at which we
+	// give up waiting for one.
+	Reorder float64
+}
+
+// faultInjector injects the configured faults into a single traceroute's
+// responses.  A nil faultInjector injects no faults.
+type faultInjector struct {
+	faults *Faults
+	held   *respPkt // The response that we hold back, for reordering.
+	// delayed receives delayed responses once their delay is over.  If the
+	// traceroute is done by then, we drop them.
+	delayed chan *respPkt
+}
+
+// newFaultInjector returns a fault injector for the given faults, or nil if
+// the faults are nil.
+func newFaultInjector(f *Faults) *faultInjector {
+	if f == nil {
+		return nil
+	}
+	return &faultInjector{
+		faults:  f,
+		delayed: make(chan *respPkt, respChanSize),
+	}
+}
+
+// delayedPkts returns the channel of delayed responses, or nil if we inject
+// no faults, so selecting on it blocks forever.
+func (i *faultInjector) delayedPkts() chan *respPkt {
+	if i == nil {
+		return nil
+	}
+	return i.delayed
+}
+
+// inject returns the responses that are due once we captured the given
+// response.  Responses that we delay are returned by delayedPkts instead.
+func (i *faultInjector) inject(p *respPkt) []*respPkt {
+	if i == nil {
+		return []*respPkt{p}
+	}
+	if rand.Float64() < i.faults.Loss {
+		return nil
+	}
+	if i.faults.Delay <= 0 {
+		return i.reorder(p)
+	}
+	d := shifted(p, p.recvd.Add(i.faults.Delay), p.recvdMono.Add(i.faults.Delay))
+	time.AfterFunc(i.faults.Delay, func() {
+		select {
+		case i.delayed <- d:
+		default:
+		}
+	})
+	return nil
+}
+
+// shifted returns a copy of the given response whose capture timestamps are
+// shifted to the given (later) timestamps.  The capture manager may share the
+// response with other traceroutes, so we mustn't modify it.
+func shifted(p *respPkt, recvd, recvdMono time.Time) *respPkt {
+	s := *p
+	if recvd.After(s.recvd) {
+		s.recvd = recvd
+	}
+	if !s.recvdMono.IsZero() && recvdMono.After(s.recvdMono) {
+		s.recvdMono = recvdMono
+	}
+	return &s
+}
+
+// reorder returns the responses that are due once the given response is no
+// longer delayed: the given response, followed by the one that we held back,
+// if any, which is released at the given response's capture time.  The given
+// response may be held back in turn.
+func (i *faultInjector) reorder(p *respPkt) []*respPkt {
+	if i == nil {
+		return []*respPkt{p}
+	}
+	if i.held == nil && rand.Float64() < i.faults.Reorder {
+		i.held = p
+		return nil
+	}
+	pkts := []*respPkt{p}
+	if i.held != nil {
+		pkts = append(pkts, shifted(i.held, p.recvd, p.recvdMono))
+		i.held = nil
+	}
+	return pkts
+}
+
+// flush returns the response that we held back, if any, so it isn't lost if
+// no other response follows it.  It's released now.
+func (i *faultInjector) flush() []*respPkt {
+	if i == nil || i.held == nil {
+		return nil
+	}
+	now := time.Now()
+	p := shifted(i.held, now.UTC(), now)
+	i.held = nil
+	return []*respPkt{p}
+}
@@ -0,0 +1,89 @@
+package zerotrace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultInjectorNil(t *testing.T) {
+	var (
+		i = newFaultInjector(nil)
+		p = &respPkt{ipID: 1}
+	)
+	assertEqual(t, i == nil, true)
+	assertEqual(t, i.inject(p)[0], p)
+	assertEqual(t, i.reorder(p)[0], p)
+	assertEqual(t, len(i.flush()), 0)
+	if i.delayedPkts() != nil {
+		t.Fatal("Expected no delayed packets without faults.")
+	}
+}
+
+func TestFaultInjectorLoss(t *testing.T) {
+	i := newFaultInjector(&Faults{Loss: 1})
+	assertEqual(t, len(i.inject(&respPkt{ipID: 1})), 0)
+
+	// Lost responses leave the traceroute unanswered.
+	var (
+		s   = newTrState(dummyAddr)
+		now = time.Now().UTC()
+	)
+	s.addTracePkt(&tracePkt{ttl: 1, ipID: 1, sent: now})
+	for _, p := range i.inject(&respPkt{ipID: 1, recvdTTL: 1, recvd: now.Add(time.Millisecond)}) {
+		s.addRespPkt(p)
+	}
+	_, err := s.calcRTT()
+	assertEqual(t, err, ErrUnresponsive)
+}
+
+func TestFaultInjectorReorder(t *testing.T) {
+	var (
+		i   = newFaultInjector(&Faults{Reorder: 1})
+		now = time.Now()
+		p1  = &respPkt{ipID: 1, recvd: now.UTC(), recvdMono: now}
+		p2  = &respPkt{ipID: 2, recvd: now.UTC().Add(time.Millisecond), recvdMono: now.Add(time.Millisecond)}
+		p3  = &respPkt{ipID: 3, recvd: now.UTC(), recvdMono: now}
+	)
+	// The first response is held back until after the second, which can't
+	// be held back in turn.  It's released when the second was captured.
+	assertEqual(t, len(i.inject(p1)), 0)
+	pkts := i.inject(p2)
+	assertEqual(t, len(pkts), 2)
+	assertEqual(t, pkts[0], p2)
+	assertEqual(t, pkts[1].ipID, p1.ipID)
+	assertEqual(t, pkts[1].recvd, p2.recvd)
+	assertEqual(t, pkts[1].recvdMono, p2.recvdMono)
+	// The captured response is left alone.
+	assertEqual(t, p1.recvd, now.UTC())
+
+	// A held-back response without successor is flushed, and released at
+	// that time.
+	assertEqual(t, len(i.inject(p3)), 0)
+	time.Sleep(time.Millisecond)
+	pkts = i.flush()
+	assertEqual(t, pkts[0].ipID, p3.ipID)
+	if !pkts[0].recvdMono.After(p3.recvdMono) || !pkts[0].recvd.After(p3.recvd) {
+		t.Fatal("Expected flushed response to be released later than captured.")
+	}
+	assertEqual(t, len(i.flush()), 0)
+}
+
+func TestFaultInjectorDelay(t *testing.T) {
+	var (
+		delay = 10 * time.Millisecond
+		i     = newFaultInjector(&Faults{Delay: delay})
+		now   = time.Now()
+		p     = &respPkt{ipID: 1, recvd: now.UTC(), recvdMono: now}
+	)
+	assertEqual(t, len(i.inject(p)), 0)
+	select {
+	case d := <-i.delayedPkts():
+		assertEqual(t, d.ipID, p.ipID)
+		assertEqual(t, d.recvd, now.UTC().Add(delay))
+		assertEqual(t, d.recvdMono.Sub(now), delay)
+	case <-time.After(time.Second):
+		t.Fatal("Expected delayed response.")
+	}
+	// The captured response is left alone.
+	assertEqual(t, p.recvd, now.UTC())
+}
@@ -0,0 +1,110 @@
+package zerotrace
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// bpfNoFlows is the BPF filter that we use while no traceroute is running.  It
+// matches nothing because 0.0.0.0 is never the destination of a trace packet.
+const bpfNoFlows = "icmp and icmp[24:4] == 0"
+
+// bpfAllFlows is the BPF filter that we fall back to if we fail to install the
+// filter for our flows, e.g., because there are so many flows that the filter
+// exceeds the kernel's instruction limit.  It makes us capture and parse a lot
+// of unrelated packets, but the capture manager still only hands each
+// traceroute the packets of its own flow.
+const bpfAllFlows = "icmp or tcp"
+
+var (
+	errNotIPv4 = errors.New("not an IPv4 address")
+)
+
+// flow represents the TCP connection that a traceroute piggybacks on.
+type flow struct {
+	srcIP, dstIP     net.IP
+	srcPort, dstPort uint16
+}
+
+// extractFlow extracts the addresses and ports of the given net.Conn.
+func extractFlow(c net.Conn) (*flow, error) {
+	srcIP, srcPort, err := splitAddr(c.LocalAddr())
+	if err != nil {
+		return nil, err
+	}
+	dstIP, dstPort, err := splitAddr(c.RemoteAddr())
+	if err != nil {
+		return nil, err
+	}
+	return &flow{
+		srcIP:   srcIP,
+		dstIP:   dstIP,
+		srcPort: srcPort,
+		dstPort: dstPort,
+	}, nil
+}
+
+// splitAddr splits the given address into its IPv4 address and port.
+func splitAddr(addr net.Addr) (net.IP, uint16, error) {
+	host, strPort, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return nil, 0, errNotIPv4
+	}
+	port, err := strconv.ParseUint(strPort, 10, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, uint16(port), nil
+}
+
+// bpf returns a BPF expression that matches the ICMP errors that routers send
+// in response to the flow's trace packets.  ICMP errors quote the IP header
+// and the first eight bytes of the offending packet, so we match on the quoted
+// destination address (at offset 24) and the quoted TCP ports (at offsets 28
+// and 30).  The expression also matches the TCP segments that the client
+// sends us over the flow, whose TTL tells us how far away the client is, and
+// our own trace packets on their way out, whose capture timestamp is more
+// accurate than the time at which we sent them.  Unlike the connection's
+// genuine segments, our trace packets have zero sequence and ack numbers.
+func (f *flow) bpf() string {
+	return fmt.Sprintf("(icmp and icmp[24:4] == 0x%08x and icmp[28:2] == %d and icmp[30:2] == %d) or "+
+		"(tcp and src host %s and src port %d and dst port %d) or "+
+		"(tcp and dst host %s and src port %d and dst port %d and tcp[4:4] == 0 and tcp[8:4] == 0)",
+		binary.BigEndian.Uint32(f.dstIP.To4()), f.srcPort, f.dstPort,
+		f.dstIP, f.dstPort, f.srcPort,
+		f.dstIP, f.srcPort, f.dstPort)
+}
+
+// bpfFilter returns a BPF filter that matches the packets of interest for all
+// of the given flows.
+func bpfFilter(flows []*flow) string {
+	if len(flows) == 0 {
+		return bpfNoFlows
+	}
+	exprs := make([]string, len(flows))
+	for i, f := range flows {
+		exprs[i] = "(" + f.bpf() + ")"
+	}
+	return strings.Join(exprs, " or ")
+}
+
+// isFromClient returns true if the given packet is one of the TCP segments
+// that the flow's client sent us.
+func (f *flow) isFromClient(p *respPkt) bool {
+	return p.fromClient && p.recvdFrom.Equal(f.dstIP) && p.recvdPort == f.dstPort
+}
+
+// isOutgoing returns true if the given packet is one of the flow's trace
+// packets, as we captured it on its way out.
+func (f *flow) isOutgoing(p *respPkt) bool {
+	return p.outgoing && p.sentTo.Equal(f.dstIP) &&
+		p.sentToPort == f.dstPort && p.recvdPort == f.srcPort
+}
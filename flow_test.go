@@ -0,0 +1,84 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+)
+
+func TestExtractFlow(t *testing.T) {
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+
+	assertEqual(t, f.srcIP.String(), srcAddr)
+	assertEqual(t, f.dstIP.String(), dstAddr)
+	assertEqual(t, f.srcPort, uint16(srcPort))
+	assertEqual(t, f.dstPort, uint16(dstPort))
+}
+
+func TestSplitAddr(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	if _, _, err := splitAddr(addr); err != errNotIPv4 {
+		t.Fatalf("Expected error %v but got %v.", errNotIPv4, err)
+	}
+}
+
+func TestBPFFilter(t *testing.T) {
+	assertEqual(t, bpfFilter(nil), bpfNoFlows)
+
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+	expected := "((icmp and icmp[24:4] == 0x0a000002 and icmp[28:2] == 12345 and icmp[30:2] == 8080) or " +
+		"(tcp and src host 10.0.0.2 and src port 8080 and dst port 12345) or " +
+		"(tcp and dst host 10.0.0.2 and src port 12345 and dst port 8080 and tcp[4:4] == 0 and tcp[8:4] == 0))"
+	assertEqual(t, bpfFilter([]*flow{f}), expected)
+
+	expected = "(" + f.bpf() + ") or (" + f.bpf() + ")"
+	assertEqual(t, bpfFilter([]*flow{f, f}), expected)
+}
+
+func TestIsFromClient(t *testing.T) {
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+
+	p := &respPkt{fromClient: true, recvdFrom: f.dstIP, recvdPort: f.dstPort}
+	assertEqual(t, f.isFromClient(p), true)
+
+	p.recvdPort++
+	assertEqual(t, f.isFromClient(p), false)
+
+	// ICMP responses aren't the client's.
+	p = &respPkt{recvdFrom: f.dstIP}
+	assertEqual(t, f.isFromClient(p), false)
+}
+
+func TestIsOutgoing(t *testing.T) {
+	f, err := extractFlow(&mockConn{})
+	failOnErr(t, err)
+
+	p := &respPkt{outgoing: true, recvdPort: f.srcPort, sentTo: f.dstIP, sentToPort: f.dstPort}
+	assertEqual(t, f.isOutgoing(p), true)
+
+	// Another client's connection to the same listening port isn't ours.
+	p.sentToPort++
+	assertEqual(t, f.isOutgoing(p), false)
+
+	// The client's segments aren't our trace packets.
+	p = &respPkt{fromClient: true, recvdFrom: f.dstIP, recvdPort: f.dstPort}
+	assertEqual(t, f.isOutgoing(p), false)
+}
+
+func BenchmarkBPFFilter(b *testing.B) {
+	f, err := extractFlow(&mockConn{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	flows := []*flow{}
+	for i := 0; i < 100; i++ {
+		flows = append(flows, f)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = bpfFilter(flows)
+	}
+}
@@ -0,0 +1,171 @@
+package zerotrace
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/google/gopacket/layers"
+)
+
+// The following implements parsing of ICMP multi-part messages (RFC 4884) and
+// the Interface Information Objects (RFC 5837) that routers may append to
+// their ICMP time exceeded messages.
+
+const (
+	icmpExtVersion      = 2
+	icmpExtMinOrigLen   = 128 // RFC 4884 pads the original datagram to 128 bytes.
+	icmpExtClassIfInfo  = 2
+	ifInfoFlagIfIndex   = 1 << 3
+	ifInfoFlagIPAddr    = 1 << 2
+	ifInfoFlagName      = 1 << 1
+	ifInfoFlagMTU       = 1 << 0
+	ifInfoAfiIPv4       = 1
+	ifInfoAfiIPv6       = 2
+	ifInfoRoleShift     = 6
+	icmpExtObjHeaderLen = 4
+)
+
+// Interface roles of RFC 5837.
+const (
+	IfRoleIncoming = 0 // The interface on which the trace packet arrived.
+	IfRoleSubIP    = 1 // A sub-IP component of the incoming interface.
+	IfRoleOutgoing = 2 // The interface over which the trace packet would leave.
+	IfRoleNextHop  = 3 // The next hop's interface.
+)
+
+// InterfaceInfo holds an Interface Information Object (RFC 5837), which
+// describes one of the interfaces of the router that answered a trace packet.
+// Fields that the router didn't include are zero.
+type InterfaceInfo struct {
+	// Role is the interface's role, e.g., IfRoleIncoming.
+	Role uint8
+	// IfIndex is the interface's ifIndex.
+	IfIndex uint32
+	// Addr is the interface's IP address.
+	Addr net.IP
+	// Name is the interface's name, e.g., "ge-0/0/1".
+	Name string
+	// MTU is the interface's MTU.
+	MTU uint32
+}
+
+// hasIcmpExtensions returns true if the given ICMP message type may carry
+// RFC 4884 extensions.
+func hasIcmpExtensions(icmpType uint8) bool {
+	switch icmpType {
+	case layers.ICMPv4TypeDestinationUnreachable,
+		layers.ICMPv4TypeTimeExceeded,
+		layers.ICMPv4TypeParameterProblem:
+		return true
+	}
+	return false
+}
+
+// parseIfInfos returns the Interface Information Objects that are contained
+// in the given ICMP payload.  The given length is the length of the original
+// datagram in 32-bit words, as found in the ICMP header.  Malformed extensions
+// are ignored.
+func parseIfInfos(payload []byte, origLen uint8) []*InterfaceInfo {
+	// Routers that don't implement RFC 4884 don't set the length.
+	if origLen == 0 {
+		return nil
+	}
+	offset := int(origLen) * 4
+	if offset < icmpExtMinOrigLen || len(payload) < offset+4 {
+		return nil
+	}
+	ext := payload[offset:]
+	if ext[0]>>4 != icmpExtVersion {
+		return nil
+	}
+	// The checksum is optional for compatibility with early implementations.
+	if binary.BigEndian.Uint16(ext[2:4]) != 0 && onesComplement(ext) != 0 {
+		return nil
+	}
+
+	var ifInfos []*InterfaceInfo
+	for objs := ext[4:]; len(objs) >= icmpExtObjHeaderLen; {
+		objLen := int(binary.BigEndian.Uint16(objs[0:2]))
+		if objLen < icmpExtObjHeaderLen || objLen > len(objs) {
+			break
+		}
+		class, cType := objs[2], objs[3]
+		if class == icmpExtClassIfInfo {
+			if ifInfo := parseIfInfo(cType, objs[icmpExtObjHeaderLen:objLen]); ifInfo != nil {
+				ifInfos = append(ifInfos, ifInfo)
+			}
+		}
+		objs = objs[objLen:]
+	}
+	return ifInfos
+}
+
+// parseIfInfo parses the given Interface Information Object payload, whose
+// C-Type determines the interface's role and which fields are present.  The
+// function returns nil if the payload is malformed.
+func parseIfInfo(cType uint8, data []byte) *InterfaceInfo {
+	ifInfo := &InterfaceInfo{Role: cType >> ifInfoRoleShift}
+
+	if cType&ifInfoFlagIfIndex != 0 {
+		if len(data) < 4 {
+			return nil
+		}
+		ifInfo.IfIndex = binary.BigEndian.Uint32(data)
+		data = data[4:]
+	}
+	if cType&ifInfoFlagIPAddr != 0 {
+		if len(data) < 4 {
+			return nil
+		}
+		addrLen := 0
+		switch binary.BigEndian.Uint16(data) {
+		case ifInfoAfiIPv4:
+			addrLen = net.IPv4len
+		case ifInfoAfiIPv6:
+			addrLen = net.IPv6len
+		default:
+			return nil
+		}
+		if len(data) < 4+addrLen {
+			return nil
+		}
+		ifInfo.Addr = append(net.IP(nil), data[4:4+addrLen]...)
+		data = data[4+addrLen:]
+	}
+	if cType&ifInfoFlagName != 0 {
+		// The name's length includes the length octet itself.
+		if len(data) < 1 || int(data[0]) < 1 || int(data[0]) > len(data) {
+			return nil
+		}
+		name := data[1:data[0]]
+		// The name is padded with zeros to a multiple of four octets.
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		ifInfo.Name = string(name)
+		data = data[data[0]:]
+	}
+	if cType&ifInfoFlagMTU != 0 {
+		if len(data) < 4 {
+			return nil
+		}
+		ifInfo.MTU = binary.BigEndian.Uint32(data)
+	}
+	return ifInfo
+}
+
+// onesComplement returns the Internet checksum (RFC 1071) of the given data,
+// which is zero if the data contains a valid checksum.
+func onesComplement(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,132 @@
+package zerotrace
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// newIfInfoPayload returns an ICMP payload that quotes the given original
+// datagram, padded to 128 bytes, followed by an RFC 4884 extension structure
+// that contains the given Interface Information Object.
+func newIfInfoPayload(orig []byte, cType uint8, obj []byte) []byte {
+	payload := make([]byte, icmpExtMinOrigLen)
+	copy(payload, orig)
+
+	ext := []byte{icmpExtVersion << 4, 0, 0, 0}
+	hdr := make([]byte, icmpExtObjHeaderLen)
+	binary.BigEndian.PutUint16(hdr, uint16(icmpExtObjHeaderLen+len(obj)))
+	hdr[2], hdr[3] = icmpExtClassIfInfo, cType
+	ext = append(append(ext, hdr...), obj...)
+	binary.BigEndian.PutUint16(ext[2:], onesComplement(ext))
+
+	return append(payload, ext...)
+}
+
+// newIfInfoObj returns an Interface Information Object payload that contains
+// all optional fields.
+func newIfInfoObj() (uint8, []byte) {
+	cType := uint8(IfRoleIncoming<<ifInfoRoleShift |
+		ifInfoFlagIfIndex | ifInfoFlagIPAddr | ifInfoFlagName | ifInfoFlagMTU)
+	obj := []byte{0, 0, 0, 42}                              // ifIndex
+	obj = append(obj, 0, ifInfoAfiIPv4, 0, 0, 192, 0, 2, 1) // IP address
+	obj = append(obj, 8, 'g', 'e', '-', '0', '/', '1', 0)   // Name
+	obj = append(obj, 0, 0, 0x05, 0xdc)                     // MTU
+	return cType, obj
+}
+
+func TestParseIfInfos(t *testing.T) {
+	cType, obj := newIfInfoObj()
+	payload := newIfInfoPayload(nil, cType, obj)
+
+	ifInfos := parseIfInfos(payload, icmpExtMinOrigLen/4)
+	assertEqual(t, len(ifInfos), 1)
+	ifInfo := ifInfos[0]
+	assertEqual(t, ifInfo.Role, uint8(IfRoleIncoming))
+	assertEqual(t, ifInfo.IfIndex, uint32(42))
+	assertEqual(t, ifInfo.Name, "ge-0/1")
+	assertEqual(t, ifInfo.MTU, uint32(1500))
+	if !ifInfo.Addr.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("Expected interface address 192.0.2.1 but got %s.", ifInfo.Addr)
+	}
+
+	// Without RFC 4884's length, we don't look for extensions.
+	assertEqual(t, len(parseIfInfos(payload, 0)), 0)
+
+	// A bad checksum invalidates the extensions.
+	payload[len(payload)-1]++
+	assertEqual(t, len(parseIfInfos(payload, icmpExtMinOrigLen/4)), 0)
+}
+
+func TestParseIfInfoPartial(t *testing.T) {
+	// Only the interface's name, as the outgoing interface.
+	ifInfo := parseIfInfo(IfRoleOutgoing<<ifInfoRoleShift|ifInfoFlagName,
+		[]byte{4, 'x', 'e', '1'})
+	assertEqual(t, ifInfo.Role, uint8(IfRoleOutgoing))
+	assertEqual(t, ifInfo.Name, "xe1")
+	assertEqual(t, ifInfo.IfIndex, uint32(0))
+
+	// Truncated objects are malformed.
+	if parseIfInfo(ifInfoFlagIfIndex|ifInfoFlagMTU, []byte{0, 0, 0, 1}) != nil {
+		t.Fatal("Expected truncated object to be rejected.")
+	}
+	if parseIfInfo(ifInfoFlagName, []byte{9, 'x'}) != nil {
+		t.Fatal("Expected name that exceeds the object to be rejected.")
+	}
+}
+
+func TestDecodeIfInfos(t *testing.T) {
+	d := newIcmpDecoder(layers.LayerTypeIPv4)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	failOnErr(t, gopacket.SerializeLayers(buf, opts,
+		&layers.IPv4{
+			Version: 4,
+			IHL:     5,
+			TTL:     1,
+			Id:      1234,
+			SrcIP:   net.ParseIP(srcAddr),
+			DstIP:   net.ParseIP(dstAddr),
+		},
+	))
+	cType, obj := newIfInfoObj()
+	payload := newIfInfoPayload(buf.Bytes(), cType, obj)
+
+	buf = gopacket.NewSerializeBuffer()
+	failOnErr(t, gopacket.SerializeLayers(buf, opts,
+		&layers.IPv4{
+			Version:  4,
+			IHL:      5,
+			TTL:      64,
+			Protocol: layers.IPProtocolICMPv4,
+			SrcIP:    dummyAddr,
+			DstIP:    net.ParseIP(srcAddr),
+		},
+		&layers.ICMPv4{
+			TypeCode: layers.CreateICMPv4TypeCode(
+				layers.ICMPv4TypeTimeExceeded,
+				layers.ICMPv4CodeTTLExceeded,
+			),
+			Id: icmpExtMinOrigLen / 4,
+		},
+		gopacket.Payload(payload),
+	))
+
+	now := time.Now().UTC()
+	p, err := d.decode(buf.Bytes(), gopacket.CaptureInfo{Timestamp: now})
+	failOnErr(t, err)
+	assertEqual(t, p.ipID, uint16(1234))
+	assertEqual(t, len(p.ifInfos), 1)
+	assertEqual(t, p.ifInfos[0].Name, "ge-0/1")
+
+	// Hops carry the interface information of their router.
+	p.sent, p.ttl = now.Add(-time.Millisecond), 5
+	h := newHop(5, []*tracePkt{(*tracePkt)(p)})
+	assertEqual(t, len(h.Interfaces), 1)
+	assertEqual(t, h.Interfaces[0].IfIndex, uint32(42))
+}
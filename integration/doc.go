@@ -0,0 +1,11 @@
+// Package integration holds end-to-end tests that run the real 0trace code
+// against a client in a network namespace, behind a router in another
+// namespace, with configurable delay and loss (via tc netem) on the router.
+// The tests need root privileges, iproute2, and tc, so they only build with
+// the integration build tag:
+//
+//	sudo go test -tags integration ./integration
+//
+// The environment variables ZEROTRACE_NETEM_DELAY (default: 20ms) and
+// ZEROTRACE_NETEM_LOSS (default: 0%) determine the router's netem settings.
+package integration
@@ -0,0 +1,178 @@
+//go:build integration && linux
+
+package integration
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brave/zerotrace"
+)
+
+// Our topology: the server (in our own namespace) reaches the client via a
+// router, and netem delays the router's packets toward the server.
+//
+//	server zt-srv 10.201.0.1 <-> 10.201.0.2 zt-rtr0 [router] zt-rtr1 10.201.1.1 <-> 10.201.1.2 zt-cli [client]
+const (
+	routerNS   = "zt-router"
+	clientNS   = "zt-client"
+	serverAddr = "10.201.0.1"
+	routerAddr = "10.201.0.2"
+	clientAddr = "10.201.1.2"
+	serverIf   = "zt-srv"
+	helperEnv  = "ZEROTRACE_HELPER_ADDR"
+)
+
+// run runs the given command and fails the test if it fails.
+func run(t *testing.T, args ...string) {
+	t.Helper()
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// getenv returns the given environment variable, or the given fallback if it
+// isn't set.
+func getenv(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// setupTopology creates our namespaces and veth pairs, and returns netem's
+// delay.  The namespaces are deleted once the test finished, which deletes
+// the veth pairs along with them.
+func setupTopology(t *testing.T) time.Duration {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("Setting up network namespaces requires root privileges.")
+	}
+	delay, err := time.ParseDuration(getenv("ZEROTRACE_NETEM_DELAY", "20ms"))
+	if err != nil {
+		t.Fatalf("Invalid netem delay: %v", err)
+	}
+	loss := getenv("ZEROTRACE_NETEM_LOSS", "0%")
+
+	run(t, "ip", "netns", "add", routerNS)
+	t.Cleanup(func() { _ = exec.Command("ip", "netns", "del", routerNS).Run() })
+	run(t, "ip", "netns", "add", clientNS)
+	t.Cleanup(func() { _ = exec.Command("ip", "netns", "del", clientNS).Run() })
+
+	inRouter := []string{"ip", "netns", "exec", routerNS}
+	inClient := []string{"ip", "netns", "exec", clientNS}
+	for _, cmd := range [][]string{
+		{"ip", "link", "add", serverIf, "type", "veth", "peer", "name", "zt-rtr0", "netns", routerNS},
+		{"ip", "addr", "add", serverAddr + "/24", "dev", serverIf},
+		{"ip", "link", "set", serverIf, "up"},
+		{"ip", "route", "add", "10.201.1.0/24", "via", routerAddr},
+		append(inRouter, "ip", "link", "add", "zt-rtr1", "type", "veth", "peer", "name", "zt-cli", "netns", clientNS),
+		append(inRouter, "ip", "addr", "add", routerAddr+"/24", "dev", "zt-rtr0"),
+		append(inRouter, "ip", "addr", "add", "10.201.1.1/24", "dev", "zt-rtr1"),
+		append(inRouter, "ip", "link", "set", "zt-rtr0", "up"),
+		append(inRouter, "ip", "link", "set", "zt-rtr1", "up"),
+		append(inRouter, "sysctl", "-qw", "net.ipv4.ip_forward=1"),
+		append(inRouter, "tc", "qdisc", "add", "dev", "zt-rtr0", "root", "netem",
+			"delay", delay.String(), "loss", loss),
+		append(inClient, "ip", "addr", "add", clientAddr+"/24", "dev", "zt-cli"),
+		append(inClient, "ip", "link", "set", "zt-cli", "up"),
+		append(inClient, "ip", "link", "set", "lo", "up"),
+		append(inClient, "ip", "route", "add", "default", "via", "10.201.1.1"),
+	} {
+		run(t, cmd...)
+	}
+	return delay
+}
+
+// TestHelperClient isn't a test but the client that we run in the client's
+// namespace: it connects to the server and holds the connection open until
+// the server closes it.
+func TestHelperClient(t *testing.T) {
+	addr := os.Getenv(helperEnv)
+	if addr == "" {
+		t.Skip("Only runs as helper process.")
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+// startClient runs our helper client in the client's namespace and returns
+// the server side of its connection.
+func startClient(t *testing.T, ln net.Listener) net.Conn {
+	t.Helper()
+	cmd := exec.Command("ip", "netns", "exec", clientNS, os.Args[0], "-test.run=^TestHelperClient$")
+	cmd.Env = append(os.Environ(), helperEnv+"="+ln.Addr().String())
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestTraceThroughRouter(t *testing.T) {
+	delay := setupTopology(t)
+
+	cfg := zerotrace.NewDefaultConfig()
+	cfg.Interface = serverIf
+	cfg.TTLStart = 1
+	cfg.TTLEnd = 3
+	cfg.NumTraces = 1
+	z := zerotrace.NewZeroTrace(cfg)
+	if err := z.Start(); err != nil {
+		t.Fatalf("Failed to start ZeroTrace: %v", err)
+	}
+	defer z.Close()
+
+	ln, err := net.Listen("tcp", serverAddr+":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	conn := startClient(t, ln)
+
+	res, err := z.Trace(conn)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if !res.Dst.Equal(net.ParseIP(clientAddr)) {
+		t.Fatalf("Expected destination %s but got %s.", clientAddr, res.Dst)
+	}
+	if len(res.Hops) == 0 || !res.Hops[0].Addr.Equal(net.ParseIP(routerAddr)) {
+		t.Fatalf("Expected first hop to be the router but got hops %+v.", res.Hops)
+	}
+	// The router's responses are delayed by netem, so our RTT must be at
+	// least netem's delay, but not by much more on an idle machine.
+	if res.RTT < delay || res.RTT > delay+time.Second {
+		t.Fatalf("Expected RTT of about %s but got %s.", delay, res.RTT)
+	}
+	if res.ClientTTL == 0 {
+		t.Fatal("Expected to capture the client's TCP segments.")
+	}
+	if res.Tunneled {
+		t.Fatalf("Expected no tunnel but got client hops %d and traced hops %d.",
+			res.ClientHops, res.TracedHops)
+	}
+	if err := z.Healthy(); err != nil {
+		t.Fatalf("Expected healthy capture but got: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package zerotrace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+)
+
+// IXPPrefix is the peering LAN prefix of an Internet exchange point.
+type IXPPrefix struct {
+	// Name is the name of the IXP, or empty if we don't know it.
+	Name string
+	// Net is the IXP's peering LAN.
+	Net *net.IPNet
+}
+
+// IXPList holds the peering LANs of Internet exchange points.  Routers that
+// respond from a peering LAN address are at an IXP, which is typically where
+// one network hands off traffic to another, e.g., a VPN provider to a client's
+// ISP.  An IXPList is immutable, so it's safe for concurrent use.  A nil or
+// zero-value IXPList contains nothing.
+type IXPList struct {
+	prefixes []*IXPPrefix
+}
+
+// NewIXPList returns a new IXP list that contains the given prefixes.
+func NewIXPList(prefixes []*IXPPrefix) *IXPList {
+	return &IXPList{prefixes: prefixes}
+}
+
+// ParsePeeringDB parses the IXP prefixes of the given PeeringDB dump, i.e., a
+// JSON object that maps the "ix", "ixlan", and "ixpfx" object types to their
+// API responses, e.g., {"ixpfx": {"data": [...]}}.  That's the format of the
+// public PeeringDB snapshots.  If the dump lacks the "ix" or "ixlan" objects,
+// the prefixes' names are empty.
+func ParsePeeringDB(r io.Reader) ([]*IXPPrefix, error) {
+	var dump struct {
+		IX struct {
+			Data []struct {
+				ID   int    `json:"id"`
+				Name string `json:"name"`
+			} `json:"data"`
+		} `json:"ix"`
+		IXLan struct {
+			Data []struct {
+				ID   int `json:"id"`
+				IXID int `json:"ix_id"`
+			} `json:"data"`
+		} `json:"ixlan"`
+		IXPfx struct {
+			Data []struct {
+				IXLanID int    `json:"ixlan_id"`
+				Prefix  string `json:"prefix"`
+			} `json:"data"`
+		} `json:"ixpfx"`
+	}
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return nil, err
+	}
+
+	var (
+		names    = make(map[int]string)
+		lanNames = make(map[int]string)
+		prefixes []*IXPPrefix
+	)
+	for _, ix := range dump.IX.Data {
+		names[ix.ID] = ix.Name
+	}
+	for _, lan := range dump.IXLan.Data {
+		lanNames[lan.ID] = names[lan.IXID]
+	}
+	for _, pfx := range dump.IXPfx.Data {
+		_, n, err := net.ParseCIDR(pfx.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("ixpfx: %w", err)
+		}
+		prefixes = append(prefixes, &IXPPrefix{Name: lanNames[pfx.IXLanID], Net: n})
+	}
+	return prefixes, nil
+}
+
+// Len returns the number of prefixes on the list.
+func (l *IXPList) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.prefixes)
+}
+
+// Lookup returns the IXP prefix that contains the given address, or nil if the
+// address isn't in any IXP's peering LAN.
+func (l *IXPList) Lookup(ip net.IP) *IXPPrefix {
+	if l == nil || ip == nil {
+		return nil
+	}
+	for _, p := range l.prefixes {
+		if p.Net.Contains(ip) {
+			return p
+		}
+	}
+	return nil
+}
+
+// tagIXPs flags the result's hops whose address is in the peering LAN of one
+// of the given IXPs.
+func (r *Result) tagIXPs(l *IXPList) {
+	for _, h := range r.Hops {
+		if p := l.Lookup(h.Addr); p != nil {
+			h.IXP = true
+			h.IXPName = p.Name
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package zerotrace
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const peeringDBDump = `{
+	"ix": {"data": [{"id": 1, "name": "Example-IX"}]},
+	"ixlan": {"data": [{"id": 10, "ix_id": 1}]},
+	"ixpfx": {"data": [
+		{"id": 100, "ixlan_id": 10, "protocol": "IPv4", "prefix": "192.0.2.0/24"},
+		{"id": 101, "ixlan_id": 10, "protocol": "IPv6", "prefix": "2001:db8::/64"},
+		{"id": 102, "ixlan_id": 11, "protocol": "IPv4", "prefix": "198.51.100.0/24"}
+	]}
+}`
+
+func TestParsePeeringDB(t *testing.T) {
+	for _, test := range []struct {
+		dump     string
+		prefixes []string // The prefixes' networks and names.
+		valid    bool
+	}{
+		{
+			peeringDBDump,
+			// Prefixes of unknown peering LANs have no name.
+			[]string{"192.0.2.0/24 Example-IX", "2001:db8::/64 Example-IX", "198.51.100.0/24 "},
+			true,
+		},
+		// Without "ix" and "ixlan" objects, we know no names.
+		{`{"ixpfx": {"data": [{"ixlan_id": 1, "prefix": "192.0.2.0/24"}]}}`, []string{"192.0.2.0/24 "}, true},
+		{`{}`, nil, true},
+		{`{"ixpfx": {"data": [{"prefix": "foo"}]}}`, nil, false},
+		{"not JSON", nil, false},
+	} {
+		prefixes, err := ParsePeeringDB(strings.NewReader(test.dump))
+		assertEqual(t, err == nil, test.valid)
+		var got []string
+		for _, p := range prefixes {
+			got = append(got, p.Net.String()+" "+p.Name)
+		}
+		if !reflect.DeepEqual(got, test.prefixes) {
+			t.Fatalf("Expected prefixes %q but got %q.", test.prefixes, got)
+		}
+	}
+}
+
+func TestIXPListLookup(t *testing.T) {
+	prefixes, err := ParsePeeringDB(strings.NewReader(peeringDBDump))
+	failOnErr(t, err)
+	l := NewIXPList(prefixes)
+	assertEqual(t, l.Len(), 3)
+
+	assertEqual(t, l.Lookup(net.ParseIP("192.0.2.7")).Name, "Example-IX")
+	assertEqual(t, l.Lookup(net.ParseIP("203.0.113.1")), (*IXPPrefix)(nil))
+	assertEqual(t, l.Lookup(nil), (*IXPPrefix)(nil))
+
+	// A nil list contains nothing.
+	var nilList *IXPList
+	assertEqual(t, nilList.Lookup(net.ParseIP("192.0.2.7")), (*IXPPrefix)(nil))
+	assertEqual(t, nilList.Len(), 0)
+	// Neither does a zero-value list.
+	assertEqual(t, (&IXPList{}).Lookup(net.ParseIP("192.0.2.7")), (*IXPPrefix)(nil))
+	assertEqual(t, (&IXPList{}).Len(), 0)
+}
+
+func TestTagIXPs(t *testing.T) {
+	prefixes, err := ParsePeeringDB(strings.NewReader(peeringDBDump))
+	failOnErr(t, err)
+	r := &Result{Hops: []*Hop{
+		{TTL: 1, Addr: net.ParseIP("10.0.0.1")},
+		{TTL: 2, Addr: net.ParseIP("192.0.2.7")},
+		{TTL: 3},
+	}}
+	r.tagIXPs(NewIXPList(prefixes))
+	assertEqual(t, r.Hops[0].IXP, false)
+	assertEqual(t, r.Hops[1].IXP, true)
+	assertEqual(t, r.Hops[1].IXPName, "Example-IX")
+	assertEqual(t, r.Hops[2].IXP, false)
+}
@@ -0,0 +1,46 @@
+package zerotrace
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrBusy is returned by Trace if the configured maximum number of
+	// concurrent calls to Trace are running, and no call finished within the
+	// configured queue timeout.
+	ErrBusy = errors.New("too many concurrent traceroutes")
+)
+
+// acquireSlot reserves one of the slots for concurrent calls to Trace.  If all
+// slots are taken, we wait for the configured queue timeout before giving up
+// with ErrBusy.  If there's no limit, acquireSlot always succeeds.
+func (z *ZeroTrace) acquireSlot() error {
+	if z.slots == nil {
+		return nil
+	}
+	select {
+	case z.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if z.cfg.TraceQueueTimeout <= 0 {
+		return ErrBusy
+	}
+	timer := time.NewTimer(z.cfg.TraceQueueTimeout)
+	defer timer.Stop()
+	select {
+	case z.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrBusy
+	}
+}
+
+// releaseSlot undoes acquireSlot.
+func (z *ZeroTrace) releaseSlot() {
+	if z.slots == nil {
+		return
+	}
+	<-z.slots
+}
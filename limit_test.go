@@ -0,0 +1,42 @@
+package zerotrace
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlots(t *testing.T) {
+	c := NewDefaultConfig()
+	c.MaxTraces = 1
+	c.TraceQueueTimeout = 0
+	z := NewZeroTrace(c)
+
+	failOnErr(t, z.acquireSlot())
+	// Without a queue, the second traceroute is refused right away.
+	assertEqual(t, z.acquireSlot(), ErrBusy)
+
+	// With a queue, the second traceroute waits for the first to finish.
+	c.TraceQueueTimeout = time.Minute
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		z.releaseSlot()
+	}()
+	failOnErr(t, z.acquireSlot())
+
+	// ...but not forever.
+	c.TraceQueueTimeout = time.Millisecond
+	assertEqual(t, z.acquireSlot(), ErrBusy)
+	z.releaseSlot()
+}
+
+func TestNoSlots(t *testing.T) {
+	c := NewDefaultConfig()
+	c.MaxTraces = 0
+	z := NewZeroTrace(c)
+
+	// Without a limit, there's always room.
+	for i := 0; i < 10; i++ {
+		failOnErr(t, z.acquireSlot())
+	}
+	z.releaseSlot()
+}
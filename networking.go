@@ -18,9 +18,9 @@ const (
 // createPkt creates and returns a trace packet for the given net.Conn object.
 // Importantly, the function only returns the TCP header and the application
 // payload.  The function assembles a TCP segment that resembles the given
-// net.Conn and has a small dummy payload.  The returned byte slice is ready to
-// be written to the wire when combined with an IP header.
-func createPkt(conn net.Conn) ([]byte, error) {
+// net.Conn and has a dummy payload of the given size.  The returned byte slice
+// is ready to be written to the wire when combined with an IP header.
+func createPkt(conn net.Conn, payloadSize int) ([]byte, error) {
 	// Extract hosts and ports from our net.Conn object.
 	srcIP, strSrcPort, err := net.SplitHostPort(conn.LocalAddr().String())
 	if err != nil {
@@ -41,13 +41,29 @@ func createPkt(conn net.Conn) ([]byte, error) {
 		return nil, err
 	}
 
+	return createSegment(
+		net.ParseIP(srcIP),
+		net.ParseIP(dstIP),
+		uint16(srcPort),
+		uint16(dstPort),
+		payloadSize,
+	)
+}
+
+// createSegment is like createPkt but takes the segment's addresses and ports
+// instead of a net.Conn object.
+func createSegment(
+	srcIP, dstIP net.IP,
+	srcPort, dstPort uint16,
+	payloadSize int,
+) ([]byte, error) {
 	// Compose the pseudo header that's necessary for computing the TCP header
 	// checksum.
 	ipLayer := &layers.IPv4{
 		Protocol: layers.IPProtocolTCP,
-		SrcIP:    net.ParseIP(srcIP),
-		DstIP:    net.ParseIP(dstIP),
-		Length:   uint16(20 + 20 + len(tcpPayload)),
+		SrcIP:    srcIP,
+		DstIP:    dstIP,
+		Length:   uint16(20 + 20 + payloadSize),
 	}
 	tcpLayer := &layers.TCP{
 		SrcPort: layers.TCPPort(srcPort),
@@ -62,6 +78,10 @@ func createPkt(conn net.Conn) ([]byte, error) {
 		return nil, err
 	}
 
+	// Pad (or truncate) our dummy payload to the requested size.
+	payload := make([]byte, payloadSize)
+	copy(payload, tcpPayload)
+
 	// Serialize our packet.
 	buf := gopacket.NewSerializeBuffer()
 	options := gopacket.SerializeOptions{
@@ -72,7 +92,7 @@ func createPkt(conn net.Conn) ([]byte, error) {
 		buf,
 		options,
 		tcpLayer,
-		gopacket.Payload([]byte(tcpPayload)),
+		gopacket.Payload(payload),
 	); err != nil {
 		return nil, err
 	}
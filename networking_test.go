@@ -40,7 +40,7 @@ func (m *mockConn) LocalAddr() net.Addr {
 
 func TestCreatePkt(t *testing.T) {
 	conn := &mockConn{}
-	rawPkt, err := createPkt(conn)
+	rawPkt, err := createPkt(conn, len(tcpPayload))
 	if err != nil {
 		t.Fatalf("Failed to create packet for given conn: %v", err)
 	}
@@ -78,3 +78,27 @@ func TestCreatePkt(t *testing.T) {
 		t.Fatal("Expected TCP flags PSH and ACK to be set.")
 	}
 }
+
+func TestCreatePktPayloadSize(t *testing.T) {
+	conn := &mockConn{}
+	for _, size := range []int{0, 4, 100, 1000} {
+		rawPkt, err := createPkt(conn, size)
+		if err != nil {
+			t.Fatalf("Failed to create packet for given conn: %v", err)
+		}
+		pkt := gopacket.NewPacket(rawPkt, layers.LayerTypeTCP, gopacket.Default)
+		tcpLayer := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if len(tcpLayer.Payload) != size {
+			t.Fatalf("Expected payload size %d but got %d.", size, len(tcpLayer.Payload))
+		}
+	}
+}
+
+func BenchmarkCreatePkt(b *testing.B) {
+	conn := &mockConn{}
+	for i := 0; i < b.N; i++ {
+		if _, err := createPkt(conn, len(tcpPayload)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
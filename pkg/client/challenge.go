@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"strconv"
+)
+
+// Challenge is a proof-of-work challenge that a server may require clients to
+// solve before it starts a measurement, which makes it costly to abuse the
+// server as a probing reflector.  A solution is a nonce such that the SHA-256
+// hash of "<challenge>:<nonce>" starts with at least Bits zero bits.
+type Challenge struct {
+	// Challenge is the server's opaque challenge.
+	Challenge string `json:"challenge"`
+	// Bits is the number of leading zero bits that a solution's hash must
+	// have.  Each bit doubles the expected work.
+	Bits int `json:"bits"`
+}
+
+// FetchChallenge fetches a new challenge from the given URL, e.g.
+// "https://example.com:8443/challenge".
+func FetchChallenge(ctx context.Context, url string) (*Challenge, error) {
+	c := &Challenge{}
+	if err := getJSON(ctx, url, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// leadingZeroBits returns the number of leading zero bits of the given hash.
+func leadingZeroBits(h []byte) int {
+	n := 0
+	for _, b := range h {
+		if b != 0 {
+			for b&0x80 == 0 {
+				n++
+				b <<= 1
+			}
+			return n
+		}
+		n += 8
+	}
+	return n
+}
+
+// Check returns true if the given nonce solves the challenge.
+func (c *Challenge) Check(nonce string) bool {
+	h := sha256.Sum256([]byte(c.Challenge + ":" + nonce))
+	return leadingZeroBits(h[:]) >= c.Bits
+}
+
+// Solve returns a nonce that solves the challenge, or the context's error if
+// the context is done first.
+func (c *Challenge) Solve(ctx context.Context) (string, error) {
+	for i := 0; ; i++ {
+		// Checking the context is cheap compared to hashing, but there's
+		// no need to do it for every nonce.
+		if i%1024 == 0 && ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		nonce := strconv.Itoa(i)
+		if c.Check(nonce) {
+			return nonce, nil
+		}
+	}
+}
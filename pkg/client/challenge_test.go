@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLeadingZeroBits(t *testing.T) {
+	for _, test := range []struct {
+		hash     []byte
+		expected int
+	}{
+		{[]byte{0x80, 0x00}, 0},
+		{[]byte{0x01, 0xff}, 7},
+		{[]byte{0x00, 0x10}, 11},
+		{[]byte{0x00, 0x00}, 16},
+	} {
+		if n := leadingZeroBits(test.hash); n != test.expected {
+			t.Fatalf("Expected %d leading zero bits in %x but got %d.", test.expected, test.hash, n)
+		}
+	}
+}
+
+func TestChallengeSolve(t *testing.T) {
+	c := &Challenge{Challenge: "foo", Bits: 12}
+	nonce, err := c.Solve(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if !c.Check(nonce) {
+		t.Fatalf("Expected nonce %q to solve challenge.", nonce)
+	}
+	if (&Challenge{Challenge: "bar", Bits: 12}).Check(nonce) {
+		t.Fatal("Expected nonce not to solve another challenge.")
+	}
+
+	// A challenge that's too hard must not keep us busy forever.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Challenge{Challenge: "foo", Bits: 256}).Solve(ctx); err != context.Canceled {
+		t.Fatalf("Expected error %v but got %v.", context.Canceled, err)
+	}
+}
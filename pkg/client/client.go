@@ -0,0 +1,189 @@
+// Package client implements the client side of the WebSocket measurement flow
+// that the example ZeroTrace server uses.  The server sends periodic pings
+// that the client answers with pongs, which keeps the TCP connection busy
+// while the server runs its 0trace measurement.  Once done, the server sends
+// its result and closes the connection.  All messages are JSON-encoded
+// Message objects.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrSchemaVersion is returned if the server speaks a version of the
+	// measurement protocol that we don't support.
+	ErrSchemaVersion = errors.New("unsupported schema version")
+)
+
+// SchemaVersion is the version of the measurement protocol that this package
+// implements.  Peers only accept their own version.  Version 3 added the
+// result's session ID and the connection, timings, and candidates messages.
+const SchemaVersion = 3
+
+// ServerConfig holds the measurement parameters that a server hands out to
+// its clients, which allows for tuning client behavior server-side.
+type ServerConfig struct {
+	// SchemaVersion is the version of the server's measurement protocol.
+	SchemaVersion int `json:"schema_version"`
+	// WssEndpoint is the WebSocket endpoint that clients connect to.
+	WssEndpoint string `json:"wss_endpoint"`
+	// TimeoutMs determines the number of milliseconds after which clients give
+	// up on a measurement.
+	TimeoutMs int64 `json:"timeout_ms"`
+	// ChallengeEndpoint is set if clients must solve a proof-of-work
+	// challenge from the given URL before they connect to WssEndpoint.
+	ChallengeEndpoint string `json:"challenge_endpoint,omitempty"`
+	// STUNServer is set if browsers should gather WebRTC ICE candidates
+	// with the given STUN server, e.g., "stun:stun.example.com:3478", and
+	// report them to the server.
+	STUNServer string `json:"stun_server,omitempty"`
+	// SessionEndpoint is set if participants can download their
+	// measurement's data by appending the result's session ID to the given
+	// URL.
+	SessionEndpoint string `json:"session_endpoint,omitempty"`
+}
+
+// Timeout returns the configuration's timeout as time.Duration.
+func (c *ServerConfig) Timeout() time.Duration {
+	return time.Duration(c.TimeoutMs) * time.Millisecond
+}
+
+// FetchConfig fetches the server configuration from the given URL, e.g.
+// "https://example.com:8443/config".
+func FetchConfig(ctx context.Context, url string) (*ServerConfig, error) {
+	cfg := &ServerConfig{}
+	if err := getJSON(ctx, url, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.SchemaVersion != SchemaVersion {
+		return nil, fmt.Errorf("%w: got %d, want %d",
+			ErrSchemaVersion, cfg.SchemaVersion, SchemaVersion)
+	}
+	return cfg, nil
+}
+
+// getJSON fetches the given URL and decodes its JSON body into v.
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Result holds the outcome of a measurement as reported by the server.
+type Result struct {
+	// RTT is the round trip time in milliseconds from the server to the client
+	// (or the hop that's closest).
+	RTT float64 `json:"rtt_ms"`
+	// Error is set if the server failed to measure the RTT.
+	Error string `json:"error,omitempty"`
+	// SessionID identifies the measurement's session, if it succeeded.
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// Duration returns the result's RTT as time.Duration.
+func (r *Result) Duration() time.Duration {
+	return time.Duration(r.RTT * float64(time.Millisecond))
+}
+
+// Client runs measurements against a ZeroTrace server.
+type Client struct {
+	endpoint          string
+	challengeEndpoint string
+	dialer            *websocket.Dialer
+}
+
+// New returns a new client for the given WebSocket endpoint, e.g.
+// "wss://example.com:8443/wss".
+func New(endpoint string) *Client {
+	return &Client{
+		endpoint: endpoint,
+		dialer:   websocket.DefaultDialer,
+	}
+}
+
+// WithChallenge makes the client solve a proof-of-work challenge from the given
+// URL before each measurement, as servers that set
+// ServerConfig.ChallengeEndpoint require.  It returns the client.
+func (c *Client) WithChallenge(challengeEndpoint string) *Client {
+	c.challengeEndpoint = challengeEndpoint
+	return c
+}
+
+// Measure runs a single measurement and returns the server's result.  The
+// measurement is aborted when the given context is done.
+func (c *Client) Measure(ctx context.Context) (*Result, error) {
+	endpoint := c.endpoint
+	if c.challengeEndpoint != "" {
+		ch, err := FetchChallenge(ctx, c.challengeEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		nonce, err := ch.Solve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		q.Set("challenge", ch.Challenge)
+		q.Set("nonce", nonce)
+		u.RawQuery = q.Encode()
+		endpoint = u.String()
+	}
+
+	conn, _, err := c.dialer.DialContext(ctx, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Unblock our read loop once the context is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		msg, err := ParseMessage(data)
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Type {
+		case TypeResult:
+			return msg.Result, nil
+		case TypePing:
+			if err := conn.WriteJSON(NewPong(msg)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%w: %q from server", ErrUnknownType, msg.Type)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newServer returns a test server that mimics the example ZeroTrace server: it
+// sends the given number of pings, expects them to be answered, and then sends
+// the given result.
+func newServer(t *testing.T, numPings int, res *Result) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade connection: %v", err)
+			return
+		}
+		defer c.Close()
+
+		for i := 1; i <= numPings; i++ {
+			if err := c.WriteJSON(NewPing(i)); err != nil {
+				t.Errorf("Failed to write ping: %v", err)
+				return
+			}
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				t.Errorf("Failed to read pong: %v", err)
+				return
+			}
+			msg, err := ParseMessage(data)
+			if err != nil || msg.Type != TypePong || msg.Seq != i {
+				t.Errorf("Expected pong %d but got %q (%v).", i, data, err)
+				return
+			}
+		}
+		if res == nil {
+			// Keep the connection open until the client gives up.
+			_, _, _ = c.ReadMessage()
+			return
+		}
+		if err := c.WriteJSON(NewResultMessage(res)); err != nil {
+			t.Errorf("Failed to write result: %v", err)
+		}
+	}))
+}
+
+func wsEndpoint(s *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+func TestMeasure(t *testing.T) {
+	expected := &Result{RTT: 12.5}
+	s := newServer(t, 3, expected)
+	defer s.Close()
+
+	res, err := New(wsEndpoint(s)).Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if *res != *expected {
+		t.Fatalf("Expected result %+v but got %+v.", expected, res)
+	}
+	if res.Duration() != 12500*time.Microsecond {
+		t.Fatalf("Expected duration %s but got %s.", 12500*time.Microsecond, res.Duration())
+	}
+}
+
+func TestMeasureTimeout(t *testing.T) {
+	s := newServer(t, 1, nil)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := New(wsEndpoint(s)).Measure(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected error %v but got %v.", context.DeadlineExceeded, err)
+	}
+}
+
+func TestMeasureChallenge(t *testing.T) {
+	expected := &Result{RTT: 1}
+	ws := newServer(t, 0, expected)
+	defer ws.Close()
+	challenge := &Challenge{Challenge: "foo", Bits: 8}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/challenge" {
+			_ = json.NewEncoder(w).Encode(challenge)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("challenge") != challenge.Challenge || !challenge.Check(q.Get("nonce")) {
+			t.Errorf("Expected solved challenge but got %q.", r.URL.RawQuery)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		ws.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	res, err := New(wsEndpoint(s) + "/wss").WithChallenge(s.URL + "/challenge").Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if *res != *expected {
+		t.Fatalf("Expected result %+v but got %+v.", expected, res)
+	}
+}
+
+func TestFetchConfig(t *testing.T) {
+	expected := ServerConfig{
+		SchemaVersion: SchemaVersion,
+		WssEndpoint:   "wss://example.com/wss",
+		TimeoutMs:     1000,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(expected)
+	}))
+	defer s.Close()
+
+	cfg, err := FetchConfig(context.Background(), s.URL)
+	if err != nil {
+		t.Fatalf("Expected no error but got: %v", err)
+	}
+	if *cfg != expected {
+		t.Fatalf("Expected config %+v but got %+v.", expected, cfg)
+	}
+	if cfg.Timeout() != time.Second {
+		t.Fatalf("Expected timeout %s but got %s.", time.Second, cfg.Timeout())
+	}
+
+	expected.SchemaVersion = SchemaVersion + 1
+	if _, err := FetchConfig(context.Background(), s.URL); !errors.Is(err, ErrSchemaVersion) {
+		t.Fatalf("Expected error %v but got %v.", ErrSchemaVersion, err)
+	}
+}
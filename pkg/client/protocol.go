@@ -0,0 +1,268 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Message types of the measurement protocol.
+const (
+	// TypePing is sent by the server, which expects the client to answer with
+	// a pong that carries the same sequence number.
+	TypePing = "ping"
+	// TypePong is the client's answer to a ping.
+	TypePong = "pong"
+	// TypeResult carries the measurement's result.  It's the last message
+	// that the server sends.
+	TypeResult = "result"
+	// TypeConnection carries the client's view of its network connection.
+	// Clients may send it once, right after connecting.
+	TypeConnection = "connection"
+	// TypeTimings carries the browser's timings of the measurement page's
+	// fetches.  Clients may send it once.
+	TypeTimings = "timings"
+	// TypeCandidates carries the WebRTC ICE candidates that the client
+	// gathered.  Clients may send it once.
+	TypeCandidates = "candidates"
+)
+
+// MaxTimings is the maximum number of timings that a timings message may
+// carry.
+const MaxTimings = 64
+
+// MaxCandidates is the maximum number of ICE candidates that a candidates
+// message may carry.
+const MaxCandidates = 32
+
+// The types of ICE candidates (RFC 8445).
+var candidateTypes = map[string]bool{
+	"host":  true,
+	"srflx": true,
+	"prflx": true,
+	"relay": true,
+}
+
+// The effective connection types of the Network Information API.
+var effectiveTypes = map[string]bool{
+	"slow-2g": true,
+	"2g":      true,
+	"3g":      true,
+	"4g":      true,
+}
+
+var (
+	// ErrUnknownType is returned for messages of unknown type.
+	ErrUnknownType = errors.New("unknown message type")
+	// ErrMissingResult is returned for result messages without a result.
+	ErrMissingResult = errors.New("result message without result")
+	// ErrMissingConnection is returned for connection messages without
+	// connection information.
+	ErrMissingConnection = errors.New("connection message without connection information")
+	// ErrInvalidConnection is returned for connection information with
+	// out-of-range values.
+	ErrInvalidConnection = errors.New("invalid connection information")
+	// ErrMissingTimings is returned for timings messages without timings.
+	ErrMissingTimings = errors.New("timings message without timings")
+	// ErrInvalidTimings is returned for too many timings or timings with
+	// out-of-range values.
+	ErrInvalidTimings = errors.New("invalid timings")
+	// ErrMissingCandidates is returned for candidates messages without
+	// candidates.
+	ErrMissingCandidates = errors.New("candidates message without candidates")
+	// ErrInvalidCandidates is returned for too many candidates or candidates
+	// of unknown type.
+	ErrInvalidCandidates = errors.New("invalid candidates")
+)
+
+// ConnectionInfo is what a browser's Network Information API (i.e.,
+// navigator.connection) reports about the client's connection.  The browser
+// estimates the connection's RTT and bandwidth from recently observed traffic,
+// which gives us the client's own latency estimate to compare against ours.
+type ConnectionInfo struct {
+	// EffectiveType is the connection's effective type, i.e., "slow-2g",
+	// "2g", "3g", or "4g".
+	EffectiveType string `json:"effective_type"`
+	// RTT is the estimated round trip time in milliseconds.
+	RTT float64 `json:"rtt_ms"`
+	// Downlink is the estimated bandwidth in Mbit/s.
+	Downlink float64 `json:"downlink_mbps"`
+	// SaveData is true if the user asked for reduced data usage.
+	SaveData bool `json:"save_data,omitempty"`
+}
+
+// validate returns an error if the connection information is out of range.
+func (c *ConnectionInfo) validate() error {
+	if !effectiveTypes[c.EffectiveType] {
+		return fmt.Errorf("%w: effective type %q", ErrInvalidConnection, c.EffectiveType)
+	}
+	if c.RTT < 0 || c.Downlink < 0 {
+		return fmt.Errorf("%w: negative RTT or downlink", ErrInvalidConnection)
+	}
+	return nil
+}
+
+// Message is the envelope of all messages that the server and client exchange
+// over the WebSocket connection, encoded as JSON.
+type Message struct {
+	// Version is the version of the measurement protocol, i.e.,
+	// SchemaVersion.
+	Version int `json:"version"`
+	// Type is the message's type, e.g., TypePing.
+	Type string `json:"type"`
+	// Seq is the sequence number of pings and pongs.
+	Seq int `json:"seq,omitempty"`
+	// Result is only set for result messages.
+	Result *Result `json:"result,omitempty"`
+	// Connection is only set for connection messages.
+	Connection *ConnectionInfo `json:"connection,omitempty"`
+	// Timings is only set for timings messages.
+	Timings []*Timing `json:"timings,omitempty"`
+	// Candidates is only set for candidates messages.
+	Candidates []*Candidate `json:"candidates,omitempty"`
+}
+
+// NewPing returns a new ping message with the given sequence number.
+func NewPing(seq int) *Message {
+	return &Message{Version: SchemaVersion, Type: TypePing, Seq: seq}
+}
+
+// NewPong returns the pong message that answers the given ping.
+func NewPong(ping *Message) *Message {
+	return &Message{Version: SchemaVersion, Type: TypePong, Seq: ping.Seq}
+}
+
+// NewResultMessage returns a new result message for the given result.
+func NewResultMessage(res *Result) *Message {
+	return &Message{Version: SchemaVersion, Type: TypeResult, Result: res}
+}
+
+// Timing is a browser's Navigation Timing or Resource Timing entry of a fetch,
+// reduced to the phases that we care about.  All durations are in
+// milliseconds, and zero if the phase didn't happen, e.g., because the
+// browser reused a connection.
+type Timing struct {
+	// Name is the fetched URL.
+	Name string `json:"name"`
+	// Type is the entry's type, i.e., "navigation" or "resource".
+	Type string `json:"type"`
+	// DNS is the duration of the DNS lookup.
+	DNS float64 `json:"dns_ms"`
+	// Connect is the duration of the TCP handshake, excluding TLS.
+	Connect float64 `json:"connect_ms"`
+	// TLS is the duration of the TLS handshake.
+	TLS float64 `json:"tls_ms"`
+	// TTFB is the time between sending the request and receiving the first
+	// byte of the response.
+	TTFB float64 `json:"ttfb_ms"`
+}
+
+// validateTimings returns an error if there are too many of the given timings
+// or if any of them is out of range.
+func validateTimings(timings []*Timing) error {
+	if len(timings) > MaxTimings {
+		return fmt.Errorf("%w: more than %d timings", ErrInvalidTimings, MaxTimings)
+	}
+	for _, t := range timings {
+		if t == nil || (t.Type != "navigation" && t.Type != "resource") {
+			return fmt.Errorf("%w: unknown entry type", ErrInvalidTimings)
+		}
+		if t.DNS < 0 || t.Connect < 0 || t.TLS < 0 || t.TTFB < 0 {
+			return fmt.Errorf("%w: negative duration", ErrInvalidTimings)
+		}
+	}
+	return nil
+}
+
+// Candidate is a WebRTC ICE candidate that a browser gathered.
+type Candidate struct {
+	// Type is the candidate's type, i.e., "host", "srflx", "prflx", or
+	// "relay".  Server-reflexive (srflx) candidates carry the client's
+	// address as seen by a STUN server.
+	Type string `json:"type"`
+	// Address is the candidate's address.  Browsers may obfuscate the
+	// addresses of host candidates as mDNS host names.
+	Address string `json:"address"`
+}
+
+// validateCandidates returns an error if there are too many of the given
+// candidates or if any of them is of unknown type.
+func validateCandidates(candidates []*Candidate) error {
+	if len(candidates) > MaxCandidates {
+		return fmt.Errorf("%w: more than %d candidates", ErrInvalidCandidates, MaxCandidates)
+	}
+	for _, c := range candidates {
+		if c == nil || !candidateTypes[c.Type] {
+			return fmt.Errorf("%w: unknown candidate type", ErrInvalidCandidates)
+		}
+	}
+	return nil
+}
+
+// NewConnectionMessage returns a new connection message for the given
+// connection information.
+func NewConnectionMessage(info *ConnectionInfo) *Message {
+	return &Message{Version: SchemaVersion, Type: TypeConnection, Connection: info}
+}
+
+// NewTimingsMessage returns a new timings message for the given timings.
+func NewTimingsMessage(timings []*Timing) *Message {
+	return &Message{Version: SchemaVersion, Type: TypeTimings, Timings: timings}
+}
+
+// NewCandidatesMessage returns a new candidates message for the given ICE
+// candidates.
+func NewCandidatesMessage(candidates []*Candidate) *Message {
+	return &Message{Version: SchemaVersion, Type: TypeCandidates, Candidates: candidates}
+}
+
+// ParseMessage parses and validates the given message.  Messages that contain
+// unknown fields, are of an unknown version or type, or lack a mandatory field
+// are rejected.
+func ParseMessage(data []byte) (*Message, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	m := &Message{}
+	if err := dec.Decode(m); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("trailing data after message")
+	}
+	if m.Version != SchemaVersion {
+		return nil, fmt.Errorf("%w: got %d, want %d",
+			ErrSchemaVersion, m.Version, SchemaVersion)
+	}
+	switch m.Type {
+	case TypePing, TypePong:
+	case TypeResult:
+		if m.Result == nil {
+			return nil, ErrMissingResult
+		}
+	case TypeConnection:
+		if m.Connection == nil {
+			return nil, ErrMissingConnection
+		}
+		if err := m.Connection.validate(); err != nil {
+			return nil, err
+		}
+	case TypeTimings:
+		if len(m.Timings) == 0 {
+			return nil, ErrMissingTimings
+		}
+		if err := validateTimings(m.Timings); err != nil {
+			return nil, err
+		}
+	case TypeCandidates:
+		if len(m.Candidates) == 0 {
+			return nil, ErrMissingCandidates
+		}
+		if err := validateCandidates(m.Candidates); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, m.Type)
+	}
+	return m, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMessage(t *testing.T) {
+	for _, m := range []*Message{
+		NewPing(1),
+		NewPong(NewPing(2)),
+		NewResultMessage(&Result{RTT: 12.5}),
+		NewResultMessage(&Result{Error: "client unresponsive to probes"}),
+		NewConnectionMessage(&ConnectionInfo{EffectiveType: "4g", RTT: 50, Downlink: 10}),
+		NewTimingsMessage([]*Timing{{Name: "https://example.com/", Type: "navigation", Connect: 20}}),
+		NewCandidatesMessage([]*Candidate{{Type: "srflx", Address: "192.0.2.1"}}),
+	} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatalf("Failed to encode message: %v", err)
+		}
+		parsed, err := ParseMessage(data)
+		if err != nil {
+			t.Fatalf("Expected no error for %s but got: %v", data, err)
+		}
+		if parsed.Type != m.Type || parsed.Seq != m.Seq {
+			t.Fatalf("Expected message %+v but got %+v.", m, parsed)
+		}
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  error
+	}{
+		{`{"version":1,"type":"ping","seq":1}`, ErrSchemaVersion},
+		{`{"version":2,"type":"connection","connection":{"effective_type":"4g"}}`, ErrSchemaVersion},
+		{`{"version":3,"type":"hello"}`, ErrUnknownType},
+		{`{"version":3,"type":"result"}`, ErrMissingResult},
+		{`{"version":3,"type":"connection"}`, ErrMissingConnection},
+		{`{"version":3,"type":"connection","connection":{"effective_type":"5g"}}`, ErrInvalidConnection},
+		{`{"version":3,"type":"connection","connection":{"effective_type":"4g","rtt_ms":-1}}`, ErrInvalidConnection},
+		{`{"version":3,"type":"timings","timings":[]}`, ErrMissingTimings},
+		{`{"version":3,"type":"timings","timings":[{"type":"paint"}]}`, ErrInvalidTimings},
+		{`{"version":3,"type":"timings","timings":[{"type":"resource","ttfb_ms":-1}]}`, ErrInvalidTimings},
+		{`{"version":3,"type":"timings","timings":[null]}`, ErrInvalidTimings},
+		{`{"version":3,"type":"candidates"}`, ErrMissingCandidates},
+		{`{"version":3,"type":"candidates","candidates":[{"type":"foo","address":"192.0.2.1"}]}`, ErrInvalidCandidates},
+		{`{"version":3,"type":"pong","seq":1,"rtt_ms":3}`, nil},
+		{`{"version":3,"type":"pong"} {}`, nil},
+		{`ping`, nil},
+	} {
+		_, err := ParseMessage([]byte(test.data))
+		if err == nil {
+			t.Fatalf("Expected error for %s.", test.data)
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Fatalf("Expected error %v for %s but got %v.", test.err, test.data, err)
+		}
+	}
+}
+
+func TestParseMessageTooManyTimings(t *testing.T) {
+	timings := make([]*Timing, MaxTimings+1)
+	for i := range timings {
+		timings[i] = &Timing{Type: "resource"}
+	}
+	data, err := json.Marshal(NewTimingsMessage(timings))
+	if err != nil {
+		t.Fatalf("Failed to encode message: %v", err)
+	}
+	if _, err := ParseMessage(data); !errors.Is(err, ErrInvalidTimings) {
+		t.Fatalf("Expected error %v but got %v.", ErrInvalidTimings, err)
+	}
+}
@@ -0,0 +1,71 @@
+package zerotrace
+
+import (
+	"io"
+	"net"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// replay feeds the packets of the given pcap file into a traceroute's state
+// machine and returns the resulting traceroute toward the given target.  The
+// pcap file must contain our trace packets in addition to the responses, which
+// is the case for the pcap dumps that Trace writes if Config.PcapDir is set.
+// Replaying lets us validate changes to our parser and correlation logic
+// against previously-captured traffic, offline.
+func replay(r io.Reader, target net.IP) (*Result, error) {
+	src, err := pcapgo.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	first := src.LinkType().LayerType()
+	if src.LinkType() == layers.LinkTypeRaw {
+		// Our pcap dumps have no link layer.
+		first = layers.LayerTypeIPv4
+	}
+
+	var (
+		m     = newCaptureManager("replay")
+		pkts  = make(chan *respPkt)
+		state = newTrState(target)
+		res   = &Result{Dst: target}
+	)
+	go func() {
+		m.read(src, newIcmpDecoder(first), pkts)
+		close(pkts)
+	}()
+
+	for p := range pkts {
+		isSegment := p.fromClient || p.outgoing
+		switch {
+		case isSegment && p.recvdFrom.Equal(target):
+			// The client's TCP segment.
+			res.DstPort = p.recvdPort
+			if p.recvdTTL > res.ClientTTL {
+				res.ClientTTL = p.recvdTTL
+			}
+		case isSegment:
+			// One of our trace packets.
+			if res.Start.IsZero() || p.recvd.Before(res.Start) {
+				res.Start = p.recvd
+			}
+			res.Src, res.SrcPort = p.recvdFrom, p.recvdPort
+			state.addTracePkt(&tracePkt{
+				ttl:  p.recvdTTL,
+				ipID: p.ipID,
+				size: p.size,
+				sent: p.recvd,
+			})
+		default:
+			state.addRespPkt(p)
+		}
+	}
+
+	if res.RTT, err = state.calcRTT(); err != nil {
+		return nil, err
+	}
+	res.Hops = state.hops()
+	res.detectTunnel(NewDefaultConfig().TunnelHopDelta)
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package zerotrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of replay tests")
+
+// replayTests maps the pcap files in testdata/ to the address of the client
+// that they trace.  To add a test case, copy a pcap dump (see Config.PcapDir)
+// to testdata/, add it here, and run "go test -run TestReplay -update".
+var replayTests = map[string]string{
+	"synthetic.pcap": dstAddr,
+}
+
+func TestReplay(t *testing.T) {
+	for name, target := range replayTests {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", name))
+			failOnErr(t, err)
+			defer f.Close()
+
+			res, err := replay(f, net.ParseIP(target))
+			failOnErr(t, err)
+			got, err := json.MarshalIndent(res, "", "  ")
+			failOnErr(t, err)
+
+			golden := filepath.Join("testdata", strings.TrimSuffix(name, ".pcap")+".golden")
+			if *update {
+				failOnErr(t, os.WriteFile(golden, got, 0o644))
+			}
+			want, err := os.ReadFile(golden)
+			failOnErr(t, err)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("Replayed result differs from %s:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestReplayUnresponsive(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "synthetic.pcap"))
+	failOnErr(t, err)
+	defer f.Close()
+
+	// If the pcap file contains no trace packets, e.g., because we assume
+	// the wrong target, no responses are correlated.
+	_, err = replay(f, net.ParseIP(srcAddr))
+	assertEqual(t, err, ErrUnresponsive)
+}
+
+func TestReplayBadFile(t *testing.T) {
+	if _, err := replay(strings.NewReader("not a pcap file"), net.ParseIP(dstAddr)); err == nil {
+		t.Fatal("Expected error when replaying a file that isn't a pcap file.")
+	}
+}
@@ -0,0 +1,302 @@
+package zerotrace
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// Result holds the outcome of a 0trace traceroute.
+type Result struct {
+	// SessionID is a random UUID that identifies the call to Trace that
+	// produced the result.  If pcap files are enabled, it's also the name of
+	// the session's pcap file.
+	SessionID string
+	// Start is the wall-clock time at which the traceroute started.
+	Start time.Time
+	// Src and Dst are the addresses of the traced TCP connection's local and
+	// remote end, respectively.
+	Src, Dst net.IP
+	// SrcPort and DstPort are the ports of the traced TCP connection's local
+	// and remote end, respectively.
+	SrcPort, DstPort uint16
+	// RTT is the round trip time to the target or, if the target won't respond
+	// to us, the RTT of the hop that's closest.
+	RTT time.Duration
+	// SelfLatency is our self-latency at the time of the traceroute: the
+	// RTT of a probe that never leaves our box (see
+	// Config.CalibrationInterval), or zero if we didn't measure it.
+	SelfLatency time.Duration
+	// ProbeInterval is the time we waited between trace packets that share a
+	// TTL, not counting Config.ProbeJitter.
+	ProbeInterval time.Duration
+	// ClientTTL is the TTL of the client's TCP segments as we received them,
+	// or zero if we received none.
+	ClientTTL uint8
+	// ClientHops is the client's hop distance as inferred from ClientTTL, or
+	// zero if we don't know it.  Like TracedHops, it counts the routers
+	// between us and the client plus the client itself.
+	ClientHops int
+	// TracedHops is the client's hop distance as determined by the traceroute,
+	// or zero if no hop responded.
+	TracedHops int
+	// Tunneled is true if TracedHops exceeds ClientHops so much that the
+	// client is likely behind an encapsulating tunnel, e.g., a VPN.
+	Tunneled bool
+	// Hops contains one entry per TTL, in increasing order of TTL.
+	Hops []*Hop
+	// Paths contains the path of each traceroute that we ran, i.e., the
+	// address of each hop in increasing order of TTL.  Hops that didn't
+	// respond have a nil address.
+	Paths [][]net.IP
+	// PathStable is true if all traceroutes took the same path, and false if
+	// they didn't.  Hops whose addresses are aliases of the same router (see
+	// Config.Aliases) count as the same.  It's nil if we can't tell, i.e., if
+	// we ran a single traceroute, or if a repeat traceroute failed and the
+	// others agree.
+	PathStable *bool
+}
+
+// subtractSelfLatency subtracts the result's self-latency from its RTT, which
+// never drops below zero.
+func (r *Result) subtractSelfLatency() {
+	r.RTT -= r.SelfLatency
+	if r.RTT < 0 {
+		r.RTT = 0
+	}
+}
+
+// path returns the address of each of the result's hops.
+func (r *Result) path() []net.IP {
+	path := make([]net.IP, len(r.Hops))
+	for i, h := range r.Hops {
+		path[i] = h.Addr
+	}
+	return path
+}
+
+// rateLimited returns true if any of the result's hops rate-limits its ICMP
+// responses.
+func (r *Result) rateLimited() bool {
+	for _, h := range r.Hops {
+		if h.RateLimited {
+			return true
+		}
+	}
+	return false
+}
+
+// isPathStable returns true if the given paths don't contradict each other.
+// We only compare hops that responded in both paths because a hop that didn't
+// respond in one of the paths tells us nothing about a path change.  Neither
+// do two addresses that the given aliases map to the same router.
+func isPathStable(paths [][]net.IP, aliases *Aliases) bool {
+	for i := 1; i < len(paths); i++ {
+		for hop := 0; hop < len(paths[0]) && hop < len(paths[i]); hop++ {
+			a, b := paths[0][hop], paths[i][hop]
+			if a != nil && b != nil && !aliases.SameRouter(a, b) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pathStability returns whether the given paths are stable, or nil if we
+// can't tell because there are fewer than two paths, or because a repeat
+// traceroute failed and we may be missing the path that contradicts the others.
+func pathStability(paths [][]net.IP, aliases *Aliases, repeatFailed bool) *bool {
+	if len(paths) < 2 {
+		return nil
+	}
+	stable := isPathStable(paths, aliases)
+	if stable && repeatFailed {
+		return nil
+	}
+	return &stable
+}
+
+// Hop summarizes the trace packets that we sent with a given TTL.
+type Hop struct {
+	// TTL is the TTL of the hop's trace packets.
+	TTL int
+	// Addr is the address of the router that responded to the hop's trace
+	// packets, or nil if none of the packets were answered.
+	Addr net.IP
+	// Sent is the number of trace packets that we sent with the hop's TTL,
+	// not counting warm-up probes.
+	Sent int
+	// RTTs contains the RTT of each answered trace packet other than warm-up
+	// probes, in the order in which the packets were sent.
+	RTTs []time.Duration
+	// Interfaces contains the interface information (RFC 5837) that the hop's
+	// router included in its ICMP responses, if any.
+	Interfaces []*InterfaceInfo
+	// RateLimited is true if the hop appears to rate-limit its ICMP responses,
+	// which means that its missing responses are not a sign of packet loss.
+	RateLimited bool
+	// IXP is true if the hop's address is in the peering LAN of an Internet
+	// exchange point (see Config.IXPs), which makes the hop a likely handoff
+	// point between two networks.
+	IXP bool
+	// IXPName is the name of the hop's IXP, if we know it.
+	IXPName string
+	// Probes contains all of the hop's trace packets, including warm-up
+	// probes, in the order in which they were sent.
+	Probes []*Probe
+}
+
+// Probe represents a single trace packet and the response to it, if any.
+type Probe struct {
+	// IPID is the IP ID of the trace packet.
+	IPID uint16
+	// Size is the size in bytes of the trace packet, including its IP header.
+	Size int
+	// Sent is the wall-clock time at which the trace packet left, for
+	// correlation with other hosts' logs.
+	Sent time.Time
+	// RTT is the trace packet's RTT, or zero if it wasn't answered.  It's
+	// the difference between the wall-clock capture timestamps of the trace
+	// packet and its response, which is precise but wrong if the system
+	// clock was stepped in between, e.g., by NTP.
+	RTT time.Duration
+	// MonoRTT is the trace packet's RTT according to our monotonic clock,
+	// which isn't affected by clock steps.  We can only read the monotonic
+	// clock in userspace, right after sending the trace packet and right
+	// after reading its response from our capture, so MonoRTT includes our
+	// scheduling and buffering delay.  It's zero if the trace packet wasn't
+	// answered or the result was replayed from a pcap file.
+	MonoRTT time.Duration
+	// From is the address that answered the trace packet, or nil if it wasn't
+	// answered.
+	From net.IP
+	// ICMPType and ICMPCode are the type and code of the ICMP packet that
+	// answered the trace packet.
+	ICMPType, ICMPCode uint8
+	// Interfaces contains the interface information (RFC 5837) that was
+	// included in the ICMP packet, if any.
+	Interfaces []*InterfaceInfo
+	// Warmup is true if the trace packet was a warm-up probe (see
+	// Config.WarmupProbes), whose RTT doesn't count toward the hop's
+	// statistics.
+	Warmup bool
+}
+
+// probeSize returns the size of the result's first trace packet, or zero if
+// the result has no trace packets.
+func (r *Result) probeSize() int {
+	for _, h := range r.Hops {
+		for _, p := range h.Probes {
+			return p.Size
+		}
+	}
+	return 0
+}
+
+// Answered returns true if the probe's trace packet was answered.
+func (p *Probe) Answered() bool {
+	return p.From != nil
+}
+
+// Loss returns the fraction of the hop's trace packets that were lost.  If the
+// hop is rate-limited, we attribute its missing responses to rate limiting
+// rather than packet loss, so the loss is zero.
+func (h *Hop) Loss() float64 {
+	if h.Sent == 0 || h.RateLimited {
+		return 0
+	}
+	return float64(h.Sent-len(h.RTTs)) / float64(h.Sent)
+}
+
+// newHop summarizes the given trace packets, which must share a TTL.  Warm-up
+// probes are part of the hop's probes but not of its statistics.
+func newHop(ttl int, pkts []*tracePkt) *Hop {
+	h := &Hop{TTL: ttl}
+	sort.Slice(pkts, func(i, j int) bool {
+		return pkts[i].sent.Before(pkts[j].sent)
+	})
+	var counted []*tracePkt
+	for _, p := range pkts {
+		probe := &Probe{IPID: p.ipID, Size: int(p.size), Sent: p.sent, Warmup: p.warmup}
+		h.Probes = append(h.Probes, probe)
+		if !p.warmup {
+			counted = append(counted, p)
+		}
+		if !p.isAnswered() {
+			continue
+		}
+		probe.RTT = p.recvd.Sub(p.sent)
+		probe.MonoRTT = p.monoRTT()
+		probe.From = p.recvdFrom
+		probe.ICMPType = p.icmpType
+		probe.ICMPCode = p.icmpCode
+		probe.Interfaces = p.ifInfos
+		if p.warmup {
+			continue
+		}
+		if h.Addr == nil {
+			h.Addr = p.recvdFrom
+		}
+		if h.Interfaces == nil && p.recvdFrom.Equal(h.Addr) {
+			h.Interfaces = p.ifInfos
+		}
+		h.RTTs = append(h.RTTs, p.recvd.Sub(p.sent))
+	}
+	h.Sent = len(counted)
+	h.RateLimited = isRateLimited(counted)
+	return h
+}
+
+// An answered prefix only suggests rate limiting if the hop saw at least
+// minPrefixProbes trace packets, of which at least minPrefixLosses went
+// unanswered.  With fewer, ordinary loss of the last packet looks the same.
+const (
+	minPrefixProbes = 5
+	minPrefixLosses = 2
+)
+
+// isRateLimited returns true if the given trace packets, which must share a
+// TTL and be sorted by the time they were sent, suggest that the hop
+// rate-limits its ICMP responses.  That's the case if the hop answered some
+// but not all of the packets and either
+//  1. only answered the packets that we sent first, which is what a token
+//     bucket does when faced with our burst of trace packets, provided that
+//     there are enough packets to tell a token bucket from random loss, or
+//  2. took increasingly long to answer consecutive packets.
+func isRateLimited(pkts []*tracePkt) bool {
+	var (
+		answered []*tracePkt
+		isPrefix = true
+	)
+	for i, p := range pkts {
+		if !p.isAnswered() {
+			continue
+		}
+		if len(answered) != i {
+			isPrefix = false
+		}
+		answered = append(answered, p)
+	}
+	if len(answered) == 0 || len(answered) == len(pkts) {
+		return false
+	}
+	if isPrefix && len(pkts) >= minPrefixProbes && len(pkts)-len(answered) >= minPrefixLosses {
+		return true
+	}
+	if len(answered) < 3 {
+		return false
+	}
+
+	sort.Slice(answered, func(i, j int) bool {
+		return answered[i].recvd.Before(answered[j].recvd)
+	})
+	prevGap := answered[1].recvd.Sub(answered[0].recvd)
+	for i := 2; i < len(answered); i++ {
+		gap := answered[i].recvd.Sub(answered[i-1].recvd)
+		if gap <= prevGap {
+			return false
+		}
+		prevGap = gap
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// newTracePkts returns trace packets that were sent one millisecond apart.
+// Each element of the given slice determines if the respective packet was
+// answered and, if so, after how many milliseconds.
+func newTracePkts(ttl uint8, rtts []int) []*tracePkt {
+	var (
+		pkts = []*tracePkt{}
+		now  = time.Now().UTC()
+	)
+	for i, rtt := range rtts {
+		p := &tracePkt{
+			ttl:  ttl,
+			ipID: uint16(i),
+			sent: now.Add(time.Duration(i) * time.Millisecond),
+		}
+		if rtt > 0 {
+			p.recvd = p.sent.Add(time.Duration(rtt) * time.Millisecond)
+			p.recvdFrom = dummyAddr
+		}
+		pkts = append(pkts, p)
+	}
+	return pkts
+}
+
+func TestNewHop(t *testing.T) {
+	h := newHop(5, newTracePkts(5, []int{10, 0, 30}))
+
+	assertEqual(t, h.TTL, 5)
+	assertEqual(t, h.Sent, 3)
+	assertEqual(t, len(h.RTTs), 2)
+	assertEqual(t, h.RTTs[0], 10*time.Millisecond)
+	assertEqual(t, h.RTTs[1], 30*time.Millisecond)
+	if !h.Addr.Equal(dummyAddr) {
+		t.Fatalf("Expected hop address %s but got %s.", dummyAddr, h.Addr)
+	}
+	assertEqual(t, h.RateLimited, false)
+	assertEqual(t, h.Loss(), 1.0/3)
+	assertEqual(t, len(h.Probes), 3)
+	for i, answered := range []bool{true, false, true} {
+		assertEqual(t, h.Probes[i].IPID, uint16(i))
+		assertEqual(t, h.Probes[i].Answered(), answered)
+	}
+	assertEqual(t, h.Probes[2].RTT, 30*time.Millisecond)
+
+	// A hop without responses has no address.
+	h = newHop(5, newTracePkts(5, []int{0, 0, 0}))
+	if h.Addr != nil {
+		t.Fatalf("Expected no hop address but got %s.", h.Addr)
+	}
+	assertEqual(t, h.Loss(), 1.0)
+}
+
+func TestNewHopWarmup(t *testing.T) {
+	// The first packet is a slow warm-up probe.
+	pkts := newTracePkts(5, []int{90, 0, 10})
+	pkts[0].warmup = true
+	h := newHop(5, pkts)
+
+	assertEqual(t, h.Sent, 2)
+	assertEqual(t, len(h.RTTs), 1)
+	assertEqual(t, h.RTTs[0], 10*time.Millisecond)
+	assertEqual(t, h.Loss(), 0.5)
+	// The warm-up probe is still part of the hop.
+	assertEqual(t, len(h.Probes), 3)
+	assertEqual(t, h.Probes[0].Warmup, true)
+	assertEqual(t, h.Probes[0].RTT, 90*time.Millisecond)
+}
+
+func TestSubtractSelfLatency(t *testing.T) {
+	r := &Result{RTT: 10 * time.Millisecond, SelfLatency: time.Millisecond}
+	r.subtractSelfLatency()
+	assertEqual(t, r.RTT, 9*time.Millisecond)
+
+	// A self-latency that exceeds the RTT doesn't make it negative.
+	r.SelfLatency = time.Second
+	r.subtractSelfLatency()
+	assertEqual(t, r.RTT, time.Duration(0))
+}
+
+func TestIsRateLimited(t *testing.T) {
+	for _, test := range []struct {
+		rtts        []int
+		rateLimited bool
+	}{
+		{[]int{10, 10, 10}, false}, // All answered.
+		{[]int{0, 0, 0}, false},    // None answered.
+		// Only the first packets were answered, but with so few packets,
+		// that's likely random loss.
+		{[]int{10, 0, 0}, false},
+		{[]int{10, 10, 0}, false},
+		// Only the first of enough packets were answered.
+		{[]int{10, 10, 10, 0, 0}, true},
+		{[]int{10, 0, 0, 0, 0}, true},
+		// A single lost packet is no sign of rate limiting.
+		{[]int{10, 10, 10, 10, 0}, false},
+		{[]int{0, 10, 10}, false}, // The first packet was lost.
+		{[]int{10, 0, 10}, false}, // A packet in the middle was lost.
+		// The gaps between responses grow: 11ms, 22ms, 33ms.
+		{[]int{0, 10, 20, 41, 73}, true},
+		// The gaps between responses are constant.
+		{[]int{0, 10, 10, 10, 10}, false},
+	} {
+		pkts := newTracePkts(1, test.rtts)
+		if isRateLimited(pkts) != test.rateLimited {
+			t.Fatalf("Expected rate limiting to be %v for RTTs %v.",
+				test.rateLimited, test.rtts)
+		}
+	}
+}
+
+func TestRateLimitedLoss(t *testing.T) {
+	h := newHop(5, newTracePkts(5, []int{10, 10, 0, 0, 0}))
+	assertEqual(t, h.RateLimited, true)
+	assertEqual(t, h.Loss(), 0.0)
+}
+
+func TestResultRateLimited(t *testing.T) {
+	r := &Result{Hops: []*Hop{
+		newHop(5, newTracePkts(5, []int{10, 20, 30})),
+		newHop(6, newTracePkts(6, []int{10, 20, 30})),
+	}}
+	assertEqual(t, r.rateLimited(), false)
+
+	r.Hops = append(r.Hops, newHop(7, newTracePkts(7, []int{10, 10, 0, 0, 0})))
+	assertEqual(t, r.rateLimited(), true)
+}
+
+func TestHops(t *testing.T) {
+	s := newTrState(dummyAddr)
+	for _, ttl := range []uint8{3, 1, 2} {
+		for i, p := range newTracePkts(ttl, []int{10, 20}) {
+			p.ipID = uint16(ttl)*10 + uint16(i)
+			p.recvdFrom = net.IPv4(10, 0, 0, ttl)
+			s.addTracePkt(p)
+		}
+	}
+
+	hops := s.hops()
+	assertEqual(t, len(hops), 3)
+	for i, h := range hops {
+		assertEqual(t, h.TTL, i+1)
+		assertEqual(t, h.Sent, 2)
+		if !h.Addr.Equal(net.IPv4(10, 0, 0, byte(i+1))) {
+			t.Fatalf("Unexpected address %s for TTL %d.", h.Addr, h.TTL)
+		}
+	}
+}
+
+func TestIsPathStable(t *testing.T) {
+	var (
+		a = net.ParseIP("10.0.0.1")
+		b = net.ParseIP("10.0.0.2")
+		c = net.ParseIP("10.0.0.3")
+	)
+	for _, test := range []struct {
+		paths  [][]net.IP
+		stable bool
+	}{
+		{[][]net.IP{{a, b}}, true},
+		{[][]net.IP{{a, b}, {a, b}}, true},
+		{[][]net.IP{{a, b}, {a, c}}, false},
+		{[][]net.IP{{a, b}, {a, b}, {c, b}}, false},
+		// Unresponsive hops don't indicate a path change.
+		{[][]net.IP{{a, nil}, {nil, b}}, true},
+		// Neither do paths of different length.
+		{[][]net.IP{{a, b}, {a}}, true},
+	} {
+		if isPathStable(test.paths, nil) != test.stable {
+			t.Fatalf("Expected path stability to be %v for paths %v.",
+				test.stable, test.paths)
+		}
+	}
+
+	// Aliases of the same router don't indicate a path change.
+	aliases := NewAliases(map[string]string{"10.0.0.2": "N1", "10.0.0.3": "N1"})
+	assertEqual(t, isPathStable([][]net.IP{{a, b}, {a, c}}, aliases), true)
+	assertEqual(t, isPathStable([][]net.IP{{a, b}, {c, b}}, aliases), false)
+}
+
+func TestPathStability(t *testing.T) {
+	var (
+		a = net.ParseIP("10.0.0.1")
+		b = net.ParseIP("10.0.0.2")
+	)
+	// A single path tells us nothing about stability.
+	assertEqual(t, pathStability([][]net.IP{{a}}, nil, false) == nil, true)
+	assertEqual(t, *pathStability([][]net.IP{{a}, {a}}, nil, false), true)
+	assertEqual(t, *pathStability([][]net.IP{{a}, {b}}, nil, false), false)
+	// If a repeat failed, agreeing paths may be missing the one that
+	// disagrees, but disagreeing paths are unstable no matter what.
+	assertEqual(t, pathStability([][]net.IP{{a}, {a}}, nil, true) == nil, true)
+	assertEqual(t, *pathStability([][]net.IP{{a}, {b}}, nil, true), false)
+}
+
+func TestResultPath(t *testing.T) {
+	r := &Result{Hops: []*Hop{{Addr: dummyAddr}, {}}}
+	path := r.path()
+	assertEqual(t, len(path), 2)
+	if !path[0].Equal(dummyAddr) || path[1] != nil {
+		t.Fatalf("Unexpected path %v.", path)
+	}
+}
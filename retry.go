@@ -0,0 +1,57 @@
+package zerotrace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// transientErrnos are the errors that we consider transient when creating our
+// raw socket or pcap handle.  EPERM races with capabilities being granted at
+// startup, and the others occur while a network interface flaps.
+var transientErrnos = []syscall.Errno{
+	syscall.EPERM,
+	syscall.EAGAIN,
+	syscall.EBUSY,
+	syscall.ENODEV,
+	syscall.ENXIO,
+	syscall.ENETDOWN,
+}
+
+// isTransient returns true if the given error is likely to go away if we try
+// again.  libpcap reports errors as strings, so we also look for the errno's
+// description in the error's text.
+func isTransient(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) || strings.Contains(msg, errno.Error()) {
+			return true
+		}
+	}
+	// libpcap's error if the interface is down, which it has no errno for.
+	return strings.Contains(msg, "is not up")
+}
+
+// retry runs the given start-up phase until it succeeds, it fails with an
+// error that isn't transient, or we made the given number of attempts.  We
+// wait for the given backoff after the first failed attempt and double the
+// backoff after each subsequent one.  The returned error names the phase.
+func retry(phase string, attempts int, backoff time.Duration, f func() error) error {
+	var err error
+	for i := 1; ; i++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if !isTransient(err) {
+			return fmt.Errorf("%s: %w", phase, err)
+		}
+		if i >= attempts {
+			return fmt.Errorf("%s: giving up after %d attempts: %w", phase, i, err)
+		}
+		l.Printf("Transient error in phase %q (attempt %d of %d): %v", phase, i, attempts, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
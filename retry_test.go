@@ -0,0 +1,58 @@
+package zerotrace
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	for _, test := range []struct {
+		err       error
+		transient bool
+	}{
+		{fmt.Errorf("listen ip4:89: %w", syscall.EPERM), true},
+		{syscall.ENODEV, true},
+		// libpcap's error strings.
+		{errors.New("socket: Operation not permitted"), true},
+		{errors.New("eth0: That device is not up"), true},
+		{errors.New("eth9: No such device exists (SIOCGIFHWADDR: No such device)"), true},
+		{errors.New("invalid BPF filter"), false},
+		{syscall.EINVAL, false},
+	} {
+		assertEqual(t, isTransient(test.err), test.transient)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var attempts int
+	failTwice := func() error {
+		attempts++
+		if attempts <= 2 {
+			return syscall.EBUSY
+		}
+		return nil
+	}
+	failOnErr(t, retry("test", 3, 0, failTwice))
+	assertEqual(t, attempts, 3)
+
+	// We give up after the given number of attempts.
+	attempts = 0
+	err := retry("test", 2, 0, failTwice)
+	if !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("Expected error %v but got %v.", syscall.EBUSY, err)
+	}
+	assertEqual(t, attempts, 2)
+
+	// Permanent errors aren't retried.
+	attempts = 0
+	err = retry("test", 5, 0, func() error {
+		attempts++
+		return syscall.EINVAL
+	})
+	if !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("Expected error %v but got %v.", syscall.EINVAL, err)
+	}
+	assertEqual(t, attempts, 1)
+}
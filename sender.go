@@ -0,0 +1,239 @@
+package zerotrace
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// sendJob instructs a sender to send the given number of probe packets for
+// the given TTL, back to back.  The probe packets cycle through the given
+// payloads, starting at the payload of the given probe.
+type sendJob struct {
+	ttl       int
+	probe     int // The index of the job's first probe among its TTL's probes.
+	numProbes int
+	warmup    bool // The probe packets are warm-up probes.
+	srcAddr   net.IP
+	dstAddr   net.IP
+	payloads  [][]byte
+	out       chan *tracePkt
+	wg        *sync.WaitGroup
+}
+
+// ttlOrder returns the TTLs from start to end (inclusive) in the order in
+// which we send their trace packets: increasing, or random if shuffle is set.
+func ttlOrder(start, end int, shuffle bool) []int {
+	ttls := []int{}
+	for ttl := start; ttl <= end; ttl++ {
+		ttls = append(ttls, ttl)
+	}
+	if shuffle {
+		rand.Shuffle(len(ttls), func(i, j int) {
+			ttls[i], ttls[j] = ttls[j], ttls[i]
+		})
+	}
+	return ttls
+}
+
+// jittered returns the given interval plus a random delay in [0, jitter).
+func jittered(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
+
+// numSenders returns the configured number of senders, but at least one.  A
+// Config that was built as a struct literal, e.g., by code that predates
+// NumSenders, would otherwise have no senders to drain our send queue.
+func (c *Config) numSenders() int {
+	if c.NumSenders < 1 {
+		return 1
+	}
+	return c.NumSenders
+}
+
+// startSenders starts the given number of sender goroutines, which send the
+// trace packets that are enqueued in the send queue.  Having a fixed number of
+// senders bounds the number of goroutines that we spawn, no matter how many
+// traceroutes are running concurrently.
+func (z *ZeroTrace) startSenders(num int) {
+	for i := 0; i < num; i++ {
+		go z.sender()
+	}
+}
+
+// sender takes jobs from the send queue and sends their probe packets until
+// the ZeroTrace object is closed.
+func (z *ZeroTrace) sender() {
+	for {
+		select {
+		case <-z.quit:
+			return
+		case job := <-z.sendQueue:
+			z.sendProbes(job)
+			job.wg.Done()
+		}
+	}
+}
+
+// deadlineTimer returns a channel that receives once the given deadline
+// passes, or nil if the deadline is zero, and a function that stops the timer.
+func deadlineTimer(deadline time.Time) (<-chan time.Time, func()) {
+	if deadline.IsZero() {
+		return nil, func() {}
+	}
+	t := time.NewTimer(time.Until(deadline))
+	return t.C, func() { t.Stop() }
+}
+
+// enqueue adds the given job to the send queue.  It returns errClosed if the
+// ZeroTrace object is closed, in which case no sender would take the job, and
+// errBudget if the given deadline (unless zero) passes before there's room.
+func (z *ZeroTrace) enqueue(job *sendJob, deadline time.Time) error {
+	z.queueMutex.RLock()
+	defer z.queueMutex.RUnlock()
+
+	// Once we're closed or out of time, we must not enqueue, even if there's
+	// room.
+	select {
+	case <-z.quit:
+		return errClosed
+	default:
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return errBudget
+	}
+	expired, stop := deadlineTimer(deadline)
+	defer stop()
+	select {
+	case <-z.quit:
+		return errClosed
+	case <-expired:
+		return errBudget
+	case z.sendQueue <- job:
+		return nil
+	}
+}
+
+// failPendingJobs marks the jobs that are left in the send queue as done
+// without sending their probe packets, so their traceroutes don't wait for
+// them forever.  It must be called after the quit channel is closed.
+func (z *ZeroTrace) failPendingJobs() {
+	// Once we hold the lock, enqueue won't add any more jobs.
+	z.queueMutex.Lock()
+	defer z.queueMutex.Unlock()
+
+	for {
+		select {
+		case job := <-z.sendQueue:
+			job.wg.Done()
+		default:
+			return
+		}
+	}
+}
+
+// sendRounds enqueues the given number of probes for each of the given TTLs,
+// using the given job as template, and waits until they are sent.  If we pace
+// our probes, we send them in rounds of one probe per TTL and wait for the
+// given interval between two rounds.  We wait here rather than in the senders,
+// which are shared by all traceroutes, so a paced traceroute doesn't hold up
+// the others.  Without pacing, we send all probes of a TTL in one job.
+// sendRounds stops sending and returns an error if the ZeroTrace object is
+// closed or the given deadline (unless zero) passes.
+func (z *ZeroTrace) sendRounds(
+	tmpl sendJob,
+	ttls []int,
+	numProbes int,
+	interval time.Duration,
+	deadline time.Time,
+) error {
+	rounds, perRound := 1, numProbes
+	if interval > 0 || z.cfg.ProbeJitter > 0 {
+		rounds, perRound = numProbes, 1
+	}
+	expired, stop := deadlineTimer(deadline)
+	defer stop()
+
+	var jobs sync.WaitGroup
+	for r := 0; r < rounds; r++ {
+		if wait := jittered(interval, z.cfg.ProbeJitter); r > 0 && wait > 0 {
+			select {
+			case <-z.quit:
+				return errClosed
+			case <-expired:
+				return errBudget
+			case <-time.After(wait):
+			}
+		}
+		for _, ttl := range ttls {
+			job := tmpl
+			job.ttl = ttl
+			job.probe = r * perRound
+			job.numProbes = perRound
+			job.wg = &jobs
+			jobs.Add(1)
+			if err := z.enqueue(&job, deadline); err != nil {
+				jobs.Done()
+				jobs.Wait()
+				return err
+			}
+		}
+		jobs.Wait()
+	}
+	return nil
+}
+
+// sendProbes sends the probe packets for the given job.  Once a packet was
+// sent, it's written to the job's output channel.  Senders never wait between
+// probe packets; see sendRounds.
+func (z *ZeroTrace) sendProbes(job *sendJob) {
+	// Send n probe packets for redundancy, in case some get lost.  Each probe
+	// packet shares a TTL but has a unique ID.
+	for n := 0; n < job.numProbes; n++ {
+		payload := job.payloads[(job.probe+n)%len(job.payloads)]
+		hdr := newIpv4Header(job.ttl, 0, job.dstAddr, len(payload))
+		ipID, err := z.ipids.borrow()
+		if err != nil {
+			l.Printf("Error borrowing IPID: %v", err)
+			continue
+		}
+		hdr.ID = int(ipID)
+		if err = z.rawConn.WriteTo(hdr, payload, nil); err != nil {
+			l.Printf("Error sending trace packet: %v", err)
+			continue
+		}
+		now := time.Now()
+		pkt := &tracePkt{
+			ttl:      uint8(job.ttl),
+			ipID:     ipID,
+			size:     uint16(hdr.TotalLen),
+			sent:     now.UTC(),
+			sentMono: now,
+			warmup:   job.warmup,
+		}
+		if z.capture.dumps.Load() > 0 {
+			pkt.raw = rawTracePkt(hdr, job.srcAddr, payload)
+		}
+		job.out <- pkt
+	}
+}
+
+// rawTracePkt returns a copy of the given trace packet for our pcap dumps.  The
+// kernel fills in the source address of the packets that we send, so we set it
+// ourselves in the copy.
+func rawTracePkt(hdr *ipv4.Header, srcAddr net.IP, payload []byte) []byte {
+	h := *hdr
+	h.Src = srcAddr
+	b, err := h.Marshal()
+	if err != nil {
+		l.Printf("Error marshalling trace packet header: %v", err)
+		return nil
+	}
+	return append(b, payload...)
+}
@@ -0,0 +1,110 @@
+package zerotrace
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJittered(t *testing.T) {
+	assertEqual(t, jittered(time.Second, 0), time.Second)
+	for i := 0; i < 100; i++ {
+		d := jittered(time.Second, time.Millisecond)
+		if d < time.Second || d >= time.Second+time.Millisecond {
+			t.Fatalf("Expected interval in [1s, 1.001s) but got %s.", d)
+		}
+	}
+}
+
+func TestTTLOrder(t *testing.T) {
+	if ttls := ttlOrder(5, 8, false); !reflect.DeepEqual(ttls, []int{5, 6, 7, 8}) {
+		t.Fatalf("Expected increasing TTLs but got %v.", ttls)
+	}
+	assertEqual(t, len(ttlOrder(5, 4, false)), 0)
+
+	// A shuffled order still contains each TTL exactly once.
+	ttls := ttlOrder(1, 32, true)
+	sort.Ints(ttls)
+	if !reflect.DeepEqual(ttls, ttlOrder(1, 32, false)) {
+		t.Fatalf("Expected each TTL once but got %v.", ttls)
+	}
+}
+
+func TestNumSenders(t *testing.T) {
+	assertEqual(t, (&Config{}).numSenders(), 1)
+	assertEqual(t, (&Config{NumSenders: -1}).numSenders(), 1)
+	assertEqual(t, NewDefaultConfig().numSenders(), 4)
+}
+
+func TestFailPendingJobs(t *testing.T) {
+	var (
+		z    = NewZeroTrace(&Config{SendQueueSize: -1})
+		jobs sync.WaitGroup
+	)
+	assertEqual(t, cap(z.sendQueue), 0)
+	z = NewZeroTrace(&Config{SendQueueSize: 2})
+	jobs.Add(2)
+	assertEqual(t, z.enqueue(&sendJob{wg: &jobs}, time.Time{}), nil)
+	assertEqual(t, z.enqueue(&sendJob{wg: &jobs}, time.Time{}), nil)
+
+	// Once we're closed, nothing is enqueued, and without senders, the
+	// pending jobs are failed rather than left waiting forever.
+	close(z.quit)
+	assertEqual(t, z.enqueue(&sendJob{wg: &jobs}, time.Time{}), errClosed)
+	z.failPendingJobs()
+	jobs.Wait()
+	assertEqual(t, len(z.sendQueue), 0)
+}
+
+func TestSendRounds(t *testing.T) {
+	z := NewZeroTrace(&Config{SendQueueSize: 8})
+	jobs := make(chan sendJob, 16)
+	go func() {
+		for job := range z.sendQueue {
+			jobs <- *job
+			job.wg.Done()
+		}
+	}()
+	defer close(z.sendQueue)
+
+	// Without pacing, each TTL's probes are sent in one job.
+	assertEqual(t, z.sendRounds(sendJob{}, []int{5, 6}, 3, 0, time.Time{}), nil)
+	for _, ttl := range []int{5, 6} {
+		job := <-jobs
+		assertEqual(t, job.ttl, ttl)
+		assertEqual(t, job.probe, 0)
+		assertEqual(t, job.numProbes, 3)
+	}
+
+	// With pacing, each round has one probe per TTL, and we wait between
+	// rounds rather than the senders.
+	start := time.Now()
+	assertEqual(t, z.sendRounds(sendJob{}, []int{5, 6}, 3, 10*time.Millisecond, time.Time{}), nil)
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("Expected rounds to take at least 20ms but got %s.", d)
+	}
+	for probe := 0; probe < 3; probe++ {
+		for _, ttl := range []int{5, 6} {
+			job := <-jobs
+			assertEqual(t, job.ttl, ttl)
+			assertEqual(t, job.probe, probe)
+			assertEqual(t, job.numProbes, 1)
+		}
+	}
+
+	// Once the deadline passes, we stop sending, even between rounds.
+	deadline := time.Now().Add(75 * time.Millisecond)
+	assertEqual(t, z.sendRounds(sendJob{}, []int{5}, 3, 50*time.Millisecond, deadline), errBudget)
+	assertEqual(t, len(jobs), 2)
+	<-jobs
+	<-jobs
+	assertEqual(t, z.sendRounds(sendJob{}, []int{5}, 3, 0, deadline), errBudget)
+	assertEqual(t, len(jobs), 0)
+
+	// Once we're closed, nothing is sent.
+	close(z.quit)
+	assertEqual(t, z.sendRounds(sendJob{}, []int{5}, 3, 0, time.Time{}), errClosed)
+	assertEqual(t, len(jobs), 0)
+}
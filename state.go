@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"time"
 )
@@ -13,19 +14,55 @@ const (
 	ipidTimeout = time.Second * 10
 )
 
+var (
+	// ErrUnresponsive is returned if none of a traceroute's trace packets
+	// were answered.  Trace gives up on the remaining traceroutes in this
+	// case because they are unlikely to fare better.
+	ErrUnresponsive = errors.New("client unresponsive to probes")
+)
+
 // tracePkts represents a trace packet that we send to the client to determine
-// the network-level RTT.
+// the network-level RTT.  The "sent" and "recvd" fields are wall-clock times,
+// ideally capture timestamps, which are precise but jump if the system clock
+// is stepped.  The "sentMono" and "recvdMono" fields carry readings of our
+// monotonic clock, which never jumps, but we can only take them in userspace.
 type tracePkt struct {
 	ttl       uint8
 	ipID      uint16
+	size      uint16
 	sent      time.Time
 	recvd     time.Time
+	sentMono  time.Time
+	recvdMono time.Time
 	recvdFrom net.IP
+	icmpType  uint8
+	icmpCode  uint8
+	ifInfos   []*InterfaceInfo
+	// warmup is true for warm-up probes, which don't count toward the
+	// traceroute's statistics.
+	warmup bool
+	// The following fields are only set for TCP segments: the client's, or
+	// our own trace packets as we captured them on their way out.
+	fromClient bool
+	outgoing   bool
+	recvdPort  uint16
+	recvdTTL   uint8
+	// sentTo and sentToPort are the destination of our own trace packets,
+	// as we captured them on their way out.
+	sentTo     net.IP
+	sentToPort uint16
+	// raw is a copy of the captured IP packet, which we only keep while a
+	// traceroute dumps its packets to a pcap file.
+	raw []byte
 }
 
 // respPkt represents a packet that we received in response to a trace packet.
-// For simplicity, we re-use the trace packet here; in particular, the "recvd"
-// and "recvdFrom" fields.
+// For simplicity, we re-use the trace packet here; in particular, the "recvd",
+// "recvdFrom", "icmp*", and "ifInfos" fields.  A respPkt may also be one of the
+// client's TCP segments, in which case "fromClient", "recvdPort", and
+// "recvdTTL" are set.  A respPkt may also be one of our own trace packets, as
+// we captured it on its way out, in which case "outgoing", "sentTo", and
+// "sentToPort" are set, and "recvd" is the time at which the packet left.
 type respPkt tracePkt
 
 // isAnswered returns true if the given trace packet has seen a response.
@@ -33,6 +70,16 @@ func (p *tracePkt) isAnswered() bool {
 	return !p.sent.IsZero() && !p.recvd.IsZero()
 }
 
+// monoRTT returns the trace packet's RTT according to our monotonic clock, or
+// zero if we lack a monotonic clock reading, e.g., because we replayed the
+// packet from a pcap file.
+func (p *tracePkt) monoRTT() time.Duration {
+	if p.sentMono.IsZero() || p.recvdMono.IsZero() {
+		return 0
+	}
+	return p.recvdMono.Sub(p.sentMono)
+}
+
 // String implements the Stringer interface.
 func (p *tracePkt) String() string {
 	return fmt.Sprintf("%s (TTL=%d, IP ID=%d)",
@@ -46,16 +93,20 @@ func (p *tracePkt) String() string {
 //  2. Packets that we sent and received as part of the traceroute.
 //  3. The IP IDs that we use as part of the traceroute.
 type trState struct {
-	sync.Mutex // Guard tracePkts.
+	sync.Mutex // Guard tracePkts and departures.
 	dstAddr    net.IP
 	tracePkts  map[uint16]*tracePkt
+	// departures holds our captured trace packets that we captured before
+	// their sender told us about them.
+	departures map[uint16]*respPkt
 }
 
 // newTrState returns a new traceroute state object.
 func newTrState(dstAddr net.IP) *trState {
 	return &trState{
-		dstAddr:   dstAddr,
-		tracePkts: make(map[uint16]*tracePkt),
+		dstAddr:    dstAddr,
+		tracePkts:  make(map[uint16]*tracePkt),
+		departures: make(map[uint16]*respPkt),
 	}
 }
 
@@ -64,22 +115,57 @@ func (s *trState) addTracePkt(p *tracePkt) {
 	s.Lock()
 	defer s.Unlock()
 
+	if d, exists := s.departures[p.ipID]; exists {
+		delete(s.departures, p.ipID)
+		setDeparture(p, d)
+	}
 	s.tracePkts[p.ipID] = p
 }
 
+// setDeparture replaces the given trace packet's send timestamp, which we take
+// after the kernel accepted the packet, with the capture timestamp of the
+// packet on its way out, which doesn't suffer from our scheduling delay.  The
+// IP IDs of concurrent traceroutes' trace packets are unique, but the TTL must
+// match, too, so a genuine segment that happens to reuse the IP ID doesn't
+// count.
+func setDeparture(p *tracePkt, d *respPkt) {
+	if p.ttl == d.recvdTTL {
+		p.sent = d.recvd
+	}
+}
+
+// addDeparture adds to the state map our own trace packet as we captured it on
+// its way out.
+func (s *trState) addDeparture(d *respPkt) {
+	s.Lock()
+	defer s.Unlock()
+
+	if p, exists := s.tracePkts[d.ipID]; exists {
+		setDeparture(p, d)
+		return
+	}
+	s.departures[d.ipID] = d
+}
+
 // AddRespPkt adds to the state map a packet that we got in response to a
-// previously-sent trace packet.
-func (s *trState) addRespPkt(p *respPkt) {
+// previously-sent trace packet.  The function returns false if the packet
+// responds to a trace packet that isn't ours.
+func (s *trState) addRespPkt(p *respPkt) bool {
 	s.Lock()
 	defer s.Unlock()
 
 	tracePkt, exists := s.tracePkts[p.ipID]
 	if !exists {
-		return
+		return false
 	}
 	// Mark the trace packet as "received".
 	tracePkt.recvd = p.recvd
+	tracePkt.recvdMono = p.recvdMono
 	tracePkt.recvdFrom = p.recvdFrom
+	tracePkt.icmpType = p.icmpType
+	tracePkt.icmpCode = p.icmpCode
+	tracePkt.ifInfos = p.ifInfos
+	return true
 }
 
 // isFinished returns true if our state indicates that the 0trace scan is
@@ -116,6 +202,26 @@ func (s *trState) summary() string {
 		len(s.tracePkts), numRcvd)
 }
 
+// hops returns a summary of each TTL for which we sent trace packets, in
+// increasing order of TTL.
+func (s *trState) hops() []*Hop {
+	s.Lock()
+	defer s.Unlock()
+
+	byTTL := make(map[uint8][]*tracePkt)
+	for _, p := range s.tracePkts {
+		byTTL[p.ttl] = append(byTTL[p.ttl], p)
+	}
+	hops := []*Hop{}
+	for ttl, pkts := range byTTL {
+		hops = append(hops, newHop(int(ttl), pkts))
+	}
+	sort.Slice(hops, func(i, j int) bool {
+		return hops[i].TTL < hops[j].TTL
+	})
+	return hops
+}
+
 // calcRTT determines the RTT between us and the client by looking for the
 // trace packet that was answered by the client itself *or* for the trace
 // packet that made it the farthest to the client (i.e., the packet whose TTL
@@ -126,7 +232,7 @@ func (s *trState) calcRTT() (time.Duration, error) {
 
 	var closestPkt *tracePkt
 	for _, p := range s.tracePkts {
-		if !p.isAnswered() {
+		if !p.isAnswered() || p.warmup {
 			continue
 		}
 		if closestPkt == nil {
@@ -157,5 +263,5 @@ func (s *trState) calcRTT() (time.Duration, error) {
 		l.Printf("Closest response packet from: %s", closestPkt)
 		return closestPkt.recvd.Sub(closestPkt.sent), nil
 	}
-	return time.Duration(0), errors.New("no response packets")
+	return time.Duration(0), ErrUnresponsive
 }
@@ -1,7 +1,9 @@
 package zerotrace
 
 import (
+	"io"
 	"net"
+	"os"
 	"testing"
 	"time"
 )
@@ -32,6 +34,22 @@ func TestIsAnswered(t *testing.T) {
 	}
 }
 
+func TestMonoRTT(t *testing.T) {
+	var (
+		now = time.Now()
+		p   = &tracePkt{sent: now.UTC(), sentMono: now}
+	)
+	assertEqual(t, p.monoRTT(), time.Duration(0))
+
+	// Our monotonic readings are immune to the wall clock being stepped
+	// between the trace packet and its response.
+	p.recvd = p.sent.Add(-time.Hour)
+	p.recvdMono = now.Add(20 * time.Millisecond)
+	assertEqual(t, p.monoRTT(), 20*time.Millisecond)
+	h := newHop(1, []*tracePkt{p})
+	assertEqual(t, h.Probes[0].MonoRTT, 20*time.Millisecond)
+}
+
 func TestNewTrState(t *testing.T) {
 	s := newTrState(dummyAddr)
 	if s.tracePkts == nil {
@@ -54,6 +72,32 @@ func TestAddTracePkt(t *testing.T) {
 	}
 }
 
+func TestAddDeparture(t *testing.T) {
+	var (
+		s    = newTrState(dummyAddr)
+		now  = time.Now().UTC()
+		left = now.Add(-time.Millisecond)
+	)
+	// The capture of a trace packet may arrive before or after its sender
+	// reports it.
+	p1 := &tracePkt{ttl: 1, ipID: 1, sent: now}
+	s.addTracePkt(p1)
+	s.addDeparture(&respPkt{outgoing: true, ipID: 1, recvdTTL: 1, recvd: left})
+	assertEqual(t, p1.sent, left)
+
+	s.addDeparture(&respPkt{outgoing: true, ipID: 2, recvdTTL: 2, recvd: left})
+	p2 := &tracePkt{ttl: 2, ipID: 2, sent: now}
+	s.addTracePkt(p2)
+	assertEqual(t, p2.sent, left)
+	assertEqual(t, len(s.departures), 0)
+
+	// A segment that reuses the IP ID but not the TTL isn't ours.
+	p3 := &tracePkt{ttl: 3, ipID: 3, sent: now}
+	s.addTracePkt(p3)
+	s.addDeparture(&respPkt{outgoing: true, ipID: 3, recvdTTL: 64, recvd: left})
+	assertEqual(t, p3.sent, now)
+}
+
 func TestIsFinished(t *testing.T) {
 	s := newTrState(dummyAddr)
 	now := time.Now().UTC()
@@ -176,3 +220,56 @@ func TestCalcRTT(t *testing.T) {
 		t.Fatalf("Expected RTT to be %s but got %s.", expectedRTT, rtt)
 	}
 }
+
+func TestCalcRTTWarmup(t *testing.T) {
+	var (
+		s   = newTrState(dummyAddr)
+		now = time.Now().UTC()
+	)
+	s.addTracePkt(&tracePkt{ttl: 2, ipID: 1, sent: now.Add(-time.Second), recvd: now, warmup: true})
+	// A traceroute whose only answered packet is a warm-up probe is
+	// unresponsive.
+	if _, err := s.calcRTT(); err != ErrUnresponsive {
+		t.Fatalf("Expected error %v but got %v.", ErrUnresponsive, err)
+	}
+	s.addTracePkt(&tracePkt{ttl: 1, ipID: 2, sent: now.Add(-time.Millisecond), recvd: now})
+	rtt, err := s.calcRTT()
+	failOnErr(t, err)
+	assertEqual(t, rtt, time.Millisecond)
+}
+
+func TestCalcRTTUnresponsive(t *testing.T) {
+	s := newTrState(dummyAddr)
+	s.addTracePkt(&tracePkt{ttl: 1, ipID: 1, sent: time.Now().UTC()})
+	if _, err := s.calcRTT(); err != ErrUnresponsive {
+		t.Fatalf("Expected error %v but got %v.", ErrUnresponsive, err)
+	}
+}
+
+func BenchmarkCalcRTT(b *testing.B) {
+	var (
+		s   = newTrState(dummyAddr)
+		now = time.Now().UTC()
+	)
+	// Populate the state with a full traceroute's worth of answered packets.
+	for ipID := 0; ipID < 100; ipID++ {
+		s.addTracePkt(&tracePkt{
+			ttl:       uint8(ipID / 3),
+			ipID:      uint16(ipID),
+			sent:      now.Add(-time.Duration(ipID) * time.Millisecond),
+			recvd:     now,
+			recvdFrom: net.IPv4(10, 0, 0, byte(ipID/3)),
+		})
+	}
+
+	// calcRTT logs the closest packet, which would drown out our results.
+	l.SetOutput(io.Discard)
+	defer l.SetOutput(os.Stderr)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.calcRTT(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package zerotrace
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+)
+
+var (
+	// ErrCaptureDown is returned by Healthy if we lost our pcap handle and
+	// failed to re-open it.
+	ErrCaptureDown = errors.New("capture is down")
+	// ErrCaptureStalled is returned by Healthy if our read loop stalled.
+	ErrCaptureStalled = errors.New("capture is stalled")
+)
+
+// startReading spawns a goroutine that reads packets from the given pcap
+// handle and writes them to the capture manager's packet stream.  The returned
+// channel is closed once the goroutine returns.
+func (m *captureManager) startReading(hdl *pcap.Handle) chan struct{} {
+	done := make(chan struct{})
+	m.heartbeat.Store(time.Now().UnixNano())
+	go func() {
+		defer close(done)
+		m.read(hdl, newIcmpDecoder(hdl.LinkType().LayerType()), m.pkts)
+	}()
+	return done
+}
+
+// isStalled returns true if the read loop hasn't read a packet or hit its
+// buffer timeout for longer than the given timeout.  As long as the pcap
+// handle's buffer timeout is shorter than the stall timeout, the read loop
+// beats even if there are no packets to capture, but not if reads keep
+// failing.
+func (m *captureManager) isStalled(now time.Time, stallTimeout time.Duration) bool {
+	return now.Sub(time.Unix(0, m.heartbeat.Load())) > stallTimeout
+}
+
+// supervise restarts the capture manager's read loop if it stalls or returns
+// before the capture manager is released.  Without a supervisor, a dead read
+// loop would silently result in traceroutes that see no responses.
+func (m *captureManager) supervise(readDone chan struct{}, stallTimeout time.Duration) {
+	ticker := time.NewTicker(stallTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-readDone:
+			l.Printf("Read loop on %s returned unexpectedly.", m.iface)
+		case now := <-ticker.C:
+			if !m.isStalled(now, stallTimeout) {
+				continue
+			}
+			l.Printf("Read loop on %s stalled for more than %s.", m.iface, stallTimeout)
+		}
+		// If the restart failed, we only retry once the stall timeout
+		// expired.
+		readDone = m.restart()
+	}
+}
+
+// restart replaces the capture manager's pcap handle with a new one that has
+// the same BPF filter, and starts a new read loop.  Closing the old handle
+// makes the old read loop return, if it's still around.  If we fail to open a
+// new handle, restart returns nil.
+func (m *captureManager) restart() chan struct{} {
+	m.pcapMu.Lock()
+	defer m.pcapMu.Unlock()
+
+	select {
+	case <-m.quit:
+		// We were released in the meanwhile.
+		return nil
+	default:
+	}
+	m.restarts.Add(1)
+	l.Printf("Restarting capture on %s.", m.iface)
+	if m.pcap != nil {
+		m.pcap.Close()
+		m.pcap = nil
+	}
+	hdl, err := openPcap(m.iface, m.snapLen, m.timeout, m.tsSource, m.filter)
+	if err != nil {
+		l.Printf("Error re-opening pcap handle on %s: %v", m.iface, err)
+		// Pretend that the read loop is alive until the stall timeout
+		// expired again, so we don't retry in a tight loop.
+		m.heartbeat.Store(time.Now().UnixNano())
+		return nil
+	}
+	m.pcap = hdl
+	return m.startReading(hdl)
+}
+
+// CaptureRestarts returns the number of times that the capture of the
+// ZeroTrace object's network interface was restarted because it stalled.
+func (z *ZeroTrace) CaptureRestarts() int64 {
+	return z.capture.restarts.Load()
+}
+
+// health returns an error if the capture manager has no pcap handle, or if its
+// read loop stalled for longer than the given timeout.  A zero timeout
+// disables stall detection.
+func (m *captureManager) health(now time.Time, stallTimeout time.Duration) error {
+	m.pcapMu.Lock()
+	down := m.pcap == nil
+	m.pcapMu.Unlock()
+
+	if down {
+		return ErrCaptureDown
+	}
+	if stallTimeout > 0 && m.isStalled(now, stallTimeout) {
+		return ErrCaptureStalled
+	}
+	return nil
+}
+
+// Healthy returns nil if the ZeroTrace object is able to capture the responses
+// to its trace packets.  Otherwise, it returns ErrCaptureDown or
+// ErrCaptureStalled.  The supervisor tries to recover from both, so a health
+// check that fails for longer than Config.CaptureStallTimeout means that our
+// measurement pipeline is wedged.  The ZeroTrace object must be started.
+func (z *ZeroTrace) Healthy() error {
+	return z.capture.health(time.Now(), z.cfg.CaptureStallTimeout)
+}
@@ -0,0 +1,99 @@
+package zerotrace
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestIsStalled(t *testing.T) {
+	m := newCaptureManager("dummy")
+	now := time.Now()
+	m.heartbeat.Store(now.UnixNano())
+
+	assertEqual(t, m.isStalled(now.Add(time.Second), 2*time.Second), false)
+	assertEqual(t, m.isStalled(now.Add(3*time.Second), 2*time.Second), true)
+}
+
+func TestReadHeartbeat(t *testing.T) {
+	var (
+		m      = newCaptureManager("dummy")
+		pkts   = make(chan *respPkt, 1)
+		before = time.Now()
+	)
+	m.read(&mockSource{pkts: [][]byte{newIcmpPkt(t, dummyAddr, 1)}},
+		newIcmpDecoder(layers.LayerTypeIPv4), pkts)
+	if m.isStalled(before, 0) {
+		t.Fatal("Expected read loop to update its heartbeat.")
+	}
+}
+
+// errSource mocks a pcap handle that fails the given number of reads before
+// it returns io.EOF.
+type errSource struct {
+	errs int
+}
+
+func (s *errSource) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.errs == 0 {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	s.errs--
+	return nil, gopacket.CaptureInfo{}, errors.New("read error")
+}
+
+func TestReadErrors(t *testing.T) {
+	var (
+		m     = newCaptureManager("dummy")
+		pkts  = make(chan *respPkt, 1)
+		start = time.Now()
+	)
+	m.heartbeat.Store(start.UnixNano())
+	m.read(&errSource{errs: 3}, newIcmpDecoder(layers.LayerTypeIPv4), pkts)
+	// We back off after each error instead of spinning...
+	if elapsed := time.Since(start); elapsed < 7*minReadBackoff {
+		t.Fatalf("Expected read loop to back off but it returned after %s.", elapsed)
+	}
+	// ...and failed reads are no sign of life.
+	assertEqual(t, m.heartbeat.Load(), start.UnixNano())
+
+	// Once we're released, we stop waiting.
+	close(m.quit)
+	start = time.Now()
+	m.read(&errSource{errs: 100}, newIcmpDecoder(layers.LayerTypeIPv4), pkts)
+	if elapsed := time.Since(start); elapsed > maxReadBackoff {
+		t.Fatalf("Expected read loop to return once released but it took %s.", elapsed)
+	}
+}
+
+func TestRestartAfterRelease(t *testing.T) {
+	m := newCaptureManager("dummy")
+	close(m.quit)
+
+	// A released capture manager must not be restarted.
+	if m.restart() != nil {
+		t.Fatal("Expected no restart after release.")
+	}
+	assertEqual(t, m.restarts.Load(), int64(0))
+}
+
+func TestCaptureHealth(t *testing.T) {
+	var (
+		m   = newCaptureManager("dummy")
+		now = time.Now()
+	)
+	// Without a pcap handle, we capture nothing.
+	assertEqual(t, m.health(now, time.Second), ErrCaptureDown)
+
+	m.pcap = &pcap.Handle{}
+	m.heartbeat.Store(now.UnixNano())
+	assertEqual(t, m.health(now, time.Second), nil)
+	assertEqual(t, m.health(now.Add(2*time.Second), time.Second), ErrCaptureStalled)
+	// A zero stall timeout disables stall detection.
+	assertEqual(t, m.health(now.Add(2*time.Second), 0), nil)
+}
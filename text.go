@@ -0,0 +1,100 @@
+package zerotrace
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// lookupFunc resolves the given IP address to host names.
+type lookupFunc func(addr string) ([]string, error)
+
+// WriteText writes the result to the given writer in the format of the
+// classic traceroute tool: one line per hop, containing the hop's TTL, the
+// address that answered, and the RTT of each trace packet.  Unanswered trace
+// packets are shown as '*'.  Hops at an IXP are flagged at the end of their
+// line, and results that look tunneled are flagged in a trailing line.  If
+// resolve is true, we look up the host name of each address via reverse DNS.
+func (r *Result) WriteText(w io.Writer, resolve bool) error {
+	var lookup lookupFunc
+	if resolve {
+		lookup = net.LookupAddr
+	}
+	return r.writeText(w, lookup)
+}
+
+// writeText implements WriteText.  If the given lookup function is nil, we
+// don't resolve addresses.
+func (r *Result) writeText(w io.Writer, lookup lookupFunc) error {
+	var (
+		maxTTL int
+		names  = make(map[string]string)
+	)
+	for _, h := range r.Hops {
+		if h.TTL > maxTTL {
+			maxTTL = h.TTL
+		}
+	}
+
+	// formatAddr returns the given address as "name (address)", or only as
+	// "address" if we don't resolve addresses.
+	formatAddr := func(addr net.IP) string {
+		s := addr.String()
+		if lookup == nil {
+			return s
+		}
+		name, exists := names[s]
+		if !exists {
+			name = s
+			if hosts, err := lookup(s); err == nil && len(hosts) > 0 {
+				name = strings.TrimSuffix(hosts[0], ".")
+			}
+			names[s] = name
+		}
+		return fmt.Sprintf("%s (%s)", name, s)
+	}
+
+	if _, err := fmt.Fprintf(w, "traceroute to %s, %d hops max\n",
+		formatAddr(r.Dst), maxTTL); err != nil {
+		return err
+	}
+	for _, h := range r.Hops {
+		var (
+			b    strings.Builder
+			last net.IP
+		)
+		fmt.Fprintf(&b, "%2d ", h.TTL)
+		for _, p := range h.Probes {
+			if !p.Answered() {
+				b.WriteString(" *")
+				continue
+			}
+			// Like traceroute, only print an address if it differs from the
+			// previous probe's.
+			if !p.From.Equal(last) {
+				fmt.Fprintf(&b, " %s", formatAddr(p.From))
+				last = p.From
+			}
+			fmt.Fprintf(&b, "  %.3f ms", float64(p.RTT)/float64(time.Millisecond))
+		}
+		if h.IXP {
+			ixp := h.IXPName
+			if ixp == "" {
+				ixp = "unknown"
+			}
+			fmt.Fprintf(&b, "  [IXP: %s]", ixp)
+		}
+		b.WriteString("\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	if r.Tunneled {
+		_, err := fmt.Fprintf(w, "possible tunnel: client is %d hops away according to its TTL but traced %d hops\n",
+			r.ClientHops, r.TracedHops)
+		return err
+	}
+	return nil
+}
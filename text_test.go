@@ -0,0 +1,75 @@
+package zerotrace
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	var (
+		b   strings.Builder
+		a   = net.ParseIP("10.0.0.1")
+		c   = net.ParseIP("10.0.0.3")
+		dst = net.ParseIP(dstAddr)
+	)
+	r := &Result{
+		Dst: dst,
+		Hops: []*Hop{
+			{TTL: 1, Probes: []*Probe{
+				{RTT: time.Millisecond, From: a},
+				{},
+				{RTT: 1500 * time.Microsecond, From: a},
+			}},
+			{TTL: 2, Probes: []*Probe{{}, {}, {}}},
+			{TTL: 3, Probes: []*Probe{
+				{RTT: 3 * time.Millisecond, From: c},
+				{RTT: 4 * time.Millisecond, From: dst},
+				{RTT: 5 * time.Millisecond, From: dst},
+			}},
+		},
+	}
+
+	failOnErr(t, r.writeText(&b, nil))
+	expected := `traceroute to 10.0.0.2, 3 hops max
+ 1  10.0.0.1  1.000 ms *  1.500 ms
+ 2  * * *
+ 3  10.0.0.3  3.000 ms 10.0.0.2  4.000 ms  5.000 ms
+`
+	assertEqual(t, b.String(), expected)
+
+	// Resolve addresses, except for one that has no host name.
+	b.Reset()
+	lookup := func(addr string) ([]string, error) {
+		if addr == "10.0.0.3" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"host-" + addr + ".example."}, nil
+	}
+	failOnErr(t, r.writeText(&b, lookup))
+	expected = `traceroute to host-10.0.0.2.example (10.0.0.2), 3 hops max
+ 1  host-10.0.0.1.example (10.0.0.1)  1.000 ms *  1.500 ms
+ 2  * * *
+ 3  10.0.0.3 (10.0.0.3)  3.000 ms host-10.0.0.2.example (10.0.0.2)  4.000 ms  5.000 ms
+`
+	assertEqual(t, b.String(), expected)
+
+	// Flag hops at an IXP.
+	b.Reset()
+	r.Hops[0].IXP, r.Hops[0].IXPName = true, "DE-CIX Frankfurt"
+	failOnErr(t, r.writeText(&b, nil))
+	if !strings.Contains(b.String(), " 1  10.0.0.1  1.000 ms *  1.500 ms  [IXP: DE-CIX Frankfurt]\n") {
+		t.Fatalf("Expected IXP hop to be flagged but got:\n%s", b.String())
+	}
+
+	// Flag results that look tunneled.
+	b.Reset()
+	r.Tunneled, r.ClientHops, r.TracedHops = true, 1, 8
+	failOnErr(t, r.writeText(&b, nil))
+	if !strings.HasSuffix(b.String(),
+		"possible tunnel: client is 1 hops away according to its TTL but traced 8 hops\n") {
+		t.Fatalf("Expected tunneled result to be flagged but got:\n%s", b.String())
+	}
+}
@@ -0,0 +1,98 @@
+package zerotrace
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrThrottled is returned by Trace if we traced too many destinations in
+	// the destination's subnet recently.
+	ErrThrottled = errors.New("too many traceroutes toward subnet")
+)
+
+// Prefix lengths of the subnets that we throttle traceroutes to.  A /24 and a
+// /48 are the smallest networks that are typically assigned to a single
+// customer, and routed as a whole.
+const (
+	throttlePrefixV4 = 24
+	throttlePrefixV6 = 48
+)
+
+// subnetThrottle limits the number of traceroutes toward each subnet within a
+// sliding window, so a single network can't make us probe thousands of
+// addresses in its range.  It's safe for concurrent use.  A nil subnetThrottle
+// allows all traceroutes.
+type subnetThrottle struct {
+	sync.Mutex // Guards all fields.
+	limit      int
+	window     time.Duration
+	hits       map[string][]time.Time // Sorted by time.
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// newSubnetThrottle returns a new throttle that allows the given number of
+// traceroutes per subnet within the given window, or nil if the limit or
+// window is zero.
+func newSubnetThrottle(limit int, window time.Duration) *subnetThrottle {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &subnetThrottle{
+		limit:  limit,
+		window: window,
+		hits:   make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// subnetOf returns the subnet that we throttle the given address by.
+func subnetOf(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(throttlePrefixV4, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(throttlePrefixV6, 128)).String()
+}
+
+// expire drops the times that fell out of the window before the given time.
+func (t *subnetThrottle) expire(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) >= t.window {
+		i++
+	}
+	return times[i:]
+}
+
+// allow returns true and records a traceroute if the given address's subnet is
+// below its limit.
+func (t *subnetThrottle) allow(ip net.IP) bool {
+	if t == nil {
+		return true
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	now := t.now()
+	// Forget subnets that were quiet for a window, so we don't accumulate
+	// all subnets that we ever traced.
+	if now.Sub(t.lastSweep) >= t.window {
+		for subnet, times := range t.hits {
+			if len(t.expire(times, now)) == 0 {
+				delete(t.hits, subnet)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	subnet := subnetOf(ip)
+	times := t.expire(t.hits[subnet], now)
+	if len(times) >= t.limit {
+		t.hits[subnet] = times
+		return false
+	}
+	t.hits[subnet] = append(times, now)
+	return true
+}
@@ -0,0 +1,40 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	assertEqual(t, subnetOf(net.ParseIP("192.0.2.123")), "192.0.2.0")
+	assertEqual(t, subnetOf(net.ParseIP("2001:db8:1:2::1")), "2001:db8:1::")
+}
+
+func TestSubnetThrottle(t *testing.T) {
+	var (
+		now = time.Now()
+		th  = newSubnetThrottle(2, time.Minute)
+	)
+	th.now = func() time.Time { return now }
+
+	assertEqual(t, th.allow(net.ParseIP("192.0.2.1")), true)
+	assertEqual(t, th.allow(net.ParseIP("192.0.2.2")), true)
+	// A third address in the same /24 exceeds the limit...
+	assertEqual(t, th.allow(net.ParseIP("192.0.2.3")), false)
+	// ...but other subnets are unaffected.
+	assertEqual(t, th.allow(net.ParseIP("198.51.100.1")), true)
+
+	// Once the first traceroutes fall out of the window, there's room again.
+	now = now.Add(time.Minute)
+	assertEqual(t, th.allow(net.ParseIP("192.0.2.3")), true)
+	// Subnets that were quiet for a window are forgotten.
+	now = now.Add(2 * time.Minute)
+	th.allow(net.ParseIP("203.0.113.1"))
+	assertEqual(t, len(th.hits), 1)
+
+	// A nil throttle allows everything.
+	assertEqual(t, newSubnetThrottle(0, time.Minute) == nil, true)
+	var nilThrottle *subnetThrottle
+	assertEqual(t, nilThrottle.allow(net.ParseIP("192.0.2.1")), true)
+}
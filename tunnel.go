@@ -0,0 +1,53 @@
+package zerotrace
+
+// initialTTLs contains the initial TTLs that common operating systems use,
+// in increasing order.
+var initialTTLs = []int{32, 64, 128, 255}
+
+// hopsFromTTL infers the number of hops that a packet traversed from the TTL
+// that it arrived with, by assuming that the sender used the smallest common
+// initial TTL that's no lower than the given TTL.
+func hopsFromTTL(ttl uint8) int {
+	for _, initial := range initialTTLs {
+		if int(ttl) <= initial {
+			return initial - int(ttl)
+		}
+	}
+	return 0
+}
+
+// tracedHops returns the client's hop distance as determined by the
+// traceroute: the TTL of the farthest hop that responded, plus one if that hop
+// isn't the client itself.  If no hop responded, tracedHops returns zero.
+func (r *Result) tracedHops() int {
+	for i := len(r.Hops) - 1; i >= 0; i-- {
+		h := r.Hops[i]
+		if h.Addr == nil {
+			continue
+		}
+		if h.Addr.Equal(r.Dst) {
+			return h.TTL
+		}
+		return h.TTL + 1
+	}
+	return 0
+}
+
+// detectTunnel sets the result's hop distances and flags the result as
+// tunneled if we traced at least the given delta more hops than the client's
+// TTL suggests.  The two distances should match unless part of the path is an
+// encapsulating tunnel, whose routers don't decrement the TTL of the packets
+// that it carries.  Tracing fewer hops is no sign of a tunnel: it's what
+// happens if the last hops or the client don't respond to us.
+func (r *Result) detectTunnel(delta int) {
+	r.TracedHops = r.tracedHops()
+	if r.ClientTTL != 0 {
+		// hopsFromTTL counts the routers between us and the client, while
+		// the traceroute counts the client as a hop, too.
+		r.ClientHops = hopsFromTTL(r.ClientTTL) + 1
+	}
+	if r.ClientHops == 0 || r.TracedHops == 0 || delta <= 0 {
+		return
+	}
+	r.Tunneled = r.TracedHops-r.ClientHops >= delta
+}
@@ -0,0 +1,77 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHopsFromTTL(t *testing.T) {
+	for _, test := range []struct {
+		ttl  uint8
+		hops int
+	}{
+		{30, 2},
+		{52, 12},
+		{64, 0},
+		{115, 13},
+		{243, 12},
+		{255, 0},
+	} {
+		assertEqual(t, hopsFromTTL(test.ttl), test.hops)
+	}
+}
+
+func TestTracedHops(t *testing.T) {
+	var (
+		router = net.ParseIP("192.168.1.1")
+		r      = &Result{Dst: dummyAddr}
+	)
+	assertEqual(t, r.tracedHops(), 0)
+
+	r.Hops = []*Hop{{TTL: 5, Addr: router}, {TTL: 6}}
+	assertEqual(t, r.tracedHops(), 6)
+
+	r.Hops = append(r.Hops, &Hop{TTL: 7, Addr: dummyAddr})
+	assertEqual(t, r.tracedHops(), 7)
+}
+
+func TestDetectTunnel(t *testing.T) {
+	newResult := func(clientTTL uint8, lastTTL int) *Result {
+		return &Result{
+			Dst:       dummyAddr,
+			ClientTTL: clientTTL,
+			Hops:      []*Hop{{TTL: lastTTL, Addr: dummyAddr}},
+		}
+	}
+
+	// The client is 4 hops away according to its TTL but we traced 14.
+	r := newResult(61, 14)
+	r.detectTunnel(5)
+	assertEqual(t, r.ClientHops, 4)
+	assertEqual(t, r.TracedHops, 14)
+	assertEqual(t, r.Tunneled, true)
+
+	// The client's TTL and the traceroute agree on the client's distance.
+	r = newResult(51, 14)
+	r.detectTunnel(5)
+	assertEqual(t, r.ClientHops, 14)
+	assertEqual(t, r.Tunneled, false)
+	r = newResult(55, 14)
+	r.detectTunnel(5)
+	assertEqual(t, r.Tunneled, false)
+
+	// The traceroute stopped short because the last hops didn't respond.
+	r = newResult(51, 3)
+	r.detectTunnel(5)
+	assertEqual(t, r.Tunneled, false)
+
+	// We didn't see any of the client's TCP segments.
+	r = newResult(0, 14)
+	r.detectTunnel(5)
+	assertEqual(t, r.Tunneled, false)
+
+	// Tunnel detection is disabled.
+	r = newResult(61, 14)
+	r.detectTunnel(0)
+	assertEqual(t, r.Tunneled, false)
+}
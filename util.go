@@ -3,6 +3,7 @@ package zerotrace
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -41,20 +42,58 @@ func extractIPID(ipPkt []byte) (uint16, error) {
 	return binary.BigEndian.Uint16(ipPkt[4:]), nil
 }
 
-// openPcap returns a new pcap handle that listens for ICMP packets.
-func openPcap(iface string, snapLen int32, timeout time.Duration) (*pcap.Handle, error) {
+// openPcap returns a new pcap handle that captures packets matching the given
+// BPF filter.  Unless the given timestamp source is empty, the handle's
+// capture timestamps come from the given source (see Config.TimestampSource).
+func openPcap(
+	iface string,
+	snapLen int32,
+	timeout time.Duration,
+	tsSource string,
+	filter string,
+) (*pcap.Handle, error) {
+	inactive, err := pcap.NewInactiveHandle(iface)
+	if err != nil {
+		return nil, err
+	}
+	defer inactive.CleanUp()
+
 	promiscuous := true
-	pcapHdl, err := pcap.OpenLive(
-		iface,
-		snapLen,
-		promiscuous,
-		timeout,
-	)
+	if err := inactive.SetSnapLen(int(snapLen)); err != nil {
+		return nil, err
+	}
+	if err := inactive.SetPromisc(promiscuous); err != nil {
+		return nil, err
+	}
+	if err := inactive.SetTimeout(timeout); err != nil {
+		return nil, err
+	}
+	if tsSource != "" {
+		if err := setTimestampSource(inactive, tsSource); err != nil {
+			return nil, err
+		}
+	}
+	pcapHdl, err := inactive.Activate()
 	if err != nil {
 		return nil, err
 	}
-	if err = pcapHdl.SetBPFFilter("icmp"); err != nil {
+	if err = pcapHdl.SetBPFFilter(filter); err != nil {
+		pcapHdl.Close()
 		return nil, err
 	}
 	return pcapHdl, nil
 }
+
+// setTimestampSource makes the given inactive pcap handle take its capture
+// timestamps from the given source, e.g., "adapter".
+func setTimestampSource(hdl *pcap.InactiveHandle, tsSource string) error {
+	src, err := pcap.TimestampSourceFromString(tsSource)
+	if err != nil {
+		return err
+	}
+	if err := hdl.SetTimestampSource(src); err != nil {
+		return fmt.Errorf("%w: timestamp source %q (supported: %v)",
+			err, tsSource, hdl.SupportedTimestamps())
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package zerotrace
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// The following types mirror the trace objects that scamper's sc_warts2json
+// emits, so that tools which consume scamper's JSON output can consume our
+// traceroutes.  Fields that we have no data for are omitted.
+
+type wartsTime struct {
+	Sec   int64  `json:"sec"`
+	Usec  int64  `json:"usec"`
+	Ftime string `json:"ftime,omitempty"`
+}
+
+type wartsHop struct {
+	Addr      string    `json:"addr"`
+	ProbeTTL  int       `json:"probe_ttl"`
+	ProbeID   int       `json:"probe_id"`
+	ProbeSize int       `json:"probe_size"`
+	Tx        wartsTime `json:"tx"`
+	RTT       float64   `json:"rtt"`
+	IcmpType  int       `json:"icmp_type"`
+	IcmpCode  int       `json:"icmp_code"`
+	IcmpQTTL  int       `json:"icmp_q_ttl"`
+}
+
+type wartsTrace struct {
+	Type       string     `json:"type"`
+	Version    string     `json:"version"`
+	Method     string     `json:"method"`
+	Src        string     `json:"src"`
+	Dst        string     `json:"dst"`
+	Sport      uint16     `json:"sport"`
+	Dport      uint16     `json:"dport"`
+	StopReason string     `json:"stop_reason"`
+	Start      wartsTime  `json:"start"`
+	HopCount   int        `json:"hop_count"`
+	Attempts   int        `json:"attempts"`
+	FirstHop   int        `json:"firsthop"`
+	ProbeSize  int        `json:"probe_size"`
+	ProbeCount int        `json:"probe_count"`
+	Hops       []wartsHop `json:"hops"`
+}
+
+// newWartsTime converts the given time to scamper's representation.
+func newWartsTime(t time.Time, withFtime bool) wartsTime {
+	wt := wartsTime{
+		Sec:  t.Unix(),
+		Usec: int64(t.Nanosecond() / 1000),
+	}
+	if withFtime {
+		wt.Ftime = t.UTC().Format("2006-01-02 15:04:05")
+	}
+	return wt
+}
+
+// WriteWartsJSON writes the result to the given writer as a single line of
+// JSON in the trace format of scamper's sc_warts2json.  Each answered trace
+// packet is represented as a hop, as is the case in scamper's output.
+func (r *Result) WriteWartsJSON(w io.Writer) error {
+	t := wartsTrace{
+		Type:       "trace",
+		Version:    "0.1",
+		Method:     "tcp-ack",
+		Src:        r.Src.String(),
+		Dst:        r.Dst.String(),
+		Sport:      r.SrcPort,
+		Dport:      r.DstPort,
+		StopReason: "HOPLIMIT",
+		Start:      newWartsTime(r.Start, true),
+		ProbeSize:  r.probeSize(),
+		Hops:       []wartsHop{},
+	}
+	for _, h := range r.Hops {
+		if h.Sent > t.Attempts {
+			t.Attempts = h.Sent
+		}
+		if t.FirstHop == 0 || h.TTL < t.FirstHop {
+			t.FirstHop = h.TTL
+		}
+		t.ProbeCount += h.Sent
+		for i, p := range h.Probes {
+			if !p.Answered() {
+				continue
+			}
+			t.HopCount = h.TTL
+			hop := wartsHop{
+				Addr:      p.From.String(),
+				ProbeTTL:  h.TTL,
+				ProbeID:   i + 1,
+				ProbeSize: p.Size,
+				Tx:        newWartsTime(p.Sent, false),
+				RTT:       float64(p.RTT) / float64(time.Millisecond),
+				IcmpType:  int(p.ICMPType),
+				IcmpCode:  int(p.ICMPCode),
+				IcmpQTTL:  1,
+			}
+			// If the target itself answered, the traceroute is complete.
+			if p.From.Equal(r.Dst) {
+				t.StopReason = "COMPLETED"
+			}
+			t.Hops = append(t.Hops, hop)
+		}
+	}
+
+	return json.NewEncoder(w).Encode(t)
+}
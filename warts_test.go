@@ -0,0 +1,65 @@
+package zerotrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteWartsJSON(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		start = time.Unix(1700000000, 123456000).UTC()
+		hop   = net.ParseIP("10.0.0.1")
+		dst   = net.ParseIP(dstAddr)
+	)
+	r := &Result{
+		Start:   start,
+		Src:     net.ParseIP(srcAddr),
+		Dst:     dst,
+		SrcPort: srcPort,
+		DstPort: dstPort,
+		Hops: []*Hop{
+			{TTL: 1, Sent: 2, Probes: []*Probe{
+				{Sent: start, RTT: time.Millisecond, From: hop, ICMPType: 11},
+				{Sent: start},
+			}},
+			{TTL: 2, Sent: 2, Probes: []*Probe{
+				{Sent: start},
+				{Sent: start, RTT: 2 * time.Millisecond, From: dst, ICMPType: 3, ICMPCode: 3},
+			}},
+		},
+	}
+	failOnErr(t, r.WriteWartsJSON(&buf))
+
+	var trace wartsTrace
+	failOnErr(t, json.Unmarshal(buf.Bytes(), &trace))
+	assertEqual(t, trace.Type, "trace")
+	assertEqual(t, trace.Src, srcAddr)
+	assertEqual(t, trace.Dst, dstAddr)
+	assertEqual(t, trace.Sport, uint16(srcPort))
+	assertEqual(t, trace.Dport, uint16(dstPort))
+	assertEqual(t, trace.StopReason, "COMPLETED")
+	assertEqual(t, trace.Start, wartsTime{
+		Sec:   1700000000,
+		Usec:  123456,
+		Ftime: "2023-11-14 22:13:20",
+	})
+	assertEqual(t, trace.HopCount, 2)
+	assertEqual(t, trace.Attempts, 2)
+	assertEqual(t, trace.FirstHop, 1)
+	assertEqual(t, trace.ProbeCount, 4)
+
+	// Only answered trace packets show up as hops.
+	assertEqual(t, len(trace.Hops), 2)
+	assertEqual(t, trace.Hops[0].Addr, hop.String())
+	assertEqual(t, trace.Hops[0].ProbeTTL, 1)
+	assertEqual(t, trace.Hops[0].ProbeID, 1)
+	assertEqual(t, trace.Hops[0].RTT, 1.0)
+	assertEqual(t, trace.Hops[1].Addr, dstAddr)
+	assertEqual(t, trace.Hops[1].ProbeID, 2)
+	assertEqual(t, trace.Hops[1].IcmpType, 3)
+	assertEqual(t, trace.Hops[1].IcmpCode, 3)
+}
@@ -6,69 +6,123 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	"golang.org/x/net/ipv4"
 )
 
 var (
 	l         = log.New(os.Stderr, "0trace: ", log.Ldate|log.Lmicroseconds|log.LUTC|log.Lshortfile)
 	errNoIcmp = errors.New("not an ICMP packet")
+	errClosed = errors.New("ZeroTrace is closed")
+	errBudget = errors.New("time budget spent")
 )
 
 type receiver chan *respPkt
 
+// respChanSize is the capacity of each traceroute's receiver.  Our trace
+// packets are captured on their way out in bursts, which mustn't crowd out the
+// responses to them.
+const respChanSize = 64
+
+// Tracer runs traceroutes toward the remote end of TCP connections.  ZeroTrace
+// implements Tracer; code that depends on the interface rather than on
+// ZeroTrace can be tested with a fake Tracer, without root privileges or
+// network access.
+type Tracer interface {
+	Trace(conn net.Conn) (*Result, error)
+	TraceAddr(addr string) (*Result, error)
+}
+
+var _ Tracer = (*ZeroTrace)(nil)
+
 // ZeroTrace implements the 0trace traceroute technique:
 // https://seclists.org/fulldisclosure/2007/Jan/145
 type ZeroTrace struct {
-	cfg                *Config
-	quit               chan struct{}
-	incoming, outgoing chan receiver
-	rawConn            *ipv4.RawConn
-	ipids              *ipIdPool
-	pcap               *pcap.Handle
+	cfg       *Config
+	quit      chan struct{}
+	sendQueue chan *sendJob
+	rawConn   *ipv4.RawConn
+	ipids     *ipIdPool
+	capture   *captureManager
+	active    atomic.Int32  // The number of calls to Trace in progress.
+	slots     chan struct{} // Limits concurrent calls to Trace, unless nil.
+	throttle  *subnetThrottle
+	backoff   *probeBackoff
+	// selfLatency is our most recently measured self-latency, in
+	// nanoseconds.
+	selfLatency atomic.Int64
+	// queueMutex keeps jobs from being enqueued while we fail the pending
+	// ones, once we're closed.
+	queueMutex sync.RWMutex
 }
 
 // NewZeroTrace returns a new ZeroTrace object that uses the given
 // configuration.
 func NewZeroTrace(c *Config) *ZeroTrace {
-	return &ZeroTrace{
-		cfg:      c,
-		incoming: make(chan receiver),
-		outgoing: make(chan receiver),
-		quit:     make(chan struct{}),
-		ipids:    newIpIdPool(),
+	z := &ZeroTrace{
+		cfg:       c,
+		quit:      make(chan struct{}),
+		sendQueue: make(chan *sendJob, max(c.SendQueueSize, 0)),
+		throttle:  newSubnetThrottle(c.SubnetLimit, c.SubnetWindow),
+		backoff:   newProbeBackoff(c.MaxProbeInterval),
+	}
+	if c.MaxTraces > 0 {
+		z.slots = make(chan struct{}, c.MaxTraces)
 	}
+	return z
 }
 
 // Start starts the ZeroTrace object.  This function instructs ZeroTrace to
-// start its event loop and to begin capturing network packets.
+// begin capturing network packets.  ZeroTrace objects that use the same
+// network interface share a single pcap handle.  Start retries creating the
+// raw socket and pcap handle if doing so fails with a transient error, and
+// fails if the configured payload sizes are invalid.
 func (z *ZeroTrace) Start() error {
-	var err error
-	z.rawConn, err = createRawIpConn()
+	if err := z.cfg.validatePayloadSizes(); err != nil {
+		return err
+	}
+	err := retry("creating raw socket", z.cfg.StartAttempts, z.cfg.StartBackoff,
+		func() (err error) {
+			z.rawConn, err = createRawIpConn()
+			return err
+		})
 	if err != nil {
 		return err
 	}
 
-	z.pcap, err = openPcap(z.cfg.Interface, z.cfg.SnapLen, z.cfg.PktBufTimeout)
+	err = retry("opening pcap handle", z.cfg.StartAttempts, z.cfg.StartBackoff,
+		func() (err error) {
+			z.capture, err = acquireCaptureManager(
+				z.cfg.Interface,
+				z.cfg.SnapLen,
+				z.cfg.PktBufTimeout,
+				z.cfg.TimestampSource,
+				z.cfg.CaptureStallTimeout,
+			)
+			return err
+		})
 	if err != nil {
+		_ = z.rawConn.Close()
 		return err
 	}
-	go z.listen(gopacket.NewPacketSource(
-		z.pcap,
-		z.pcap.LinkType(),
-	).Packets())
+	// IP IDs must be unique across all traceroutes that share an interface.
+	z.ipids = z.capture.ipids
+	z.startSenders(z.cfg.numSenders())
+	if z.cfg.CalibrationInterval > 0 {
+		go z.calibrate(z.cfg.CalibrationInterval)
+	}
 
 	return nil
 }
 
-// Close closes the ZeroTrace object.
+// Close closes the ZeroTrace object.  Trace packets that are still waiting
+// for a sender are never sent.
 func (z *ZeroTrace) Close() {
-	z.pcap.Close()
 	close(z.quit)
+	z.failPendingJobs()
+	z.capture.release()
 }
 
 // CalcRTT starts a new 0trace traceroute and returns the RTT to the target
@@ -77,160 +131,274 @@ func (z *ZeroTrace) Close() {
 // target.  Note that the TCP connection may be corrupted as part of the 0trace
 // measurement.
 func (z *ZeroTrace) CalcRTT(conn net.Conn) (time.Duration, error) {
+	res, err := z.Trace(conn)
+	if err != nil {
+		return 0, err
+	}
+	return res.RTT, nil
+}
+
+// Trace is like CalcRTT but returns the traceroute's full result, including a
+// summary of each hop.  Trace runs as many traceroutes as configured, one after
+// another, and reports whether they all took the same path.  If a repeat
+// traceroute fails, Trace returns what it has, with path stability unknown.  If
+// a traceroute encounters hops that rate-limit their ICMP responses, the next
+// traceroute paces its trace packets more slowly, and so do the next calls to
+// Trace toward the same destination for a while.  The RTT and hops in the
+// result are those of the first traceroute.  If configured, the captured
+// packets of all traceroutes are written to a pcap file named after the
+// result's session ID.  If the configured time budget runs out, Trace skips the
+// remaining traceroutes.  Trace returns ErrBlocked if the connection's remote
+// end is on the configured blocklist, ErrThrottled if we traced too many
+// destinations in its subnet recently, and ErrBusy if too many calls to Trace
+// are running already.
+func (z *ZeroTrace) Trace(conn net.Conn) (*Result, error) {
+	z.active.Add(1)
+	defer z.active.Add(-1)
+
+	var (
+		res          *Result
+		repeatFailed bool
+		deadline     time.Time
+	)
+	if z.cfg.TraceBudget > 0 {
+		deadline = time.Now().UTC().Add(z.cfg.TraceBudget)
+	}
+	dstAddr, err := extractRemoteIP(conn)
+	if err != nil {
+		return nil, err
+	}
+	if z.cfg.Blocklist.Contains(dstAddr) {
+		l.Printf("Not tracing blocklisted destination %s.", dstAddr)
+		return nil, ErrBlocked
+	}
+	if !z.throttle.allow(dstAddr) {
+		l.Printf("Not tracing %s: %v", dstAddr, ErrThrottled)
+		return nil, ErrThrottled
+	}
+	if err := z.acquireSlot(); err != nil {
+		l.Printf("Not tracing %s: %v", dstAddr, err)
+		return nil, err
+	}
+	defer z.releaseSlot()
+	interval := z.backoff.interval(dstAddr, z.cfg.ProbeInterval)
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	dump := z.openDump(sessionID)
+	defer z.closeDump(dump)
+
+	for i := 0; i == 0 || i < z.cfg.NumTraces; i++ {
+		if i > 0 && !deadline.IsZero() && time.Now().UTC().After(deadline) {
+			l.Printf("Time budget spent after %d of %d traceroutes.", i, z.cfg.NumTraces)
+			break
+		}
+		r, err := z.trace(conn, interval, dump, deadline)
+		if err != nil && res == nil {
+			return nil, err
+		}
+		if err != nil {
+			// E.g., hops rate-limit the repeat's ICMP responses, which
+			// doesn't invalidate the traceroutes that succeeded.
+			l.Printf("Repeat traceroute %d of %d failed: %v", i+1, z.cfg.NumTraces, err)
+			repeatFailed = true
+			break
+		}
+		if res == nil {
+			res = r
+			res.SessionID = sessionID
+		}
+		res.Paths = append(res.Paths, r.path())
+		if r.rateLimited() {
+			interval = z.cfg.backoffProbeInterval(interval)
+			z.backoff.remember(dstAddr, interval)
+		}
+	}
+	res.PathStable = pathStability(res.Paths, z.cfg.Aliases, repeatFailed)
+	return res, nil
+}
+
+// TraceAddr establishes a TCP connection to the given address (in host:port
+// form) and uses it to run Trace.  This allows for tracing arbitrary
+// destinations that accept TCP connections, and not just the peers of
+// connections that we accepted.
+func (z *ZeroTrace) TraceAddr(addr string) (*Result, error) {
+	// Don't dial blocklisted addresses.  Host names are checked by Trace once
+	// they are resolved.
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && z.cfg.Blocklist.Contains(ip) {
+			return nil, ErrBlocked
+		}
+	}
+	conn, err := net.DialTimeout("tcp4", addr, z.cfg.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return z.Trace(conn)
+}
+
+// trace runs a single 0trace traceroute over the given net.Conn, waiting for
+// the given interval between trace packets that share a TTL.  The traceroute's
+// packets are written to the given pcap dump, which may be nil.  At the given
+// deadline, unless it's zero, we stop sending trace packets and waiting for
+// responses.
+func (z *ZeroTrace) trace(
+	conn net.Conn,
+	interval time.Duration,
+	dump *pcapDump,
+	deadline time.Time,
+) (*Result, error) {
 	var (
 		state     *trState
-		wg        sync.WaitGroup
+		clientTTL uint8
+		sent      = make(chan struct{})
 		ticker    = time.NewTicker(250 * time.Millisecond)
-		respChan  = make(chan *respPkt, 1)
+		respChan  = make(chan *respPkt, respChanSize)
 		traceChan = make(chan *tracePkt, 1)
+		faults    = newFaultInjector(z.cfg.Faults)
 	)
+	defer ticker.Stop()
 	defer close(respChan)
 	defer close(traceChan)
 
-	remoteIP, err := extractRemoteIP(conn)
+	f, err := extractFlow(conn)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	state = newTrState(remoteIP)
+	state = newTrState(f.dstIP)
+	start := time.Now().UTC()
 
 	// Register for receiving a copy of newly-captured ICMP responses.
-	z.incoming <- respChan
-	defer func() { z.outgoing <- respChan }()
+	z.capture.register(respChan, f)
+	defer z.capture.unregister(respChan)
 
 	// Spawn goroutine that sends trace packets.
-	wg.Add(1)
-	go z.sendTracePkts(traceChan, conn, &wg)
+	go z.sendTracePkts(traceChan, conn, sent, interval, deadline)
 
 	for {
 		select {
 		case tracePkt := <-traceChan:
 			state.addTracePkt(tracePkt) // Sent new trace packet.
+			dump.write(tracePkt.raw, tracePkt.sent)
 		case respPkt := <-respChan:
-			state.addRespPkt(respPkt) // Received new response packet.
+			if respPkt.outgoing {
+				// Captured one of our trace packets on its way out.  We
+				// already dumped our own copy of it.
+				state.addDeparture(respPkt)
+				continue
+			}
+			if respPkt.fromClient {
+				// Received the client's TCP segment.  We keep the highest TTL
+				// because it belongs to the shortest path.
+				if respPkt.recvdTTL > clientTTL {
+					clientTTL = respPkt.recvdTTL
+				}
+				dump.write(respPkt.raw, respPkt.recvd)
+				continue
+			}
+			// Received new response packet.
+			addRespPkts(state, dump, faults.inject(respPkt))
+		case respPkt := <-faults.delayedPkts():
+			addRespPkts(state, dump, faults.reorder(respPkt))
+		case <-sent:
+			sent = nil // All trace packets are sent.
 		case <-ticker.C:
-			wg.Wait()
-			if state.isFinished() {
-				return state.calcRTT()
+			addRespPkts(state, dump, faults.flush())
+			expired := !deadline.IsZero() && time.Now().UTC().After(deadline)
+			if sent == nil && (state.isFinished() || expired) {
+				rtt, err := state.calcRTT()
+				if err != nil {
+					return nil, err
+				}
+				res := &Result{
+					Start:         start,
+					Src:           f.srcIP,
+					Dst:           f.dstIP,
+					SrcPort:       f.srcPort,
+					DstPort:       f.dstPort,
+					RTT:           rtt,
+					ProbeInterval: interval,
+					ClientTTL:     clientTTL,
+					SelfLatency:   z.SelfLatency(),
+					Hops:          state.hops(),
+				}
+				if z.cfg.SubtractSelfLatency {
+					res.subtractSelfLatency()
+				}
+				res.detectTunnel(z.cfg.TunnelHopDelta)
+				res.tagIXPs(z.cfg.IXPs)
+				return res, nil
 			}
 		}
 	}
 }
 
-// sendTracePkts sends a burst of trace packets to our target.  Once a packet
-// was sent, it's written to the given channel.
+// addRespPkts adds the given response packets to the given traceroute state,
+// and writes those that it accepts to the given pcap dump.
+func addRespPkts(state *trState, dump *pcapDump, pkts []*respPkt) {
+	for _, p := range pkts {
+		if state.addRespPkt(p) {
+			dump.write(p.raw, p.recvd)
+		}
+	}
+}
+
+// sendTracePkts enqueues a burst of trace packets to our target and waits until
+// they are sent, waiting for the given interval between trace packets that
+// share a TTL.  Once a packet was sent, it's written to the given channel.  We
+// don't send the remaining trace packets once the given deadline passes,
+// unless it's zero.  The function closes the given "sent" channel when it's
+// done.
 func (z *ZeroTrace) sendTracePkts(
 	c chan *tracePkt,
 	conn net.Conn,
-	wg *sync.WaitGroup,
+	sent chan struct{},
+	interval time.Duration,
+	deadline time.Time,
 ) {
-	defer wg.Done()
+	defer close(sent)
 
-	dstAddr, err := extractRemoteIP(conn)
+	f, err := extractFlow(conn)
 	if err != nil {
-		l.Printf("Error extracting remote IP address from connection: %v", err)
+		l.Printf("Error extracting flow from connection: %v", err)
 		return
 	}
-	pktPayload, err := createPkt(conn)
-	if err != nil {
-		l.Printf("Error creating trace packet payload: %v", err)
-		return
+	payloads := [][]byte{}
+	for _, size := range z.cfg.payloadSizes() {
+		payload, err := createPkt(conn, size)
+		if err != nil {
+			l.Printf("Error creating trace packet payload: %v", err)
+			return
+		}
+		payloads = append(payloads, payload)
 	}
-
-	start := time.Now().UTC()
-	defer func() {
-		diff := time.Now().UTC().Sub(start)
-		l.Printf("Sent trace packets in: %v", diff)
-	}()
-	for ttl := z.cfg.TTLStart; ttl <= z.cfg.TTLEnd; ttl++ {
-		// Parallelize the sending of trace packets.
-		go func(ttl int) {
-			hdr := newIpv4Header(ttl, 0, dstAddr, len(pktPayload))
-			// Send n probe packets for redundancy, in case some get lost.
-			// Each probe packet shares a TTL but has a unique ID.
-			for n := 0; n < z.cfg.NumProbes; n++ {
-				ipID, err := z.ipids.borrow()
-				if err != nil {
-					l.Printf("Error borrowing IPID: %v", err)
-					continue
-				}
-				hdr.ID = int(ipID)
-				if err = z.rawConn.WriteTo(hdr, pktPayload, nil); err != nil {
-					l.Printf("Error sending trace packet: %v", err)
-					continue
-				}
-				c <- &tracePkt{
-					ttl:  uint8(ttl),
-					ipID: ipID,
-					sent: time.Now().UTC(),
-				}
-			}
-		}(ttl)
+	tmpl := sendJob{
+		srcAddr:  f.srcIP,
+		dstAddr:  f.dstIP,
+		payloads: payloads,
+		out:      c,
 	}
-}
-
-// listen opens a pcap handle and begins listening for incoming ICMP packets.
-// New traceroutes register themselves with this function's event loop to
-// receive a copy of newly-captured ICMP packets.
-func (z *ZeroTrace) listen(pktStream chan gopacket.Packet) {
-	var (
-		ticker    = time.NewTicker(3 * time.Second)
-		receivers = make(map[receiver]bool)
-	)
 
-	l.Println("Starting listening loop.")
-	defer l.Println("Leaving listening loop.")
-	for {
-		select {
-		case <-z.quit:
+	start := time.Now().UTC()
+	// Warm-up probes must leave before all other probes, so we wait for them
+	// to be sent.
+	if z.cfg.WarmupProbes > 0 {
+		warmup := tmpl
+		warmup.warmup = true
+		err := z.sendRounds(warmup, []int{z.cfg.TTLStart}, z.cfg.WarmupProbes, interval, deadline)
+		if err != nil {
+			l.Printf("Not sending trace packets: %v", err)
 			return
-		case <-ticker.C:
-			z.ipids.releaseUnanswered()
-		case r := <-z.incoming:
-			receivers[r] = true
-		case r := <-z.outgoing:
-			delete(receivers, r)
-		case pkt := <-pktStream:
-			respPkt, err := z.parseIcmpPkt(pkt)
-			if err != nil {
-				l.Printf("Error parsing ICMP packet: %v", err)
-				continue
-			}
-			z.ipids.release(respPkt.ipID)
-			// Fan-out new packet to all running traceroutes.
-			for r := range receivers {
-				// A receiver's channel may be full if the receiver is done with
-				// the scan and has already exited its event loop.
-				if len(r) == 0 {
-					r <- respPkt
-				}
-			}
 		}
 	}
-}
-
-// parseIcmpPkt extracts what we need (IP ID, timestamp, address) from the
-// given ICMP packet.
-func (z *ZeroTrace) parseIcmpPkt(packet gopacket.Packet) (*respPkt, error) {
-	if packet == nil {
-		return nil, errNoIcmp
-	}
-	ipv4Layer := packet.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
-	icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
-	if ipv4Layer == nil || icmpLayer == nil {
-		return nil, errNoIcmp
+	ttls := ttlOrder(z.cfg.TTLStart, z.cfg.TTLEnd, z.cfg.ShuffleTTLs)
+	if err := z.sendRounds(tmpl, ttls, z.cfg.NumProbes, interval, deadline); err != nil {
+		l.Printf("Not sending remaining trace packets: %v", err)
 	}
-	icmpPkt, _ := icmpLayer.(*layers.ICMPv4)
-
-	ipID, err := extractIPID(icmpPkt.LayerPayload())
-	if err != nil {
-		return nil, err
-	}
-
-	// We're not interested in the response packet's TTL because by definition,
-	// it's always going to be 1.
-	return &respPkt{
-		ipID:      ipID,
-		recvd:     packet.Metadata().Timestamp,
-		recvdFrom: ipv4Layer.SrcIP,
-	}, nil
+	l.Printf("Sent trace packets in: %v (send queue length: %d)",
+		time.Now().UTC().Sub(start), len(z.sendQueue))
 }
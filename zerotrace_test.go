@@ -0,0 +1,20 @@
+package zerotrace
+
+import (
+	"net"
+	"testing"
+)
+
+func TestTraceAddrUnreachable(t *testing.T) {
+	// Grab a free port and close the listener, so nothing accepts connections
+	// on the port.
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	failOnErr(t, err)
+	addr := ln.Addr().String()
+	failOnErr(t, ln.Close())
+
+	z := NewZeroTrace(NewDefaultConfig())
+	if _, err := z.TraceAddr(addr); err == nil {
+		t.Fatal("Expected error when tracing unreachable address.")
+	}
+}